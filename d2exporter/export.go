@@ -320,6 +320,13 @@ func toConnection(edge *d2graph.Edge, theme *d2themes.Theme) d2target.Connection
 		connection.Fill = edge.Style.Fill.Value
 	}
 
+	if edge.StrokeGradient != nil {
+		connection.StrokeGradient = &d2target.Gradient{
+			Start: edge.StrokeGradient.Start,
+			End:   edge.StrokeGradient.End,
+		}
+	}
+
 	connection.FontSize = text.FontSize
 	if edge.Style.FontSize != nil {
 		connection.FontSize, _ = strconv.Atoi(edge.Style.FontSize.Value)
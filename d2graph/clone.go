@@ -0,0 +1,214 @@
+package d2graph
+
+import (
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// Clone returns a deep copy of the graph, e.g. to lay out variations of a diagram without changing it.
+// Objects, edges, decorations and nested boards are copied with their boxes, routes and attributes.
+// The ASTs are shared since layouts do not change them
+func (g *Graph) Clone() *Graph {
+	return g.clone(g.Parent)
+}
+
+func (g *Graph) clone(parent *Graph) *Graph {
+	c := *g
+	c.Parent = parent
+
+	objects := make(map[*Object]*Object)
+	c.Root = g.Root.clone(&c)
+	objects[g.Root] = c.Root
+	c.Objects = make([]*Object, 0, len(g.Objects))
+	for _, obj := range g.Objects {
+		objects[obj] = obj.clone(&c)
+		c.Objects = append(c.Objects, objects[obj])
+	}
+	remap := func(obj *Object) *Object {
+		if clone, ok := objects[obj]; ok {
+			return clone
+		}
+		return obj
+	}
+	// edges can end on objects that are not in the graph, like sequence diagram lifeline ends
+	remapEndpoint := func(obj *Object) *Object {
+		if _, ok := objects[obj]; !ok && obj != nil {
+			objects[obj] = obj.clone(obj.Graph)
+		}
+		return objects[obj]
+	}
+	for _, obj := range append([]*Object{g.Root}, g.Objects...) {
+		clone := objects[obj]
+		clone.Parent = remap(obj.Parent)
+		clone.Children = make(map[string]*Object, len(obj.Children))
+		for id, child := range obj.Children {
+			clone.Children[id] = remap(child)
+		}
+		clone.ChildrenArray = make([]*Object, 0, len(obj.ChildrenArray))
+		for _, child := range obj.ChildrenArray {
+			clone.ChildrenArray = append(clone.ChildrenArray, remap(child))
+		}
+		for i := range clone.References {
+			clone.References[i].ScopeObj = remap(clone.References[i].ScopeObj)
+		}
+	}
+
+	edges := make(map[*Edge]*Edge)
+	c.Edges = make([]*Edge, 0, len(g.Edges))
+	for _, edge := range g.Edges {
+		clone := edge.clone()
+		clone.Src = remapEndpoint(edge.Src)
+		clone.Dst = remapEndpoint(edge.Dst)
+		for i := range clone.References {
+			clone.References[i].ScopeObj = remap(clone.References[i].ScopeObj)
+		}
+		edges[edge] = clone
+		c.Edges = append(c.Edges, clone)
+	}
+
+	c.Decorations = nil
+	for _, d := range g.Decorations {
+		clone := *d
+		clone.Box = cloneBox(d.Box)
+		clone.LabelPosition = clonePointer(d.LabelPosition)
+		clone.Classes = cloneSlice(d.Classes)
+		clone.Style = d.Style.clone()
+		clone.Object = remap(d.Object)
+		if e, ok := edges[d.Edge]; ok {
+			clone.Edge = e
+		}
+		c.Decorations = append(c.Decorations, &clone)
+	}
+
+	if g.layoutInputs != nil {
+		c.layoutInputs = g.layoutInputs.clone(objects, edges)
+	}
+
+	c.Layers = cloneBoards(g.Layers, &c)
+	c.Scenarios = cloneBoards(g.Scenarios, &c)
+	c.Steps = cloneBoards(g.Steps, &c)
+	return &c
+}
+
+func cloneBoards(boards []*Graph, parent *Graph) []*Graph {
+	if boards == nil {
+		return nil
+	}
+	clones := make([]*Graph, 0, len(boards))
+	for _, b := range boards {
+		clones = append(clones, b.clone(parent))
+	}
+	return clones
+}
+
+func (obj *Object) clone(g *Graph) *Object {
+	c := *obj
+	c.Graph = g
+	c.Box = cloneBox(obj.Box)
+	c.LabelPosition = clonePointer(obj.LabelPosition)
+	c.IconPosition = clonePointer(obj.IconPosition)
+	c.ContentAspectRatio = clonePointer(obj.ContentAspectRatio)
+	if obj.Class != nil {
+		c.Class = &d2target.Class{
+			Fields:  cloneSlice(obj.Class.Fields),
+			Methods: cloneSlice(obj.Class.Methods),
+		}
+	}
+	if obj.SQLTable != nil {
+		c.SQLTable = &d2target.SQLTable{
+			Columns: cloneSlice(obj.SQLTable.Columns),
+		}
+	}
+	c.References = cloneSlice(obj.References)
+	c.Attributes = obj.Attributes.clone()
+	// the parent and children are remapped once every object is cloned
+	return &c
+}
+
+func (e *Edge) clone() *Edge {
+	c := *e
+	c.SrcTableColumnIndex = clonePointer(e.SrcTableColumnIndex)
+	c.DstTableColumnIndex = clonePointer(e.DstTableColumnIndex)
+	c.LabelPosition = clonePointer(e.LabelPosition)
+	c.LabelPercentage = clonePointer(e.LabelPercentage)
+	c.Route = cloneSlice(e.Route)
+	for i, p := range c.Route {
+		c.Route[i] = clonePointer(p)
+	}
+	c.SrcArrowhead = cloneAttributes(e.SrcArrowhead)
+	c.DstArrowhead = cloneAttributes(e.DstArrowhead)
+	c.References = cloneSlice(e.References)
+	c.Attributes = e.Attributes.clone()
+	c.StrokeGradient = clonePointer(e.StrokeGradient)
+	return &c
+}
+
+func (a Attributes) clone() Attributes {
+	c := a
+	c.Style = a.Style.clone()
+	c.Icon = clonePointer(a.Icon)
+	for _, s := range []**Scalar{
+		&c.Tooltip, &c.Link, &c.WidthAttr, &c.HeightAttr, &c.Top, &c.Left,
+		&c.GridRows, &c.GridColumns, &c.GridGap, &c.VerticalGap, &c.HorizontalGap,
+		&c.LabelPosition, &c.IconPosition,
+	} {
+		*s = clonePointer(*s)
+	}
+	c.Constraint = cloneSlice(a.Constraint)
+	c.Classes = cloneSlice(a.Classes)
+	c.Metadata = cloneMap(a.Metadata)
+	return c
+}
+
+func cloneAttributes(a *Attributes) *Attributes {
+	if a == nil {
+		return nil
+	}
+	c := a.clone()
+	return &c
+}
+
+func (s Style) clone() Style {
+	c := s
+	for _, s := range []**Scalar{
+		&c.Opacity, &c.Stroke, &c.Fill, &c.FillPattern, &c.StrokeWidth, &c.StrokeDash, &c.BorderRadius,
+		&c.Shadow, &c.ThreeDee, &c.Multiple, &c.Font, &c.FontSize, &c.FontColor, &c.Animated,
+		&c.Bold, &c.Italic, &c.Underline, &c.Filled, &c.DoubleBorder, &c.TextTransform,
+	} {
+		*s = clonePointer(*s)
+	}
+	return c
+}
+
+func cloneBox(b *geo.Box) *geo.Box {
+	if b == nil {
+		return nil
+	}
+	return geo.NewBox(clonePointer(b.TopLeft), b.Width, b.Height)
+}
+
+func clonePointer[T any](p *T) *T {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+func cloneMap[K comparable, V any](m map[K]V) map[K]V {
+	if m == nil {
+		return nil
+	}
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+func cloneSlice[T any](s []T) []T {
+	if s == nil {
+		return nil
+	}
+	return append(make([]T, 0, len(s)), s...)
+}
@@ -0,0 +1,83 @@
+package d2graph_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestClone(t *testing.T) {
+	t.Parallel()
+
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.t: hi {style.stroke: red}
+b.t -> a
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	assert.Nil(t, d2sequence.Layout(ctx, g, nil))
+	g.Objects[0].Metadata = map[string]string{"editor.id": "1"}
+	before, err := d2graph.SerializeGraph(g)
+	assert.Nil(t, err)
+
+	clone := g.Clone()
+	assert.Nil(t, d2graph.CompareSerializedGraph(g, clone))
+	for i, obj := range clone.Objects {
+		assert.True(t, obj != g.Objects[i] && obj.Box != g.Objects[i].Box && obj.TopLeft != g.Objects[i].TopLeft)
+		assert.True(t, obj.Graph == clone)
+		if obj.Parent != clone.Root {
+			assert.Contains(t, clone.Objects, obj.Parent)
+		}
+	}
+	for i, edge := range clone.Edges {
+		assert.True(t, edge != g.Edges[i] && edge.Route[0] != g.Edges[i].Route[0])
+		if !d2sequence.IsLifelineEnd(edge.Dst) {
+			assert.Contains(t, clone.Objects, edge.Dst)
+		}
+	}
+
+	assert.Nil(t, d2sequence.LayoutWithOpts(ctx, clone, nil, &d2sequence.ConfigurableOpts{VerticalScale: 2, BackgroundBands: true}))
+	clone.Edges[0].Style.Stroke.Value = "blue"
+	clone.Objects[0].Label.Value = "changed"
+	clone.Objects[0].Metadata["editor.id"] = "2"
+
+	after, err := d2graph.SerializeGraph(g)
+	assert.Nil(t, err)
+	assert.Equal(t, string(before), string(after))
+	assert.Empty(t, g.Decorations)
+	assert.NotEqual(t, g.Root.Height, clone.Root.Height)
+}
+
+func TestCloneLayoutInputs(t *testing.T) {
+	t.Parallel()
+
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: hi
+`), nil)
+	assert.Nil(t, err)
+	for _, obj := range g.Objects {
+		obj.LabelDimensions = d2target.TextDimensions{Width: 37, Height: 13}
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{Canvas: &d2sequence.CanvasOpts{Width: 100, Height: 100, ScaleDown: true}}
+	assert.Nil(t, d2sequence.LayoutWithOpts(ctx, g, nil, opts))
+
+	// the clone starts over from the unscaled labels of the graph, not from its own scaled copies
+	clone := g.Clone()
+	assert.Nil(t, d2sequence.LayoutWithOpts(ctx, clone, nil, opts))
+	assert.Nil(t, d2graph.CompareSerializedGraph(g, clone))
+	assert.Equal(t, g.Objects[0].LabelDimensions, clone.Objects[0].LabelDimensions)
+	assert.Equal(t, g.Objects[0].Width, clone.Objects[0].Width)
+}
@@ -51,6 +51,10 @@ type Graph struct {
 	Root    *Object   `json:"root"`
 	Edges   []*Edge   `json:"edges"`
 	Objects []*Object `json:"objects"`
+	// Decorations are placed by layout engines, see Decoration
+	Decorations []*Decoration `json:"decorations,omitempty"`
+	// layoutInputs are the layout inputs a layout engine changed in place, see SaveObjectInputs
+	layoutInputs *layoutInputs
 
 	Layers    []*Graph `json:"layers,omitempty"`
 	Scenarios []*Graph `json:"scenarios,omitempty"`
@@ -158,6 +162,12 @@ type Attributes struct {
 	// These names are attached to the rendered elements in SVG
 	// so that users can target them however they like outside of D2
 	Classes []string `json:"classes,omitempty"`
+
+	// Metadata is arbitrary data of the tools working on the graph, e.g. editor IDs or source positions.
+	// It belongs to them: layouts never read or write it, so it is kept intact through layout.
+	// Keys prefixed with the name of a layout package and a dot, like "d2sequence.", are reserved for the helpers of
+	// that package, e.g. d2sequence.SetLineCaps, and other tools should not use them. It is not exported to d2target
+	Metadata map[string]string `json:"metadata,omitempty"`
 }
 
 // ApplyTextTransform will alter the `Label.Value` of the current object based
@@ -1088,6 +1098,21 @@ func (obj *Object) SizeToContent(contentWidth, contentHeight, paddingX, paddingY
 	}
 }
 
+// EnsureBox sets a box of the given default dimensions if the object has none,
+// and fills in any missing (non-positive) width or height of an existing box
+func (obj *Object) EnsureBox(defaultWidth, defaultHeight float64) {
+	if obj.Box == nil {
+		obj.Box = geo.NewBox(nil, defaultWidth, defaultHeight)
+		return
+	}
+	if obj.Width <= 0 {
+		obj.Width = defaultWidth
+	}
+	if obj.Height <= 0 {
+		obj.Height = defaultHeight
+	}
+}
+
 func (obj *Object) OuterNearContainer() *Object {
 	for obj != nil {
 		if obj.NearKey != nil {
@@ -1136,9 +1161,19 @@ type Edge struct {
 	References []EdgeReference `json:"references,omitempty"`
 	Attributes `json:"attributes,omitempty"`
 
+	// StrokeGradient is a fade of the edge line from one color to another along its route, e.g. for data flow intensity.
+	// It is exported as d2target.Connection.StrokeGradient
+	StrokeGradient *Gradient `json:"strokeGradient,omitempty"`
+
 	ZIndex int `json:"zIndex"`
 }
 
+// Gradient is a color transition from the start to the end of an edge route
+type Gradient struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
 type EdgeReference struct {
 	Edge *d2ast.Edge `json:"-"`
 
@@ -0,0 +1,44 @@
+package d2graph
+
+import "oss.terrastruct.com/d2/lib/geo"
+
+// Decoration is a shape placed by a layout engine that has no counterpart in the diagram,
+// e.g. the background bands behind sequence diagram messages.
+// Decorations only exist on the laid out graph, for tools that draw from it: d2exporter does not export them
+// to d2target, so the d2 renderers do not draw them. Tools that do draw them with the objects and edges by ZIndex
+type Decoration struct {
+	// Kind tells what the decoration is, e.g. "band"
+	Kind string `json:"kind"`
+
+	*geo.Box `json:"box"`
+	Label    string `json:"label,omitempty"`
+	// LabelPosition is where the label is drawn in the box, if it has one
+	LabelPosition *string `json:"labelPosition,omitempty"`
+	// Classes flag variants of a kind, e.g. alternating bands
+	Classes []string `json:"classes,omitempty"`
+	Style   Style    `json:"style"`
+
+	// Owner is the layout engine that placed the decoration, which removes it when laying out the graph again.
+	// Decorations added by other tools have none and are kept
+	Owner string `json:"owner,omitempty"`
+
+	// the object or edge the decoration belongs to, if any
+	Object *Object `json:"-"`
+	Edge   *Edge   `json:"-"`
+
+	ZIndex int `json:"zIndex"`
+}
+
+func (d *Decoration) Move(dx, dy float64) {
+	d.TopLeft.X += dx
+	d.TopLeft.Y += dy
+}
+
+func (d *Decoration) HasClass(class string) bool {
+	for _, c := range d.Classes {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
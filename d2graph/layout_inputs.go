@@ -0,0 +1,401 @@
+package d2graph
+
+import (
+	"reflect"
+	"strings"
+)
+
+// layoutInputs keep the values a layout engine changed in place as they were before the layout, and the objects
+// it created, so that laying out the graph again starts over from the same inputs instead of from the output of
+// the previous layout, e.g. label dimensions it scaled down or styles it set, which would otherwise look declared
+type layoutInputs struct {
+	objects map[*Object]objectInputs
+	edges   map[*Edge]edgeInputs
+	created []*Object
+	// reordered are the edges a layout engine moved in g.Edges, by the edge that was in their place before
+	reordered map[*Edge]*Edge
+	// the values the layout left, see SaveLayoutOutputs
+	objectOutputs map[*Object]objectState
+	edgeOutputs   map[*Edge]edgeInputs
+}
+
+type objectInputs struct {
+	state    objectState
+	children []*Object
+}
+
+type edgeInputs struct {
+	src   *Object
+	dst   *Object
+	state edgeState
+}
+
+// objectState is what a layout engine can change in place on an object.
+// The fields are exported for restoreOwned to go through them
+type objectState struct {
+	HasBox        bool
+	Width         float64
+	Height        float64
+	Attributes    Attributes
+	LabelPosition *string
+}
+
+// edgeState is what a layout engine can change in place on an edge, besides its endpoints
+type edgeState struct {
+	Attributes      Attributes
+	LabelPosition   *string
+	LabelPercentage *float64
+	SrcArrowhead    *Attributes
+	DstArrowhead    *Attributes
+	StrokeGradient  *Gradient
+}
+
+func newObjectState(obj *Object) objectState {
+	s := objectState{
+		Attributes:    obj.Attributes.clone(),
+		LabelPosition: clonePointer(obj.LabelPosition),
+	}
+	if obj.Box != nil {
+		s.HasBox = true
+		s.Width, s.Height = obj.Width, obj.Height
+	}
+	return s
+}
+
+func (s objectState) apply(obj *Object) {
+	if !s.HasBox {
+		obj.Box = nil
+	} else if obj.Box != nil {
+		obj.Width, obj.Height = s.Width, s.Height
+	}
+	obj.Attributes = s.Attributes
+	obj.LabelPosition = s.LabelPosition
+}
+
+func (s objectState) clone() objectState {
+	s.Attributes = s.Attributes.clone()
+	s.LabelPosition = clonePointer(s.LabelPosition)
+	return s
+}
+
+func newEdgeState(edge *Edge) edgeState {
+	return edgeState{
+		Attributes:      edge.Attributes.clone(),
+		LabelPosition:   clonePointer(edge.LabelPosition),
+		LabelPercentage: clonePointer(edge.LabelPercentage),
+		SrcArrowhead:    cloneAttributes(edge.SrcArrowhead),
+		DstArrowhead:    cloneAttributes(edge.DstArrowhead),
+		StrokeGradient:  clonePointer(edge.StrokeGradient),
+	}
+}
+
+func (s edgeState) apply(edge *Edge) {
+	edge.Attributes = s.Attributes
+	edge.LabelPosition = s.LabelPosition
+	edge.LabelPercentage = s.LabelPercentage
+	edge.SrcArrowhead = s.SrcArrowhead
+	edge.DstArrowhead = s.DstArrowhead
+	edge.StrokeGradient = s.StrokeGradient
+}
+
+func (s edgeState) clone() edgeState {
+	s.Attributes = s.Attributes.clone()
+	s.LabelPosition = clonePointer(s.LabelPosition)
+	s.LabelPercentage = clonePointer(s.LabelPercentage)
+	s.SrcArrowhead = cloneAttributes(s.SrcArrowhead)
+	s.DstArrowhead = cloneAttributes(s.DstArrowhead)
+	s.StrokeGradient = clonePointer(s.StrokeGradient)
+	return s
+}
+
+func (g *Graph) ensureLayoutInputs() *layoutInputs {
+	if g.layoutInputs == nil {
+		g.layoutInputs = &layoutInputs{
+			objects: make(map[*Object]objectInputs),
+			edges:   make(map[*Edge]edgeInputs),
+		}
+	}
+	return g.layoutInputs
+}
+
+// SaveObjectInputs keeps the size, attributes, label position and order of the children of an object before a layout
+// engine changes them in place, for RestoreLayoutInputs. Only the first call for an object between two restores keeps
+// its values
+func (g *Graph) SaveObjectInputs(obj *Object) {
+	inputs := g.ensureLayoutInputs()
+	if _, has := inputs.objects[obj]; has {
+		return
+	}
+	inputs.objects[obj] = objectInputs{
+		state:    newObjectState(obj),
+		children: cloneSlice(obj.ChildrenArray),
+	}
+}
+
+// SaveEdgeInputs keeps the endpoints, attributes, arrowheads and label position of an edge before a layout engine
+// changes them in place, for RestoreLayoutInputs. Only the first call for an edge between two restores keeps its values
+func (g *Graph) SaveEdgeInputs(edge *Edge) {
+	inputs := g.ensureLayoutInputs()
+	if _, has := inputs.edges[edge]; has {
+		return
+	}
+	inputs.edges[edge] = edgeInputs{
+		src:   edge.Src,
+		dst:   edge.Dst,
+		state: newEdgeState(edge),
+	}
+}
+
+// SaveLayoutOutputs keeps the values the layout left on the objects and edges saved with SaveObjectInputs and
+// SaveEdgeInputs once it is done. RestoreLayoutInputs then only gives back the inputs of the values that still are
+// the layout output, so that the changes made since, e.g. by an editor, are kept
+func (g *Graph) SaveLayoutOutputs() {
+	inputs := g.ensureLayoutInputs()
+	inputs.objectOutputs = make(map[*Object]objectState, len(inputs.objects))
+	for obj := range inputs.objects {
+		inputs.objectOutputs[obj] = newObjectState(obj)
+	}
+	inputs.edgeOutputs = make(map[*Edge]edgeInputs, len(inputs.edges))
+	for edge := range inputs.edges {
+		inputs.edgeOutputs[edge] = edgeInputs{
+			src:   edge.Src,
+			dst:   edge.Dst,
+			state: newEdgeState(edge),
+		}
+	}
+}
+
+// ReorderEdges replaces g.Edges by the same edges in another order, e.g. to draw some edges on top of others,
+// and keeps the order they were in for RestoreLayoutInputs, so that laying out the graph again starts from it
+func (g *Graph) ReorderEdges(edges []*Edge) {
+	inputs := g.ensureLayoutInputs()
+	reordered := make(map[*Edge]*Edge)
+	for i, edge := range g.Edges {
+		declared := edge
+		if d, has := inputs.reordered[edge]; has {
+			declared = d
+		}
+		if edges[i] != declared {
+			reordered[edges[i]] = declared
+		}
+	}
+	inputs.reordered = reordered
+	g.Edges = edges
+}
+
+// DeclaredEdges are the edges of the graph in the order they had before the layout reordered them
+// with ReorderEdges. Edges added since keep their place
+func (g *Graph) DeclaredEdges() []*Edge {
+	if g.layoutInputs == nil || len(g.layoutInputs.reordered) == 0 {
+		return g.Edges
+	}
+	present := make(map[*Edge]bool, len(g.Edges))
+	for _, edge := range g.Edges {
+		present[edge] = true
+	}
+	for edge, declared := range g.layoutInputs.reordered {
+		// an edge was removed since, the order of the others can no longer be undone
+		if !present[edge] || !present[declared] {
+			return g.Edges
+		}
+	}
+	edges := make([]*Edge, len(g.Edges))
+	for i, edge := range g.Edges {
+		if declared, has := g.layoutInputs.reordered[edge]; has {
+			edge = declared
+		}
+		edges[i] = edge
+	}
+	return edges
+}
+
+// DeclaredEndpoints are the ends of an edge before the layout moved them, e.g. to a span it created.
+// Ends changed since SaveLayoutOutputs are kept
+func (g *Graph) DeclaredEndpoints(edge *Edge) (src, dst *Object) {
+	src, dst = edge.Src, edge.Dst
+	if g.layoutInputs == nil {
+		return src, dst
+	}
+	saved, has := g.layoutInputs.edges[edge]
+	if !has {
+		return src, dst
+	}
+	output, hasOutput := g.layoutInputs.edgeOutputs[edge]
+	if !hasOutput || edge.Src == output.src {
+		src = saved.src
+	}
+	if !hasOutput || edge.Dst == output.dst {
+		dst = saved.dst
+	}
+	return src, dst
+}
+
+// AddLayoutObject records an object a layout engine created, e.g. a span it inferred,
+// for RestoreLayoutInputs to remove it
+func (g *Graph) AddLayoutObject(obj *Object) {
+	inputs := g.ensureLayoutInputs()
+	inputs.created = append(inputs.created, obj)
+}
+
+// RestoreLayoutInputs gives the objects and edges back the values kept with SaveObjectInputs and SaveEdgeInputs,
+// puts the edges back in their DeclaredEdges order and removes the objects added with AddLayoutObject, undoing what
+// a layout changed in place before laying out the graph again. Values changed since SaveLayoutOutputs are kept
+func (g *Graph) RestoreLayoutInputs() {
+	inputs := g.layoutInputs
+	if inputs == nil {
+		return
+	}
+	g.Edges = g.DeclaredEdges()
+	g.layoutInputs = nil
+
+	for obj, saved := range inputs.objects {
+		state := saved.state
+		if output, has := inputs.objectOutputs[obj]; has {
+			state = newObjectState(obj)
+			restoreOwned(reflect.ValueOf(&state).Elem(), reflect.ValueOf(output), reflect.ValueOf(saved.state))
+		}
+		state.apply(obj)
+		obj.ChildrenArray = restoreOrder(saved.children, obj.ChildrenArray)
+	}
+	for edge, saved := range inputs.edges {
+		state := saved.state
+		src, dst := saved.src, saved.dst
+		if output, has := inputs.edgeOutputs[edge]; has {
+			state = newEdgeState(edge)
+			restoreOwned(reflect.ValueOf(&state).Elem(), reflect.ValueOf(output.state), reflect.ValueOf(saved.state))
+			if edge.Src != output.src {
+				src = edge.Src
+			}
+			if edge.Dst != output.dst {
+				dst = edge.Dst
+			}
+		}
+		edge.Src, edge.Dst = src, dst
+		state.apply(edge)
+	}
+
+	if len(inputs.created) == 0 {
+		return
+	}
+	created := make(map[*Object]bool, len(inputs.created))
+	for _, obj := range inputs.created {
+		created[obj] = true
+	}
+	for _, obj := range inputs.created {
+		if created[obj.Parent] {
+			continue
+		}
+		delete(obj.Parent.Children, strings.ToLower(obj.ID))
+		var children []*Object
+		for _, child := range obj.Parent.ChildrenArray {
+			if child != obj {
+				children = append(children, child)
+			}
+		}
+		obj.Parent.ChildrenArray = children
+	}
+	var objects []*Object
+	for _, obj := range g.Objects {
+		if !created[obj] {
+			objects = append(objects, obj)
+		}
+	}
+	g.Objects = objects
+}
+
+// restoreOwned sets the fields of current that still have the value the layout left in output back to saved,
+// going through structs field by field so that e.g. a style the layout set is undone but a label edited since is not
+func restoreOwned(current, output, saved reflect.Value) {
+	if current.Kind() == reflect.Struct {
+		for i := 0; i < current.NumField(); i++ {
+			restoreOwned(current.Field(i), output.Field(i), saved.Field(i))
+		}
+		return
+	}
+	if reflect.DeepEqual(current.Interface(), output.Interface()) {
+		current.Set(saved)
+	}
+}
+
+// restoreOrder is the children in their saved order, followed by the children added since
+func restoreOrder(saved, children []*Object) []*Object {
+	present := make(map[*Object]bool, len(children))
+	for _, child := range children {
+		present[child] = true
+	}
+	ordered := make([]*Object, 0, len(children))
+	had := make(map[*Object]bool, len(saved))
+	for _, child := range saved {
+		had[child] = true
+		if present[child] {
+			ordered = append(ordered, child)
+		}
+	}
+	for _, child := range children {
+		if !had[child] {
+			ordered = append(ordered, child)
+		}
+	}
+	return ordered
+}
+
+func (inputs *layoutInputs) clone(objects map[*Object]*Object, edges map[*Edge]*Edge) *layoutInputs {
+	remap := func(obj *Object) *Object {
+		if clone, ok := objects[obj]; ok {
+			return clone
+		}
+		return obj
+	}
+	cloneEdgeInputs := func(saved edgeInputs) edgeInputs {
+		return edgeInputs{
+			src:   remap(saved.src),
+			dst:   remap(saved.dst),
+			state: saved.state.clone(),
+		}
+	}
+	c := &layoutInputs{
+		objects: make(map[*Object]objectInputs, len(inputs.objects)),
+		edges:   make(map[*Edge]edgeInputs, len(inputs.edges)),
+	}
+	for obj, saved := range inputs.objects {
+		children := make([]*Object, 0, len(saved.children))
+		for _, child := range saved.children {
+			children = append(children, remap(child))
+		}
+		c.objects[remap(obj)] = objectInputs{
+			state:    saved.state.clone(),
+			children: children,
+		}
+	}
+	for edge, saved := range inputs.edges {
+		if clone, ok := edges[edge]; ok {
+			c.edges[clone] = cloneEdgeInputs(saved)
+		}
+	}
+	if inputs.objectOutputs != nil {
+		c.objectOutputs = make(map[*Object]objectState, len(inputs.objectOutputs))
+		for obj, output := range inputs.objectOutputs {
+			c.objectOutputs[remap(obj)] = output.clone()
+		}
+	}
+	if inputs.edgeOutputs != nil {
+		c.edgeOutputs = make(map[*Edge]edgeInputs, len(inputs.edgeOutputs))
+		for edge, output := range inputs.edgeOutputs {
+			if clone, ok := edges[edge]; ok {
+				c.edgeOutputs[clone] = cloneEdgeInputs(output)
+			}
+		}
+	}
+	for _, obj := range inputs.created {
+		c.created = append(c.created, remap(obj))
+	}
+	if inputs.reordered != nil {
+		c.reordered = make(map[*Edge]*Edge, len(inputs.reordered))
+		for edge, declared := range inputs.reordered {
+			if edge, declared := edges[edge], edges[declared]; edge != nil && declared != nil {
+				c.reordered[edge] = declared
+			}
+		}
+	}
+	return c
+}
@@ -41,6 +41,12 @@ func (obj *Object) IsSequenceDiagramNote() bool {
 	if obj.OuterSequenceDiagram() == nil {
 		return false
 	}
+	// objects created during layout (e.g. inferred spans) have no references, so also check connected edges
+	for _, e := range obj.Graph.Edges {
+		if e.Src == obj || e.Dst == obj {
+			return false
+		}
+	}
 	return !obj.hasEdgeRef() && !obj.ContainsAnyEdge(obj.Graph.Edges) && len(obj.ChildrenArray) == 0 && !obj.ContainsAnyObject(obj.Graph.Objects)
 }
 
@@ -169,6 +169,9 @@ func LayoutNested(ctx context.Context, g *d2graph.Graph, graphInfo GraphInfo, co
 			for _, e := range nestedGraph.Edges {
 				e.Move(dx, dy)
 			}
+			for _, d := range nestedGraph.Decorations {
+				d.Move(dx, dy)
+			}
 
 			// Then after re-injecting everything, we extract curr with includeSelf=false,
 			// and externalEdges=[C], nestedGraph.Edges=[B], and graph.Edges=[A].
@@ -478,6 +481,7 @@ func InjectNested(container *d2graph.Object, nestedGraph *d2graph.Graph, isRoot
 	}
 	g.Objects = append(g.Objects, nestedGraph.Objects...)
 	g.Edges = append(g.Edges, nestedGraph.Edges...)
+	g.Decorations = append(g.Decorations, nestedGraph.Decorations...)
 
 	if isRoot {
 		if nestedGraph.Root.LabelPosition != nil {
@@ -505,6 +509,9 @@ func PositionNested(container *d2graph.Object, nestedGraph *d2graph.Graph) {
 	for _, e := range nestedGraph.Edges {
 		e.Move(dx, dy)
 	}
+	for _, d := range nestedGraph.Decorations {
+		d.Move(dx, dy)
+	}
 }
 
 func boundingBox(g *d2graph.Graph) (tl, br *geo.Point) {
@@ -0,0 +1,229 @@
+package d2sequence
+
+import (
+	"fmt"
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// openCall is a call waiting for its return on the callee's activation stack
+type openCall struct {
+	caller *d2graph.Object
+	span   *d2graph.Object
+}
+
+// inferActivations creates spans from call/return message pairs
+// a message from actor A to actor B is a call: it opens a span on B (nested in B's innermost open span)
+// a later message from B to A while that call is on top of B's stack is its return and closes the span
+// .  ┌─────┐            ┌─────┐
+// .  │  a  │            │  b  │
+// .  └──┬──┘            └──┬──┘
+// .     ├───── call ─────►┌┤
+// .     │                 ││ inferred span
+// .     ◄──── return ─────└┤
+// .     │                  │
+// messages to/from descendants of actors are explicit spans and are left untouched
+// it returns the calls that have no return by the end of the diagram, by the span they opened
+// the inferred spans and the original ends of the messages are kept in the graph layout inputs, so that laying
+// out the graph again infers them from the same messages
+func inferActivations(root *d2graph.Object, messages []*d2graph.Edge) map[*d2graph.Object]*d2graph.Edge {
+	stacks := make(map[*d2graph.Object][]openCall)
+	calls := make(map[*d2graph.Object]*d2graph.Edge)
+	innermost := func(actor *d2graph.Object) *d2graph.Object {
+		if stack := stacks[actor]; len(stack) > 0 {
+			return stack[len(stack)-1].span
+		}
+		return actor
+	}
+
+	for _, message := range messages {
+		src, dst := message.Src, message.Dst
+		if src == dst || src.Parent != root || dst.Parent != root {
+			continue
+		}
+
+		if stack := stacks[src]; len(stack) > 0 && stack[len(stack)-1].caller == dst {
+			// return: leaves from the span opened by the call
+			message.Src = stack[len(stack)-1].span
+			stacks[src] = stack[:len(stack)-1]
+			message.Dst = innermost(dst)
+			continue
+		}
+
+		// call: leaves from the caller innermost span and opens a new one on the callee
+		parent := innermost(dst)
+		span := parent.EnsureChild([]string{nextActivationID(parent)})
+		root.Graph.AddLayoutObject(span)
+		message.Src = innermost(src)
+		message.Dst = span
+		stacks[dst] = append(stacks[dst], openCall{caller: src, span: span})
+		calls[span] = message
+	}
+
+	unanswered := make(map[*d2graph.Object]*d2graph.Edge)
+	for _, stack := range stacks {
+		for _, call := range stack {
+			unanswered[call.span] = calls[call.span]
+		}
+	}
+	return unanswered
+}
+
+func nextActivationID(parent *d2graph.Object) string {
+	for i := len(parent.ChildrenArray); ; i++ {
+		id := fmt.Sprintf("activation-%d", i)
+		if _, has := parent.HasChild([]string{id}); !has {
+			return id
+		}
+	}
+}
+
+// openingCalls finds the message that opens each span: the first message of the span when it goes to the span
+// spans that start with a message from them are not opened by a call and map to nil
+func openingCalls(root *d2graph.Object, messages []*d2graph.Edge) map[*d2graph.Object]*d2graph.Edge {
+	calls := make(map[*d2graph.Object]*d2graph.Edge)
+	for _, message := range messages {
+		if IsLifelineEnd(message.Dst) {
+			continue
+		}
+		for _, obj := range []*d2graph.Object{message.Src, message.Dst} {
+			if _, has := calls[obj]; !has && obj.Parent != root {
+				calls[obj] = nil
+				if obj == message.Dst && message.Src != message.Dst {
+					calls[obj] = message
+				}
+			}
+		}
+	}
+	return calls
+}
+
+// replyTo returns the call a message replies to, a message from a span back to the sender of the call that opened it
+func replyTo(calls map[*d2graph.Object]*d2graph.Edge, message *d2graph.Edge) (*d2graph.Edge, bool) {
+	call := calls[message.Src]
+	if call == nil || message.Dst != call.Src {
+		return nil, false
+	}
+	return call, true
+}
+
+// placeLostReturns places a stub from the bottom of the span of each unanswered call towards its caller
+// . ┌─────┐            ┌─────┐
+// . │  a  │            │  b  │
+// . └──┬──┘            └──┬──┘
+// .    ├───── call ─────►┌┤
+// .    │            - - -└┤ stub
+// .    │                  │
+func (sd *sequenceDiagram) placeLostReturns() {
+	for _, span := range sd.spans {
+		call, has := sd.unanswered[span]
+		if !has {
+			continue
+		}
+		y := span.TopLeft.Y + span.Height
+		x := span.TopLeft.X - LOST_RETURN_STUB_LENGTH
+		if call.Src.Center().X > span.Center().X {
+			x = span.TopLeft.X + span.Width
+		}
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   LOST_RETURN_DECORATION,
+			Box:    geo.NewBox(geo.NewPoint(x, y), LOST_RETURN_STUB_LENGTH, 0),
+			Object: span,
+			Edge:   call,
+			ZIndex: MESSAGE_Z_INDEX,
+		})
+	}
+}
+
+// mergeActivations gives all the spans of each actor a single summary box, from the top of its first span
+// to the bottom of its last one. Messages connect to the sides of the summary box like they do to spans
+// . ┌─────┐            ┌─────┐
+// . │  a  │            │  b  │
+// . └──┬──┘            └──┬──┘
+// .    ├───── call ─────►┌┴┐
+// .    ◄──── return ─────┤ │
+// .    │                 │ │ summary
+// .    ├───── call ─────►│ │
+// .    ◄──── return ─────┤ │
+// .    │                 └┬┘
+func (sd *sequenceDiagram) mergeActivations() {
+	spans := make(map[*d2graph.Object][]*d2graph.Object)
+	for _, span := range sd.spans {
+		actor := span.Parent
+		for actor.Parent != sd.root {
+			actor = actor.Parent
+		}
+		spans[actor] = append(spans[actor], span)
+	}
+	for _, actor := range sd.actors {
+		if len(spans[actor]) < 2 {
+			continue
+		}
+		minY := math.Inf(1)
+		maxY := math.Inf(-1)
+		for _, span := range spans[actor] {
+			minY = math.Min(minY, span.TopLeft.Y)
+			maxY = math.Max(maxY, span.TopLeft.Y+span.Height)
+		}
+		width := SPAN_BASE_WIDTH
+		switch sd.opts.ActivationStyle {
+		case ActivationStyleInline:
+			width = INLINE_SPAN_WIDTH
+		case ActivationStyleLane:
+			width = 0
+		}
+		x := actor.Center().X - width/2.
+		for _, span := range spans[actor] {
+			span.Box = geo.NewBox(geo.NewPoint(x, minY), width, maxY-minY)
+		}
+	}
+}
+
+// checkActivations clamps the spans that end below the lifeline of their actor to the lifeline end with
+// ClampActivations, or fails on the first one
+func (sd *sequenceDiagram) checkActivations() error {
+	lifelineEnds := make(map[*d2graph.Object]float64, len(sd.lifelines))
+	for _, lifeline := range sd.lifelines {
+		lifelineEnds[lifeline.Src] = lifeline.Route[len(lifeline.Route)-1].Y
+	}
+	for _, span := range sd.spans {
+		actor := span
+		for !sd.isActor(actor) {
+			actor = actor.Parent
+		}
+		end, has := lifelineEnds[actor]
+		if !has || span.TopLeft.Y+span.Height <= end {
+			continue
+		}
+		if !sd.opts.ClampActivations {
+			return errorf(ACTIVATIONS_STAGE, span, "%s extends below the lifeline of %s", span.AbsID(), actor.AbsID())
+		}
+		span.Height = math.Max(0, end-span.TopLeft.Y)
+	}
+	return nil
+}
+
+// placeLanes shades the column of each actor, as wide as its header, over each of its outermost spans
+// .  ┌─────┐
+// .  │  a  │
+// .  └──┬──┘
+// .  ░░░│░░░
+// .  ░░░│░░░ active
+// .  ░░░│░░░
+// .     │
+func (sd *sequenceDiagram) placeLanes() {
+	for _, span := range sd.spans {
+		actor := span.Parent
+		if !sd.isActor(actor) {
+			continue
+		}
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   LANE_DECORATION,
+			Box:    geo.NewBox(geo.NewPoint(actor.TopLeft.X, span.TopLeft.Y), actor.Width, span.Height),
+			Object: span,
+			ZIndex: BACKGROUND_Z_INDEX,
+		})
+	}
+}
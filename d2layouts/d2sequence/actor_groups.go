@@ -0,0 +1,353 @@
+package d2sequence
+
+import (
+	"math"
+	"strconv"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+type actorGroup struct {
+	opts     ActorGroup
+	actors   []*d2graph.Object
+	children []*actorGroup
+
+	// first and last rank of the actors in the group and its nested groups
+	first int
+	last  int
+
+	// how many group borders are stacked on each side of the group, counting its own
+	leftDepth  int
+	rightDepth int
+	// space taken above the actor headers by the group and its nested groups
+	topReserve float64
+	// space taken by the title, 0 without one
+	titleHeight float64
+
+	// border of the group once placed
+	box *geo.Box
+}
+
+// header is the space the group border and title take above its content
+func (ag *actorGroup) header() float64 {
+	return ACTOR_GROUP_PADDING + ag.titleHeight
+}
+
+// measureTitle measures the title of the group, drawn with the style of the group
+func (sd *sequenceDiagram) measureTitle(ag *actorGroup) error {
+	if ag.opts.Label == "" {
+		return nil
+	}
+	mtext := &d2target.MText{
+		Text:     ag.opts.Label,
+		FontSize: d2fonts.FONT_SIZE_M,
+	}
+	if ag.opts.Style.FontSize != nil {
+		mtext.FontSize, _ = strconv.Atoi(ag.opts.Style.FontSize.Value)
+	}
+	if ag.opts.Style.Bold != nil {
+		mtext.IsBold, _ = strconv.ParseBool(ag.opts.Style.Bold.Value)
+	}
+	if ag.opts.Style.Italic != nil {
+		mtext.IsItalic, _ = strconv.ParseBool(ag.opts.Style.Italic.Value)
+	}
+	dims, err := sd.measureText(mtext)
+	if err != nil {
+		return err
+	}
+	ag.titleHeight = math.Max(ACTOR_GROUP_LABEL_HEIGHT, float64(dims.Height))
+	return nil
+}
+
+// initActorGroups resolves the actor groups and widens the space between actors for the group borders
+func (sd *sequenceDiagram) initActorGroups() error {
+	assigned := make(map[*d2graph.Object]bool)
+	for _, opts := range sd.opts.ActorGroups {
+		ag, err := sd.newActorGroup(opts, assigned)
+		if err != nil {
+			return err
+		}
+		sd.actorGroups = append(sd.actorGroups, ag)
+	}
+
+	all := sd.allActorGroups()
+	for rank := 0; rank < len(sd.actors)-1; rank++ {
+		closing := 0
+		opening := 0
+		divider := false
+		for _, ag := range all {
+			if ag.last == rank {
+				closing = go2.IntMax(closing, ag.rightDepth)
+				divider = divider || ag.opts.DividerAfter
+			}
+			if ag.first == rank+1 {
+				opening = go2.IntMax(opening, ag.leftDepth)
+			}
+		}
+		if closing+opening == 0 {
+			continue
+		}
+		actorHW := sd.actors[rank].Width / 2.
+		nextActorHW := sd.actors[rank+1].Width / 2.
+		sd.actorXStep[rank] = math.Max(sd.actorXStep[rank], actorHW+nextActorHW+HORIZONTAL_PAD+float64(closing+opening)*ACTOR_GROUP_PADDING)
+		if divider {
+			sd.actorXStep[rank] += DIVIDER_GAP
+		}
+	}
+
+	for _, ag := range sd.actorGroups {
+		if ag.first == 0 {
+			sd.actorGroupInsets.Left = math.Max(sd.actorGroupInsets.Left, float64(ag.leftDepth)*ACTOR_GROUP_PADDING)
+		}
+		if ag.last == len(sd.actors)-1 {
+			sd.actorGroupInsets.Right = math.Max(sd.actorGroupInsets.Right, float64(ag.rightDepth)*ACTOR_GROUP_PADDING)
+		}
+		sd.actorGroupInsets.Top = math.Max(sd.actorGroupInsets.Top, ag.topReserve)
+	}
+	sd.maxActorHeight += sd.actorGroupInsets.Top
+	return nil
+}
+
+// allActorGroups returns the actor groups and all of their nested groups, outer groups first
+func (sd *sequenceDiagram) allActorGroups() []*actorGroup {
+	var all []*actorGroup
+	queue := append([]*actorGroup{}, sd.actorGroups...)
+	for len(queue) > 0 {
+		ag := queue[0]
+		queue = queue[1:]
+		all = append(all, ag)
+		queue = append(queue, ag.children...)
+	}
+	return all
+}
+
+func (sd *sequenceDiagram) newActorGroup(opts ActorGroup, assigned map[*d2graph.Object]bool) (*actorGroup, error) {
+	ag := &actorGroup{
+		opts:  opts,
+		first: math.MaxInt32,
+		last:  -1,
+	}
+	for _, id := range opts.Actors {
+		var actor *d2graph.Object
+		for _, a := range sd.actors {
+			if a.AbsID() == id {
+				actor = a
+				break
+			}
+		}
+		if actor == nil {
+			return nil, errorf(ACTOR_GROUPS_STAGE, nil, "actor group %#v references %#v which is not an actor", opts.Label, id)
+		}
+		if assigned[actor] {
+			return nil, errorf(ACTOR_GROUPS_STAGE, actor, "actor %#v is in more than one actor group", id)
+		}
+		assigned[actor] = true
+		ag.actors = append(ag.actors, actor)
+		ag.first = go2.IntMin(ag.first, sd.objectRank[actor])
+		ag.last = go2.IntMax(ag.last, sd.objectRank[actor])
+	}
+
+	var childTopReserve float64
+	for _, childOpts := range opts.Groups {
+		child, err := sd.newActorGroup(childOpts, assigned)
+		if err != nil {
+			return nil, err
+		}
+		ag.children = append(ag.children, child)
+		ag.first = go2.IntMin(ag.first, child.first)
+		ag.last = go2.IntMax(ag.last, child.last)
+		childTopReserve = math.Max(childTopReserve, child.topReserve)
+	}
+	if ag.last < 0 {
+		return nil, errorf(ACTOR_GROUPS_STAGE, nil, "actor group %#v has no actors", opts.Label)
+	}
+
+	count := len(ag.actors)
+	for rank := ag.first; rank <= ag.last; rank++ {
+		// placeholders in between the actors of a group are in the group
+		if sd.placeholders[sd.actors[rank]] {
+			count++
+		}
+	}
+	ag.leftDepth = 1
+	ag.rightDepth = 1
+	for _, child := range ag.children {
+		count += child.last - child.first + 1
+		if child.first == ag.first {
+			ag.leftDepth = child.leftDepth + 1
+		}
+		if child.last == ag.last {
+			ag.rightDepth = child.rightDepth + 1
+		}
+	}
+	if err := sd.measureTitle(ag); err != nil {
+		return nil, err
+	}
+	ag.topReserve = childTopReserve + ag.header()
+	if count != ag.last-ag.first+1 {
+		return nil, errorf(ACTOR_GROUPS_STAGE, sd.actors[ag.first], "actors of actor group %#v must be declared next to each other", opts.Label)
+	}
+	return ag, nil
+}
+
+// placeActorGroups places a box around the headers of the actors in each group, nested groups inside their parent
+// . ┌─────────────────────────┐
+// . │outer                    │
+// . │ ┌────────────┐          │
+// . │ │inner       │          │
+// . │ │ ┌───┐ ┌───┐│  ┌───┐   │
+// . │ │ │ a │ │ b ││  │ c │   │
+// . │ │ └─┬─┘ └─┬─┘│  └─┬─┘   │
+// . │ └───┼─────┼──┘    │     │
+// . └─────┼─────┼───────┼─────┘
+func (sd *sequenceDiagram) placeActorGroups() {
+	for _, ag := range sd.actorGroups {
+		sd.placeActorGroup(ag)
+	}
+}
+
+func (sd *sequenceDiagram) placeActorGroup(ag *actorGroup) *geo.Box {
+	// the group is added before its children so that it is drawn below them
+	decoration := &d2graph.Decoration{
+		Kind:          ACTOR_GROUP_DECORATION,
+		Label:         ag.opts.Label,
+		Style:         ag.opts.Style,
+		LabelPosition: go2.Pointer(label.InsideTopLeft.String()),
+		ZIndex:        BACKGROUND_Z_INDEX,
+	}
+	sd.decorations = append(sd.decorations, decoration)
+
+	minX := math.Inf(1)
+	minY := math.Inf(1)
+	maxX := math.Inf(-1)
+	maxY := math.Inf(-1)
+	for _, actor := range ag.actors {
+		minX = math.Min(minX, actor.TopLeft.X)
+		minY = math.Min(minY, actor.TopLeft.Y)
+		maxX = math.Max(maxX, actor.TopLeft.X+actor.Width)
+		bottom := actor.TopLeft.Y + actor.Height
+		if *actor.LabelPosition == label.OutsideBottomCenter.String() && actor.HasLabel() {
+			bottom += float64(actor.LabelDimensions.Height)
+		}
+		maxY = math.Max(maxY, bottom)
+	}
+	for _, child := range ag.children {
+		box := sd.placeActorGroup(child)
+		minX = math.Min(minX, box.TopLeft.X)
+		minY = math.Min(minY, box.TopLeft.Y)
+		maxX = math.Max(maxX, box.TopLeft.X+box.Width)
+		maxY = math.Max(maxY, box.TopLeft.Y+box.Height)
+	}
+
+	minX -= ACTOR_GROUP_PADDING
+	minY -= ag.header()
+	maxX += ACTOR_GROUP_PADDING
+	maxY += ACTOR_GROUP_PADDING
+
+	decoration.Box = geo.NewBox(geo.NewPoint(minX, minY), maxX-minX, maxY-minY)
+	ag.box = decoration.Box
+	return decoration.Box
+}
+
+// innermostActorGroup returns the most nested actor group the actor is in, nil if it is in none
+func (sd *sequenceDiagram) innermostActorGroup(actor *d2graph.Object) *actorGroup {
+	var innermost *actorGroup
+	rank := sd.objectRank[actor]
+	for groups := sd.actorGroups; len(groups) > 0; {
+		in := groups
+		groups = nil
+		for _, ag := range in {
+			if ag.first <= rank && rank <= ag.last {
+				innermost = ag
+				groups = ag.children
+				break
+			}
+		}
+	}
+	return innermost
+}
+
+// placeActorGroupMessages makes the messages sent to or from an actor group as a whole end on the group border,
+// on the side of the other actor, instead of on the lifeline of the actor they are declared with
+// . ┌───┐   ┌─────────────────┐
+// . │ a │   │group ┌───┐ ┌───┐│
+// . └─┬─┘   │      │ b │ │ c ││
+// .   │     │      └─┬─┘ └─┬─┘│
+// .   │     └────────┼─────┼──┘
+// .   ├────►         │     │
+func (sd *sequenceDiagram) placeActorGroupMessages() error {
+	actorOf := func(obj *d2graph.Object) *d2graph.Object {
+		for !sd.isActor(obj) {
+			obj = obj.Parent
+		}
+		return obj
+	}
+	for _, message := range sd.messages {
+		messageOpts := sd.opts.messageOpts(message)
+		if len(message.Route) != 2 || (!messageOpts.ToActorGroup && !messageOpts.FromActorGroup) {
+			continue
+		}
+		for i, end := range []struct {
+			toGroup bool
+			actor   *d2graph.Object
+			self    *geo.Point
+			other   *geo.Point
+		}{
+			{messageOpts.FromActorGroup, actorOf(message.Src), message.Route[0], message.Route[1]},
+			{messageOpts.ToActorGroup, actorOf(message.Dst), message.Route[1], message.Route[0]},
+		} {
+			if !end.toGroup {
+				continue
+			}
+			ag := sd.innermostActorGroup(end.actor)
+			if ag == nil {
+				return edgeErrorf(ACTOR_GROUPS_STAGE, message, "%s is not in an actor group for %s", end.actor.AbsID(), message.AbsID())
+			}
+			// when the other end is in the group too, the message stays on the lifeline
+			x := end.self.X
+			if end.other.X < ag.box.TopLeft.X {
+				x = ag.box.TopLeft.X
+			} else if end.other.X > ag.box.TopLeft.X+ag.box.Width {
+				x = ag.box.TopLeft.X + ag.box.Width
+			}
+			message.Route[i] = geo.NewPoint(x, end.self.Y)
+		}
+	}
+	return nil
+}
+
+// placeDividers places a divider over the whole height of the diagram halfway between each group with DividerAfter
+// and the actor or actor group after it
+// . ┌──────────────┐  ┆  ┌───────┐
+// . │ ┌───┐ ┌───┐  │  ┆  │ ┌───┐ │
+// . │ │ a │ │ b │  │  ┆  │ │ c │ │
+// . │ └─┬─┘ └─┬─┘  │  ┆  │ └─┬─┘ │
+// . └───┼─────┼────┘  ┆  └───┼───┘
+// .     ├─────┼───────┼─────►│
+func (sd *sequenceDiagram) placeDividers() {
+	all := sd.allActorGroups()
+	for _, ag := range all {
+		if !ag.opts.DividerAfter || ag.last == len(sd.actors)-1 {
+			continue
+		}
+		left := ag.box.TopLeft.X + ag.box.Width
+		right := sd.actors[ag.last+1].TopLeft.X
+		for _, other := range all {
+			if other.first == ag.last+1 {
+				right = math.Min(right, other.box.TopLeft.X)
+			}
+		}
+		x := (left + right) / 2
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   DIVIDER_DECORATION,
+			Box:    geo.NewBox(geo.NewPoint(x-DIVIDER_WIDTH/2, 0), DIVIDER_WIDTH, sd.getHeight()),
+			ZIndex: BACKGROUND_Z_INDEX,
+		})
+	}
+}
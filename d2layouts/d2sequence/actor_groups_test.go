@@ -0,0 +1,281 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestNestedActorGroups(t *testing.T) {
+	// ┌──────────────────────────┐
+	// │outer                     │
+	// │ ┌───────┐                │
+	// │ │inner  │                │
+	// │ │ ┌───┐ │ ┌───┐          │  ┌───┐
+	// │ │ │ a │ │ │ b │          │  │ c │
+	// │ │ └─┬─┘ │ └─┬─┘          │  └─┬─┘
+	// │ └───┼───┘   │            │    │
+	// └─────┼───────┼────────────┘    │
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	c := g.Root.EnsureChild([]string{"c"})
+	c.Box = geo.NewBox(nil, 100, 100)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: c}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		ActorGroups: []d2sequence.ActorGroup{{
+			Label:  "outer",
+			Actors: []string{"b"},
+			Groups: []d2sequence.ActorGroup{{
+				Label:  "inner",
+				Actors: []string{"a"},
+			}},
+		}},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Decorations) != 2 {
+		t.Fatalf("expected 2 actor groups, got %d", len(g.Decorations))
+	}
+	outer, inner := g.Decorations[0], g.Decorations[1]
+	if outer.Label != "outer" || inner.Label != "inner" {
+		t.Fatalf("expected the outer group to be drawn before the inner one, got %q then %q", outer.Label, inner.Label)
+	}
+	for _, d := range g.Decorations {
+		if d.Kind != d2sequence.ACTOR_GROUP_DECORATION {
+			t.Fatalf("expected an actor group, got %s", d.Kind)
+		}
+	}
+
+	contains := func(outer, inner *geo.Box) bool {
+		return outer.TopLeft.X < inner.TopLeft.X && outer.TopLeft.Y < inner.TopLeft.Y &&
+			outer.TopLeft.X+outer.Width > inner.TopLeft.X+inner.Width && outer.TopLeft.Y+outer.Height > inner.TopLeft.Y+inner.Height
+	}
+	if !contains(inner.Box, a.Box) {
+		t.Fatal("expected the inner group to enclose a")
+	}
+	if !contains(outer.Box, inner.Box) {
+		t.Fatal("expected the outer group to enclose the inner group")
+	}
+	if !contains(outer.Box, b.Box) {
+		t.Fatal("expected the outer group to enclose b")
+	}
+	if contains(outer.Box, c.Box) || outer.TopLeft.X+outer.Width >= c.TopLeft.X {
+		t.Fatal("expected c to be outside of the groups")
+	}
+	if a.TopLeft.Y-inner.TopLeft.Y < d2sequence.ACTOR_GROUP_LABEL_HEIGHT || inner.TopLeft.Y-outer.TopLeft.Y < d2sequence.ACTOR_GROUP_LABEL_HEIGHT {
+		t.Fatal("expected room for the group titles above their content")
+	}
+	if inner.TopLeft.X-outer.TopLeft.X != d2sequence.ACTOR_GROUP_PADDING {
+		t.Fatal("expected the inner group title to be indented in the outer group")
+	}
+
+	root := geo.NewBox(geo.NewPoint(0, 0), g.Root.Width, g.Root.Height)
+	if !contains(root, outer.Box) {
+		t.Fatal("expected the diagram to fit the groups")
+	}
+	// both group borders on the right of a and the outer one on the right of b
+	if b.TopLeft.X-(a.TopLeft.X+a.Width) < d2sequence.ACTOR_GROUP_PADDING*2 {
+		t.Fatal("expected space between a and b for the inner group border")
+	}
+	if c.TopLeft.X-(b.TopLeft.X+b.Width) < d2sequence.ACTOR_GROUP_PADDING {
+		t.Fatal("expected space between b and c for the outer group border")
+	}
+}
+
+func TestActorGroupNotAdjacent(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	c := g.Root.EnsureChild([]string{"c"})
+	c.Box = geo.NewBox(nil, 100, 100)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: c}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		ActorGroups: []d2sequence.ActorGroup{{Label: "x", Actors: []string{"a", "c"}}},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err == nil {
+		t.Fatal("expected an error for a group of actors that are not next to each other")
+	}
+}
+
+func TestActorGroupTitleHeight(t *testing.T) {
+	layout := func(style d2graph.Style) (group *d2graph.Decoration, a *d2graph.Object) {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a = g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		opts := &d2sequence.ConfigurableOpts{
+			ActorGroups: []d2sequence.ActorGroup{{Label: "title", Actors: []string{"a"}, Style: style}},
+		}
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		if len(g.Decorations) != 1 {
+			t.Fatalf("expected 1 actor group, got %d", len(g.Decorations))
+		}
+		return g.Decorations[0], a
+	}
+
+	group, a := layout(d2graph.Style{})
+	assert.Equal(t, d2sequence.ACTOR_GROUP_PADDING+d2sequence.ACTOR_GROUP_LABEL_HEIGHT, a.TopLeft.Y-group.TopLeft.Y)
+
+	// a title taller than the default space pushes the actors down
+	group, a = layout(d2graph.Style{FontSize: &d2graph.Scalar{Value: "60"}})
+	assert.Equal(t, "60", group.Style.FontSize.Value)
+	if a.TopLeft.Y-group.TopLeft.Y < d2sequence.ACTOR_GROUP_PADDING+60 {
+		t.Fatalf("expected room for the title above a, got %v", a.TopLeft.Y-group.TopLeft.Y)
+	}
+}
+
+func TestActorGroupMessages(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+client; b; c; d
+client -> c: to the group
+c -> client: from the group
+b -> c: within the group
+client -> d: not a group message
+`), nil)
+	assert.Nil(t, err)
+	for _, actor := range g.Root.ChildrenArray {
+		actor.Box = geo.NewBox(nil, 100, 50)
+	}
+	toGroup, fromGroup, within, plain := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		ActorGroups: []d2sequence.ActorGroup{{Label: "backend", Actors: []string{"b", "c"}}},
+		Messages: map[string]d2sequence.MessageOpts{
+			toGroup.AbsID():   {ToActorGroup: true},
+			fromGroup.AbsID(): {FromActorGroup: true},
+			within.AbsID():    {ToActorGroup: true},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var group *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.ACTOR_GROUP_DECORATION {
+			group = d
+		}
+	}
+	if group == nil {
+		t.Fatal("expected an actor group")
+	}
+	cX, _ := d2sequence.LifelineX(g, g.Root.ChildrenArray[2])
+	clientX, _ := d2sequence.LifelineX(g, g.Root.ChildrenArray[0])
+
+	// a single arrow to the left border of the group, at the height of the message
+	assert.Equal(t, 2, len(toGroup.Route))
+	assert.Equal(t, group.TopLeft.X, toGroup.Route[1].X)
+	assert.Equal(t, toGroup.Route[0].Y, toGroup.Route[1].Y)
+	assert.Equal(t, clientX, toGroup.Route[0].X)
+
+	assert.Equal(t, group.TopLeft.X, fromGroup.Route[0].X)
+	assert.Equal(t, clientX, fromGroup.Route[1].X)
+
+	// the sender is in the group
+	assert.Equal(t, cX, within.Route[1].X)
+	dX, _ := d2sequence.LifelineX(g, g.Root.ChildrenArray[3])
+	assert.Equal(t, dX, plain.Route[1].X)
+}
+
+func TestActorGroupDivider(t *testing.T) {
+	layout := func(divider bool) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> c
+c -> b
+`), nil)
+		assert.Nil(t, err)
+		for _, actor := range g.Root.ChildrenArray {
+			actor.Box = geo.NewBox(nil, 100, 50)
+		}
+		ctx := log.WithTB(context.Background(), t, nil)
+		opts := &d2sequence.ConfigurableOpts{
+			ActorGroups: []d2sequence.ActorGroup{
+				{Label: "front", Actors: []string{"a", "b"}, DividerAfter: divider},
+				{Label: "back", Actors: []string{"c"}},
+			},
+		}
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	plain := layout(false)
+	g := layout(true)
+	var groups []*d2graph.Decoration
+	var divider *d2graph.Decoration
+	for _, d := range g.Decorations {
+		switch d.Kind {
+		case d2sequence.ACTOR_GROUP_DECORATION:
+			groups = append(groups, d)
+		case d2sequence.DIVIDER_DECORATION:
+			divider = d
+		}
+	}
+	if divider == nil || len(groups) != 2 {
+		t.Fatalf("expected two actor groups and a divider, got %d groups", len(groups))
+	}
+	front, back := groups[0], groups[1]
+	x := divider.Center().X
+	if x <= front.TopLeft.X+front.Width || x >= back.TopLeft.X {
+		t.Fatalf("expected the divider between the groups, got %v", x)
+	}
+	assert.Equal(t, (front.TopLeft.X+front.Width+back.TopLeft.X)/2, x)
+
+	// over the whole diagram
+	top, bottom := divider.TopLeft.Y, divider.TopLeft.Y+divider.Height
+	if top > front.TopLeft.Y {
+		t.Fatalf("expected the divider to start above the groups, it starts at %v", top)
+	}
+	for _, edge := range g.Edges {
+		for _, p := range edge.Route {
+			if p.Y > bottom {
+				t.Fatalf("expected the divider to cover %s, it ends at %v", edge.AbsID(), bottom)
+			}
+		}
+	}
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+
+	// the gap is wider to fit the divider
+	gap := func(g *d2graph.Graph) float64 {
+		b, _ := d2sequence.LifelineX(g, g.Root.ChildrenArray[1])
+		c, _ := d2sequence.LifelineX(g, g.Root.ChildrenArray[2])
+		return c - b
+	}
+	assert.Equal(t, gap(plain)+d2sequence.DIVIDER_GAP, gap(g))
+}
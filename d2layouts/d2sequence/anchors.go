@@ -0,0 +1,63 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// Anchor is where a named anchor of a laid out sequence diagram is, see ActorOpts.Anchors
+type Anchor struct {
+	Actor *d2graph.Object
+	Y     float64
+}
+
+// Anchors returns the anchors of a laid out sequence diagram by name
+func Anchors(g *d2graph.Graph) map[string]Anchor {
+	anchors := make(map[string]Anchor)
+	for _, d := range g.Decorations {
+		if d.Kind == ANCHOR_DECORATION {
+			anchors[d.Label] = Anchor{Actor: d.Object, Y: d.TopLeft.Y}
+		}
+	}
+	return anchors
+}
+
+// placeAnchors places the anchors of each actor on its lifeline as empty decorations
+func (sd *sequenceDiagram) placeAnchors() error {
+	messages := make(map[string]*d2graph.Edge, len(sd.messages))
+	for _, message := range sd.messages {
+		messages[sd.opts.messageID(message)] = message
+	}
+	names := make(map[string]bool)
+	for _, lifeline := range sd.lifelines {
+		actor := lifeline.Src
+		for _, anchor := range sd.actorOpts(actor).Anchors {
+			if names[anchor.Name] {
+				return errorf(VALIDATE_STAGE, actor, "anchor %#v is declared more than once", anchor.Name)
+			}
+			names[anchor.Name] = true
+
+			y := lifeline.Route[0].Y
+			if anchor.Message != "" {
+				message, has := messages[anchor.Message]
+				if !has {
+					return errorf(VALIDATE_STAGE, actor, "anchor %#v references %#v which is not a message", anchor.Name, anchor.Message)
+				}
+				y = math.Inf(1)
+				for _, p := range message.Route {
+					y = math.Min(y, p.Y)
+				}
+			}
+			sd.decorations = append(sd.decorations, &d2graph.Decoration{
+				Kind:   ANCHOR_DECORATION,
+				Box:    geo.NewBox(geo.NewPoint(lifeline.Route[0].X, y+anchor.Offset), 0, 0),
+				Label:  anchor.Name,
+				Object: actor,
+				ZIndex: MARKER_Z_INDEX,
+			})
+		}
+	}
+	return nil
+}
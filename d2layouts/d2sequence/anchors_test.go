@@ -0,0 +1,48 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestAnchors(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: first
+b -> a: second
+`), nil)
+	assert.Nil(t, err)
+	a, _ := g.Root.HasChild([]string{"a"})
+	b, _ := g.Root.HasChild([]string{"b"})
+	second := g.Edges[1]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"a": {Anchors: []d2sequence.LifelineAnchor{{Name: "start"}}},
+			"b": {Anchors: []d2sequence.LifelineAnchor{{Name: "checkpoint A", Message: second.AbsID(), Offset: 10}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	anchors := d2sequence.Anchors(g)
+	assert.Equal(t, 2, len(anchors))
+	checkpoint, has := anchors["checkpoint A"]
+	if !has {
+		t.Fatal("expected anchor checkpoint A")
+	}
+	assert.Equal(t, b, checkpoint.Actor)
+	assert.Equal(t, second.Route[0].Y+10, checkpoint.Y)
+	assert.Equal(t, a, anchors["start"].Actor)
+	assert.Equal(t, a.TopLeft.Y+a.Height, anchors["start"].Y)
+}
@@ -0,0 +1,179 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+// AppendMessage adds a message below the last one of a sequence diagram laid out with Layout, without moving anything
+// that is already placed. Lifelines, spans the message connects to and the diagram bounds are extended to fit it.
+// Both ends of the message must already be laid out, use Layout when the message adds actors or spans
+func AppendMessage(g *d2graph.Graph, message *d2graph.Edge) error {
+	if !g.Root.IsSequenceDiagram() || g.Root.Box == nil || g.Root.TopLeft == nil {
+		return errorf(VALIDATE_STAGE, g.Root, "%s is not a laid out sequence diagram", g.Root.AbsID())
+	}
+	for _, endpoint := range []*d2graph.Object{message.Src, message.Dst} {
+		top := endpoint
+		for top != nil && top.Parent != g.Root {
+			top = top.Parent
+		}
+		if top == nil || top.IsSequenceDiagramGroup() {
+			return errorf(VALIDATE_STAGE, endpoint, "could not find center of %s. Is it declared as an actor?", endpoint.ID)
+		}
+		if endpoint.Box == nil || endpoint.TopLeft == nil {
+			return errorf(ROUTE_STAGE, endpoint, "%s is not laid out, its diagram must be laid out again", endpoint.ID)
+		}
+	}
+
+	var lifelines []*d2graph.Edge
+	lastY := math.Inf(-1)
+	for _, edge := range g.Edges {
+		if edge == message {
+			continue
+		}
+		if IsLifelineEnd(edge.Dst) {
+			lifelines = append(lifelines, edge)
+			continue
+		}
+		for _, p := range edge.Route {
+			lastY = math.Max(lastY, p.Y)
+		}
+	}
+	for _, obj := range g.Objects {
+		if obj.IsSequenceDiagramNote() && obj.Box != nil && obj.TopLeft != nil {
+			lastY = math.Max(lastY, obj.TopLeft.Y+obj.Height)
+		}
+	}
+	lifelineEndY := math.Inf(-1)
+	for _, lifeline := range lifelines {
+		lifelineEndY = math.Max(lifelineEndY, lifeline.Route[len(lifeline.Route)-1].Y)
+	}
+	if math.IsInf(lastY, -1) {
+		// the first message goes where Layout would have placed it, below the actors
+		for _, actor := range Actors(g) {
+			lastY = math.Max(lastY, actor.TopLeft.Y+actor.Height)
+		}
+	}
+
+	// the diagram keeps its spacing, lifelines end one step after the last message
+	yStep := math.Max(MIN_MESSAGE_DISTANCE, float64(message.LabelDimensions.Height)) + VERTICAL_PAD
+	if !math.IsInf(lifelineEndY, -1) {
+		yStep = math.Max(yStep, lifelineEndY-lastY)
+	}
+	startY := lastY + yStep
+
+	startX := getCenter(message.Src).X
+	endX := getCenter(message.Dst).X
+	srcActor := message.Src
+	for srcActor.Parent != g.Root {
+		srcActor = srcActor.Parent
+	}
+	dstActor := message.Dst
+	for dstActor.Parent != g.Root {
+		dstActor = dstActor.Parent
+	}
+	if srcActor == dstActor {
+		midX := startX + SELF_MESSAGE_HORIZONTAL_TRAVEL
+		endY := startY + SELF_MESSAGE_HEIGHT
+		message.Route = []*geo.Point{
+			geo.NewPoint(startX, startY),
+			geo.NewPoint(midX, startY),
+			geo.NewPoint(midX, endY),
+			geo.NewPoint(endX, endY),
+		}
+	} else {
+		message.Route = []*geo.Point{
+			geo.NewPoint(startX, startY),
+			geo.NewPoint(endX, startY),
+		}
+	}
+	message.ZIndex = MESSAGE_Z_INDEX
+	if message.Label.Value != "" {
+		message.LabelPosition = go2.Pointer(label.InsideMiddleCenter.String())
+	}
+
+	// spans grow down to the message and the route is adjusted to their sides like adjustRouteEndpoints does
+	route := message.Route
+	srcRank := srcActor.Center().X
+	dstRank := dstActor.Center().X
+	if message.Src != srcActor {
+		growSpan(g, message.Src, route[0].Y)
+		if srcRank <= dstRank {
+			route[0].X += message.Src.Width / 2.
+		} else {
+			route[0].X -= message.Src.Width / 2.
+		}
+	}
+	if message.Dst != dstActor {
+		growSpan(g, message.Dst, route[len(route)-1].Y)
+		if srcRank < dstRank {
+			route[len(route)-1].X -= message.Dst.Width / 2.
+		} else {
+			route[len(route)-1].X += message.Dst.Width / 2.
+		}
+	}
+
+	messageBottom := startY
+	for _, p := range route {
+		messageBottom = math.Max(messageBottom, p.Y)
+	}
+	// the diagram ends one step below the message, like lifelines, even when they are left out with SkipLifelines
+	endY := messageBottom + yStep
+	contentEndY := lifelineEndY
+	if math.IsInf(contentEndY, -1) {
+		contentEndY = g.Root.TopLeft.Y + g.Root.Height - GROUP_CONTAINER_PADDING
+	}
+	growth := math.Max(0, endY-contentEndY)
+	for _, lifeline := range lifelines {
+		end := lifeline.Route[len(lifeline.Route)-1]
+		// lifelines ending earlier on purpose are left as is
+		if end.Y == lifelineEndY && end.Y < endY {
+			end.Y = endY
+		}
+	}
+	// self messages of the last actor and their labels can go further right than the diagram
+	messageRight := math.Inf(-1)
+	for _, p := range route {
+		messageRight = math.Max(messageRight, p.X)
+	}
+	if labelBox := edgeLabelBox(message); labelBox != nil {
+		messageRight = math.Max(messageRight, labelBox.TopLeft.X+labelBox.Width)
+	}
+	widthGrowth := math.Max(0, messageRight+GROUP_CONTAINER_PADDING-(g.Root.TopLeft.X+g.Root.Width))
+	g.Root.Width += widthGrowth
+	g.Root.Height += growth
+	for _, d := range g.Decorations {
+		if d.Kind == FRAME_DECORATION {
+			d.Width += widthGrowth
+			d.Height += growth
+		}
+	}
+
+	for _, edge := range g.Edges {
+		if edge == message {
+			return nil
+		}
+	}
+	// messages come before lifelines like with Layout
+	i := len(g.Edges)
+	for j, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			i = j
+			break
+		}
+	}
+	g.Edges = append(g.Edges[:i], append([]*d2graph.Edge{message}, g.Edges[i:]...)...)
+	return nil
+}
+
+// growSpan extends a span and the spans it is in down to y
+func growSpan(g *d2graph.Graph, span *d2graph.Object, y float64) {
+	for ; span.Parent != g.Root; span = span.Parent {
+		span.Height = math.Max(span.Height, y+SPAN_MESSAGE_PAD-span.TopLeft.Y)
+	}
+}
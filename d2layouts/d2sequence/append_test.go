@@ -0,0 +1,105 @@
+package d2sequence_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestAppendMessage(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b
+a -> b.t
+b.t -> a
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	routes := func() []string {
+		var routes []string
+		for _, e := range g.Edges[:2] {
+			var route []string
+			for _, p := range e.Route {
+				route = append(route, fmt.Sprint(*p))
+			}
+			routes = append(routes, strings.Join(route, " "))
+		}
+		return routes
+	}
+	before := routes()
+	height := g.Root.Height
+	a, _ := g.Root.HasChild([]string{"a"})
+	b_t, _ := g.Root.HasChild([]string{"b", "t"})
+	spanBottom := b_t.TopLeft.Y + b_t.Height
+
+	message := &d2graph.Edge{Src: a, Dst: b_t}
+	if err := d2sequence.AppendMessage(g, message); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, before, routes())
+	if message != g.Edges[2] {
+		t.Fatal("expected the message to be added after the other messages")
+	}
+	last := g.Edges[1].Route[len(g.Edges[1].Route)-1]
+	if message.Route[0].Y <= last.Y {
+		t.Fatal("expected the message below the last one")
+	}
+	if message.Route[1].X != b_t.TopLeft.X {
+		t.Fatal("expected the message to end on the span side")
+	}
+	if b_t.TopLeft.Y+b_t.Height <= spanBottom || b_t.TopLeft.Y+b_t.Height < message.Route[1].Y {
+		t.Fatal("expected the span to grow down to the message")
+	}
+	if g.Root.Height <= height {
+		t.Fatal("expected the diagram to grow")
+	}
+	for _, e := range g.Edges[3:] {
+		if end := e.Route[len(e.Route)-1]; end.Y <= message.Route[0].Y {
+			t.Fatal("expected the lifelines to extend past the message")
+		}
+	}
+}
+
+func TestAppendMessageWithoutLifelines(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{SkipLifelines: true}); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.Root.HasChild([]string{"a"})
+	b, _ := g.Root.HasChild([]string{"b"})
+
+	// the diagram grows with the messages even though there are no lifelines to extend
+	for i := 0; i < 3; i++ {
+		height := g.Root.Height
+		message := &d2graph.Edge{Src: a, Dst: b}
+		if err := d2sequence.AppendMessage(g, message); err != nil {
+			t.Fatal(err)
+		}
+		if g.Root.Height <= height {
+			t.Fatalf("expected the diagram to grow with message %d", i)
+		}
+		if err := d2sequence.CheckBounds(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
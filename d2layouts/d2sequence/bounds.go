@@ -0,0 +1,118 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+// Bounds returns the box of a laid out sequence diagram, kept up to date by Layout, AppendMessage and ReflowMessages,
+// nil if it is not laid out
+func Bounds(g *d2graph.Graph) *geo.Box {
+	if g.Root.Box == nil || g.Root.TopLeft == nil {
+		return nil
+	}
+	return g.Root.Box.Copy()
+}
+
+// CheckBounds checks that every object, message, lifeline and decoration of a laid out sequence diagram,
+// including their labels, is within the diagram box. It returns a *LayoutError for the first one that is not
+func CheckBounds(g *d2graph.Graph) error {
+	if g.Root.Box == nil || g.Root.TopLeft == nil {
+		return errorf(BOUNDS_STAGE, g.Root, "%s is not laid out", g.Root.AbsID())
+	}
+	bounds := g.Root.Box
+	contains := func(tl *geo.Point, width, height float64) bool {
+		return tl.X >= bounds.TopLeft.X && tl.Y >= bounds.TopLeft.Y &&
+			tl.X+width <= bounds.TopLeft.X+bounds.Width && tl.Y+height <= bounds.TopLeft.Y+bounds.Height
+	}
+
+	for _, obj := range g.Objects {
+		if obj.Box == nil || obj.TopLeft == nil {
+			return errorf(BOUNDS_STAGE, obj, "%s is not placed", obj.AbsID())
+		}
+		if !contains(obj.TopLeft, obj.Width, obj.Height) {
+			return errorf(BOUNDS_STAGE, obj, "%s is out of the diagram bounds", obj.AbsID())
+		}
+		if labelBox := objectLabelBox(obj); labelBox != nil && !contains(labelBox.TopLeft, labelBox.Width, labelBox.Height) {
+			return errorf(BOUNDS_STAGE, obj, "label of %s is out of the diagram bounds", obj.AbsID())
+		}
+	}
+
+	for _, edge := range g.Edges {
+		for _, p := range edge.Route {
+			if !contains(p, 0, 0) {
+				return edgeErrorf(BOUNDS_STAGE, edge, "%s is out of the diagram bounds", edge.AbsID())
+			}
+		}
+		if labelBox := edgeLabelBox(edge); labelBox != nil && !contains(labelBox.TopLeft, labelBox.Width, labelBox.Height) {
+			return edgeErrorf(BOUNDS_STAGE, edge, "label of %s is out of the diagram bounds", edge.AbsID())
+		}
+	}
+
+	for _, d := range g.Decorations {
+		if !contains(d.TopLeft, d.Width, d.Height) {
+			if d.Edge != nil {
+				return edgeErrorf(BOUNDS_STAGE, d.Edge, "%s of %s is out of the diagram bounds", d.Kind, d.Edge.AbsID())
+			}
+			return errorf(BOUNDS_STAGE, d.Object, "%s is out of the diagram bounds", d.Kind)
+		}
+	}
+	return nil
+}
+
+// CheckHorizontalMessages checks that every message of a laid out sequence diagram has both of its ends at the same
+// height, catching layout bugs. Self messages, which loop back to the lifeline they start from, and lifelines are left
+// out. Curved messages only need level ends. It returns a *LayoutError for the first message that is not horizontal
+func CheckHorizontalMessages(g *d2graph.Graph) error {
+	actorOf := func(obj *d2graph.Object) *d2graph.Object {
+		for obj.Parent != nil && obj.Parent != g.Root {
+			obj = obj.Parent
+		}
+		return obj
+	}
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) || actorOf(edge.Src) == actorOf(edge.Dst) {
+			continue
+		}
+		if len(edge.Route) < 2 {
+			return edgeErrorf(ROUTE_STAGE, edge, "%s is not routed", edge.AbsID())
+		}
+		if start, end := edge.Route[0], edge.Route[len(edge.Route)-1]; start.Y != end.Y {
+			return edgeErrorf(ROUTE_STAGE, edge, "%s is not horizontal, it goes from y %v to %v", edge.AbsID(), start.Y, end.Y)
+		}
+	}
+	return nil
+}
+
+// objectLabelBox returns where the label of a placed object is, nil if it has none
+func objectLabelBox(obj *d2graph.Object) *geo.Box {
+	if !obj.HasLabel() || obj.LabelPosition == nil {
+		return nil
+	}
+	width := float64(obj.LabelDimensions.Width)
+	height := float64(obj.LabelDimensions.Height)
+	labelTL := label.FromString(*obj.LabelPosition).GetPointOnBox(obj.Box, label.PADDING, width, height)
+	if labelTL == nil {
+		return nil
+	}
+	return geo.NewBox(labelTL, width, height)
+}
+
+// edgeLabelBox returns where the label of a routed edge is, nil if it has none
+func edgeLabelBox(edge *d2graph.Edge) *geo.Box {
+	if edge.Label.Value == "" || edge.LabelPosition == nil {
+		return nil
+	}
+	labelPercentage := 0.5
+	if edge.LabelPercentage != nil {
+		labelPercentage = *edge.LabelPercentage
+	}
+	width := float64(edge.LabelDimensions.Width)
+	height := float64(edge.LabelDimensions.Height)
+	labelTL, _ := label.FromString(*edge.LabelPosition).GetPointOnRoute(edge.Route, 0, labelPercentage, width, height)
+	if labelTL == nil {
+		return nil
+	}
+	return geo.NewBox(labelTL, width, height)
+}
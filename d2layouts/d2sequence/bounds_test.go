@@ -0,0 +1,137 @@
+package d2sequence_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestCheckBounds(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	b_t1 := b.EnsureChild([]string{"t1"})
+	call := &d2graph.Edge{Src: a, Dst: b_t1}
+	call.Label.Value = "call"
+	call.LabelDimensions = d2target.TextDimensions{Width: 40, Height: 20}
+	ret := &d2graph.Edge{Src: b_t1, Dst: a}
+	g.Edges = []*d2graph.Edge{call, ret}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatalf("expected the layout to be within bounds, got %v", err)
+	}
+
+	ret.Route[1].X = g.Root.TopLeft.X - 10
+	var layoutErr *d2sequence.LayoutError
+	err := d2sequence.CheckBounds(g)
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a LayoutError, got %v", err)
+	}
+	if layoutErr.Edge != ret || layoutErr.Stage != d2sequence.BOUNDS_STAGE {
+		t.Fatalf("expected the corrupted route to be flagged, got %v", err)
+	}
+	ret.Route[1].X = a.Center().X
+
+	call.LabelDimensions.Width = int(g.Root.Width) * 2
+	err = d2sequence.CheckBounds(g)
+	if !errors.As(err, &layoutErr) || layoutErr.Edge != call {
+		t.Fatalf("expected the overflowing label to be flagged, got %v", err)
+	}
+	call.LabelDimensions.Width = 40
+
+	b_t1.TopLeft.Y = g.Root.Height + 1
+	err = d2sequence.CheckBounds(g)
+	if !errors.As(err, &layoutErr) || layoutErr.Object != b_t1 {
+		t.Fatalf("expected the corrupted span to be flagged, got %v", err)
+	}
+}
+
+func TestBoundsAfterAppend(t *testing.T) {
+	compile := func(input string) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		for _, e := range g.Edges {
+			if e.Label.Value != "" {
+				e.LabelDimensions = d2target.TextDimensions{Width: 60, Height: 20}
+			}
+		}
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.Layout(ctx, g, nil); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	g := compile("shape: sequence_diagram\na; b\na -> b\n")
+	before := d2sequence.Bounds(g)
+	b, _ := g.Root.HasChild([]string{"b"})
+	message := &d2graph.Edge{
+		Src: b,
+		Dst: b,
+		Attributes: d2graph.Attributes{
+			Label:           d2graph.Scalar{Value: "loop"},
+			LabelDimensions: d2target.TextDimensions{Width: 60, Height: 20},
+		},
+	}
+	if err := d2sequence.AppendMessage(g, message); err != nil {
+		t.Fatal(err)
+	}
+	after := d2sequence.Bounds(g)
+	if after.Width <= before.Width || after.Height <= before.Height {
+		t.Fatalf("expected the bounds %v to grow from %v", after.ToString(), before.ToString())
+	}
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same as laying out the diagram with the message
+	relaidOut := d2sequence.Bounds(compile("shape: sequence_diagram\na; b\na -> b\nb -> b: loop\n"))
+	assert.Equal(t, *relaidOut, *after)
+}
+
+func TestCheckHorizontalMessages(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.t: call
+b.t -> b.t: think
+b.t -> a: return
+a -> a: self
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{Curvature: 0.1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d2sequence.CheckHorizontalMessages(g); err != nil {
+		t.Fatalf("expected the messages to be horizontal, got %v", err)
+	}
+
+	ret := g.Edges[2]
+	ret.Route[len(ret.Route)-1].Y += 5
+	var layoutErr *d2sequence.LayoutError
+	err = d2sequence.CheckHorizontalMessages(g)
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a LayoutError, got %v", err)
+	}
+	if layoutErr.Edge != ret || layoutErr.Stage != d2sequence.ROUTE_STAGE {
+		t.Fatalf("expected the corrupted route to be flagged, got %v", err)
+	}
+}
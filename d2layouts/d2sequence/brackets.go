@@ -0,0 +1,119 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+// placeDurationBrackets places the duration brackets in a gutter on the right of everything placed so far,
+// including self messages of the last actor. Brackets that overlap vertically are placed in separate columns
+// . ┌───┐     ┌───┐
+// . │ a │     │ b │
+// . └─┬─┘     └─┬─┘
+// .   ├────────►│     ┐
+// .   │◄────────┤     ┘ 200ms
+func (sd *sequenceDiagram) placeDurationBrackets() error {
+	if len(sd.opts.DurationBrackets) == 0 {
+		return nil
+	}
+	messageIndex := make(map[string]int, len(sd.messages))
+	for i, message := range sd.messages {
+		messageIndex[sd.opts.messageID(message)] = i
+	}
+
+	type bracket struct {
+		opts   DurationBracket
+		top    float64
+		bottom float64
+		// the bracket and its label
+		width  float64
+		column int
+	}
+	var brackets []*bracket
+	var columnWidths []float64
+	var columns [][]*bracket
+	for _, opts := range sd.opts.DurationBrackets {
+		from, hasFrom := messageIndex[opts.From]
+		if !hasFrom {
+			return errorf(VALIDATE_STAGE, nil, "duration bracket %#v references %#v which is not a message", opts.Label, opts.From)
+		}
+		to, hasTo := messageIndex[opts.To]
+		if !hasTo {
+			return errorf(VALIDATE_STAGE, nil, "duration bracket %#v references %#v which is not a message", opts.Label, opts.To)
+		}
+		if to < from {
+			return edgeErrorf(VALIDATE_STAGE, sd.messages[to], "duration bracket %#v ends at %s before it starts at %s", opts.Label, opts.To, opts.From)
+		}
+
+		b := &bracket{
+			opts:   opts,
+			top:    math.Inf(1),
+			bottom: math.Inf(-1),
+			width:  DURATION_BRACKET_WIDTH,
+		}
+		for _, message := range sd.messages[from : to+1] {
+			for _, p := range message.Route {
+				b.top = math.Min(b.top, p.Y)
+				b.bottom = math.Max(b.bottom, p.Y)
+			}
+		}
+		if opts.Label != "" {
+			dims, err := sd.measureText(&d2target.MText{
+				Text:     opts.Label,
+				FontSize: d2fonts.FONT_SIZE_M,
+			})
+			if err != nil {
+				return err
+			}
+			b.width += DURATION_BRACKET_LABEL_GAP + float64(dims.Width)
+		}
+
+		// the first column without a bracket overlapping this one
+		b.column = len(columns)
+		for i, column := range columns {
+			overlaps := false
+			for _, other := range column {
+				if b.top <= other.bottom && other.top <= b.bottom {
+					overlaps = true
+					break
+				}
+			}
+			if !overlaps {
+				b.column = i
+				break
+			}
+		}
+		if b.column == len(columns) {
+			columns = append(columns, nil)
+			columnWidths = append(columnWidths, 0)
+		}
+		columns[b.column] = append(columns[b.column], b)
+		columnWidths[b.column] = math.Max(columnWidths[b.column], b.width)
+		brackets = append(brackets, b)
+	}
+
+	content := sd.contentBounds()
+	columnXs := make([]float64, len(columns))
+	x := content.TopLeft.X + content.Width + DURATION_BRACKET_GAP
+	for i, width := range columnWidths {
+		columnXs[i] = x
+		x += width + DURATION_BRACKET_GAP
+	}
+	for _, b := range brackets {
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:          DURATION_BRACKET_DECORATION,
+			Box:           geo.NewBox(geo.NewPoint(columnXs[b.column], b.top), b.width, b.bottom-b.top),
+			Label:         b.opts.Label,
+			LabelPosition: go2.Pointer(label.InsideMiddleRight.String()),
+			ZIndex:        LABEL_Z_INDEX,
+		})
+	}
+	return nil
+}
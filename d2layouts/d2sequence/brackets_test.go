@@ -0,0 +1,71 @@
+package d2sequence_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestDurationBrackets(t *testing.T) {
+	layout := func(brackets []d2sequence.DurationBracket) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: call
+b -> b: work
+b -> a: return
+`), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{DurationBrackets: brackets})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	without := layout(nil)
+	g := layout([]d2sequence.DurationBracket{{
+		Label: "200ms",
+		From:  without.Edges[0].AbsID(),
+		To:    without.Edges[2].AbsID(),
+	}})
+	if g.Root.Width <= without.Root.Width {
+		t.Fatalf("expected the right margin to expand for the bracket, got a width of %v for %v", g.Root.Width, without.Root.Width)
+	}
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+
+	var bracket *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.DURATION_BRACKET_DECORATION {
+			bracket = d
+		}
+	}
+	if bracket == nil {
+		t.Fatal("expected a duration bracket")
+	}
+	assert.Equal(t, "200ms", bracket.Label)
+	call, ret := g.Edges[0], g.Edges[2]
+	assert.Equal(t, call.Route[0].Y, bracket.TopLeft.Y)
+	assert.Equal(t, ret.Route[0].Y, bracket.TopLeft.Y+bracket.Height)
+
+	// the bracket is right of the self message loop and its label
+	selfMessage := g.Edges[1]
+	overhang := math.Inf(-1)
+	for _, p := range selfMessage.Route {
+		overhang = math.Max(overhang, p.X)
+	}
+	if bracket.TopLeft.X <= overhang {
+		t.Fatalf("expected the bracket at %v to be right of the self message at %v", bracket.TopLeft.X, overhang)
+	}
+}
@@ -0,0 +1,116 @@
+package d2sequence
+
+import (
+	"math"
+	"strconv"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// CanvasOpts is a fixed size the diagram is laid out in, e.g. for a slide or a thumbnail
+type CanvasOpts struct {
+	Width  float64
+	Height float64
+	// ScaleDown shrinks a diagram larger than the canvas to fit it, text included, instead of returning an error
+	ScaleDown bool
+}
+
+// FitAspect fits a laid out diagram in a slot of the given aspect ratio, width over height, without distorting it.
+// The slot is 1 high and ratio wide: scale is the factor to draw the diagram at and box is where the scaled diagram
+// goes in the slot, centered with equal margins on opposite sides. Both scale linearly to a slot h high, e.g. the
+// diagram is drawn at scale*h. It returns 0 and nil if the diagram is not laid out or the ratio is not positive
+func FitAspect(g *d2graph.Graph, ratio float64) (scale float64, box *geo.Box) {
+	bounds := Bounds(g)
+	if bounds == nil || bounds.Width <= 0 || bounds.Height <= 0 || ratio <= 0 {
+		return 0, nil
+	}
+	scale = math.Min(ratio/bounds.Width, 1/bounds.Height)
+	width, height := bounds.Width*scale, bounds.Height*scale
+	return scale, geo.NewBox(geo.NewPoint((ratio-width)/2, (1-height)/2), width, height)
+}
+
+// fitCanvas centers the laid out diagram in the canvas, with equal margins on opposite sides,
+// and makes the root the size of the canvas
+func (sd *sequenceDiagram) fitCanvas(root *d2graph.Object, canvas CanvasOpts) error {
+	if canvas.Width <= 0 || canvas.Height <= 0 {
+		return errorf(BOUNDS_STAGE, root, "canvas of %vx%v must have a positive width and height", canvas.Width, canvas.Height)
+	}
+	width, height := root.Width, root.Height
+	if width > canvas.Width || height > canvas.Height {
+		if !canvas.ScaleDown {
+			return errorf(BOUNDS_STAGE, root, "diagram of %vx%v does not fit in the canvas of %vx%v", width, height, canvas.Width, canvas.Height)
+		}
+		if sd.opts.actorOffsets != nil {
+			return errorf(BOUNDS_STAGE, root, "diagram of %vx%v must be scaled down to fit in the canvas of %vx%v, which would move the actors held in place by ReflowMessages", width, height, canvas.Width, canvas.Height)
+		}
+		scale := math.Min(canvas.Width/width, canvas.Height/height)
+		sd.scale(root.TopLeft, scale)
+		width *= scale
+		height *= scale
+	}
+	sd.shift(geo.NewPoint((canvas.Width-width)/2, (canvas.Height-height)/2))
+	root.Width = canvas.Width
+	root.Height = canvas.Height
+	return nil
+}
+
+// scale resizes the diagram around origin, with the font sizes and label dimensions.
+// Their unscaled values are kept for the next layout of the graph
+func (sd *sequenceDiagram) scale(origin *geo.Point, scale float64) {
+	scalePoint := func(p *geo.Point) {
+		p.X = origin.X + (p.X-origin.X)*scale
+		p.Y = origin.Y + (p.Y-origin.Y)*scale
+	}
+	scaleFont := func(style *d2graph.Style, fontSize int) {
+		style.FontSize = &d2graph.Scalar{Value: strconv.Itoa(int(math.Max(1, math.Round(float64(fontSize)*scale))))}
+	}
+	scaleLabel := func(style *d2graph.Style, dimensions *d2target.TextDimensions, fontSize int) {
+		scaleFont(style, fontSize)
+		dimensions.Width = int(math.Ceil(float64(dimensions.Width) * scale))
+		dimensions.Height = int(math.Ceil(float64(dimensions.Height) * scale))
+	}
+
+	allObjects := append([]*d2graph.Object{}, sd.actors...)
+	allObjects = append(allObjects, sd.spans...)
+	allObjects = append(allObjects, sd.groups...)
+	allObjects = append(allObjects, sd.notes...)
+	for _, obj := range allObjects {
+		scalePoint(obj.TopLeft)
+		obj.Width *= scale
+		obj.Height *= scale
+		if obj.HasLabel() {
+			fontSize := obj.Text().FontSize
+			if obj.Class != nil || obj.SQLTable != nil {
+				fontSize -= d2target.HeaderFontAdd
+			}
+			scaleLabel(&obj.Style, &obj.LabelDimensions, fontSize)
+		}
+	}
+
+	allEdges := append([]*d2graph.Edge{}, sd.messages...)
+	allEdges = append(allEdges, sd.lifelines...)
+	for _, edge := range allEdges {
+		for _, p := range edge.Route {
+			scalePoint(p)
+		}
+		if edge.Label.Value != "" {
+			scaleLabel(&edge.Style, &edge.LabelDimensions, edge.Text().FontSize)
+		}
+	}
+
+	for _, d := range sd.decorations {
+		scalePoint(d.TopLeft)
+		d.Width *= scale
+		d.Height *= scale
+		if d.Label != "" {
+			fontSize := d2fonts.FONT_SIZE_M
+			if d.Style.FontSize != nil {
+				fontSize, _ = strconv.Atoi(d.Style.FontSize.Value)
+			}
+			scaleFont(&d.Style, fontSize)
+		}
+	}
+}
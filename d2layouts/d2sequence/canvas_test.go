@@ -0,0 +1,114 @@
+package d2sequence_test
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestCanvas(t *testing.T) {
+	layout := func(canvas *d2sequence.CanvasOpts) (*d2graph.Graph, error) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: hello
+b -> a
+`), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		return g, d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{Canvas: canvas})
+	}
+
+	g, err := layout(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := g.Root.Box.Copy()
+	a, _ := g.Root.HasChild([]string{"a"})
+	contentA := a.TopLeft.Copy()
+
+	g, err = layout(&d2sequence.CanvasOpts{Width: content.Width + 300, Height: content.Height + 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, content.Width+300, g.Root.Width)
+	assert.Equal(t, content.Height+100, g.Root.Height)
+	a, _ = g.Root.HasChild([]string{"a"})
+	assert.Equal(t, contentA.X+150, a.TopLeft.X)
+	assert.Equal(t, contentA.Y+50, a.TopLeft.Y)
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+
+	// the margins are the same on both sides, the content is centered
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for _, obj := range g.Objects {
+		minX = math.Min(minX, obj.TopLeft.X)
+		maxX = math.Max(maxX, obj.TopLeft.X+obj.Width)
+	}
+	assert.InDelta(t, minX-g.Root.TopLeft.X, g.Root.TopLeft.X+g.Root.Width-maxX, 1e-9)
+
+	_, err = layout(&d2sequence.CanvasOpts{Width: content.Width / 2, Height: content.Height})
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Stage != d2sequence.BOUNDS_STAGE {
+		t.Fatalf("expected a bounds error for a diagram larger than the canvas, got %v", err)
+	}
+
+	g, err = layout(&d2sequence.CanvasOpts{Width: content.Width / 2, Height: content.Height, ScaleDown: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, content.Width/2, g.Root.Width)
+	a, _ = g.Root.HasChild([]string{"a"})
+	assert.InDelta(t, contentA.X/2, a.TopLeft.X, 1e-9)
+	assert.Equal(t, content.Height/4, a.TopLeft.Y-contentA.Y/2)
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFitAspect(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d; e
+a -> e: wide
+`), nil)
+	assert.Nil(t, err)
+	scale, box := d2sequence.FitAspect(g, 1)
+	assert.Equal(t, 0., scale)
+	assert.Nil(t, box)
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	width, height := g.Root.Width, g.Root.Height
+	if width <= height {
+		t.Fatalf("expected a wide diagram, got %vx%v", width, height)
+	}
+
+	// a wide diagram fills the width of a square and is centered vertically
+	scale, box = d2sequence.FitAspect(g, 1)
+	assert.InDelta(t, 1/width, scale, 1e-9)
+	assert.InDelta(t, 0, box.TopLeft.X, 1e-9)
+	assert.InDelta(t, 1, box.Width, 1e-9)
+	assert.InDelta(t, height/width, box.Height, 1e-9)
+	assert.InDelta(t, 1-box.Height, box.TopLeft.Y*2, 1e-9)
+	// undistorted
+	assert.InDelta(t, width/height, box.Width/box.Height, 1e-9)
+
+	// a slot wider than the diagram leaves margins on the sides instead
+	scale, box = d2sequence.FitAspect(g, 2*width/height)
+	assert.InDelta(t, 1/height, scale, 1e-9)
+	assert.InDelta(t, 0, box.TopLeft.Y, 1e-9)
+	assert.InDelta(t, width/height/2, box.TopLeft.X, 1e-9)
+}
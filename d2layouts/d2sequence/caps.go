@@ -0,0 +1,47 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// LineCap is how tools drawing from the graph end a message line, like the SVG stroke-linecap
+type LineCap string
+
+const (
+	// LineCapButt ends the line exactly at its end point
+	LineCapButt LineCap = "butt"
+	// LineCapRound ends the line with a half circle past its end point, half the stroke width in radius
+	LineCapRound LineCap = "round"
+	// LineCapSquare ends the line with a half square past its end point, half the stroke width long
+	LineCapSquare LineCap = "square"
+)
+
+// METADATA_PREFIX starts the metadata keys set by this package, other keys belong to the tools working on the graph
+const METADATA_PREFIX = "d2sequence."
+
+// metadata keys the line caps of a message are kept under, behind the reserved METADATA_PREFIX,
+// see d2graph.Attributes.Metadata
+const (
+	START_CAP_METADATA = METADATA_PREFIX + "line-cap.start"
+	END_CAP_METADATA   = METADATA_PREFIX + "line-cap.end"
+)
+
+// SetLineCaps sets the caps of the start and the end of a message. They are kept in its metadata under reserved keys,
+// which Layout leaves untouched, for tools drawing the message to read back with LineCaps. An empty cap removes it
+func SetLineCaps(message *d2graph.Edge, start, end LineCap) {
+	for key, lineCap := range map[string]LineCap{START_CAP_METADATA: start, END_CAP_METADATA: end} {
+		if lineCap == "" {
+			delete(message.Metadata, key)
+			continue
+		}
+		if message.Metadata == nil {
+			message.Metadata = make(map[string]string)
+		}
+		message.Metadata[key] = string(lineCap)
+	}
+}
+
+// LineCaps returns the caps of the start and the end of a message set with SetLineCaps, empty when it has none
+func LineCaps(message *d2graph.Edge) (start, end LineCap) {
+	return LineCap(message.Metadata[START_CAP_METADATA]), LineCap(message.Metadata[END_CAP_METADATA])
+}
@@ -0,0 +1,99 @@
+package d2sequence
+
+import (
+	"math"
+	"sort"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// compactVertical moves messages and notes up to remove the vertical space they don't need, e.g. the space
+// the tallest label makes every row take. Each message keeps the distance its own label needs
+// and the order is unchanged. Spans and groups are placed after, so they follow their messages.
+// The gaps made on purpose, see sequenceDiagram.spacedAfter, are kept and messages stay below the headers
+// of the actors with an ActorOpts.EntryY
+func (sd *sequenceDiagram) compactVertical() {
+	type row struct {
+		top     float64
+		bottom  float64
+		message *d2graph.Edge
+		// the most the row can move up
+		maxShift float64
+		move     func(dy float64)
+	}
+	var rows []row
+	for _, message := range sd.messages {
+		message := message
+		labelHeight := float64(message.LabelDimensions.Height)
+		if guard, has := sd.guards[message]; has {
+			labelHeight = math.Max(labelHeight, float64(guard.Height))
+		}
+		halfHeight := (math.Max(labelHeight, MIN_MESSAGE_DISTANCE) + VERTICAL_PAD) / 2.
+		minY := math.Inf(1)
+		maxY := math.Inf(-1)
+		for _, p := range message.Route {
+			minY = math.Min(minY, p.Y)
+			maxY = math.Max(maxY, p.Y)
+		}
+		maxShift := math.Inf(1)
+		for _, actor := range []*d2graph.Object{message.Src, message.Dst} {
+			for !actor.Parent.IsSequenceDiagram() {
+				actor = actor.Parent
+			}
+			if sd.actorOpts(actor).EntryY != nil {
+				maxShift = math.Min(maxShift, message.Route[0].Y-(actor.TopLeft.Y+actor.Height))
+			}
+		}
+		rows = append(rows, row{
+			top:      minY - halfHeight,
+			bottom:   maxY + halfHeight,
+			message:  message,
+			maxShift: maxShift,
+			move: func(dy float64) {
+				for _, p := range message.Route {
+					p.Y += dy
+				}
+			},
+		})
+	}
+	for _, note := range sd.notes {
+		note := note
+		halfPad := (MIN_MESSAGE_DISTANCE + VERTICAL_PAD) / 2.
+		rows = append(rows, row{
+			top:      note.TopLeft.Y - halfPad,
+			bottom:   note.TopLeft.Y + note.Height + halfPad,
+			maxShift: math.Inf(1),
+			move: func(dy float64) {
+				note.TopLeft.Y += dy
+			},
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+	sort.SliceStable(rows, func(i, j int) bool {
+		return rows[i].top < rows[j].top
+	})
+
+	// the first row stays in place, every gap after it is removed from the rows below.
+	// A row that moves less than the rows above takes the rows below with it, so they stay apart
+	bottom := rows[0].top
+	shift := 0.
+	shifts := make(map[*d2graph.Edge]float64)
+	for _, r := range rows {
+		if r.top > bottom {
+			shift += r.top - bottom
+		}
+		if prev, has := sd.spacedAfter[r.message]; has {
+			shift = math.Min(shift, shifts[prev])
+		}
+		shift = math.Min(shift, r.maxShift)
+		if r.message != nil {
+			shifts[r.message] = shift
+		}
+		bottom = math.Max(bottom, r.bottom)
+		if shift > 0 {
+			r.move(-shift)
+		}
+	}
+}
@@ -13,6 +13,9 @@ const MIN_ACTOR_WIDTH = 100.
 
 const SELF_MESSAGE_HORIZONTAL_TRAVEL = 80.
 
+// vertical size of a self message loop, see MessageOpts.SelfMessageHeight
+const SELF_MESSAGE_HEIGHT = MIN_MESSAGE_DISTANCE * 1.5
+
 const GROUP_CONTAINER_PADDING = 12.
 
 const EDGE_GROUP_LABEL_PADDING = 20.
@@ -31,17 +34,176 @@ const MIN_SPAN_HEIGHT = 30.
 
 const SPAN_MESSAGE_PAD = 10.
 
+// horizontal step between a span and the spans nested in it, see ActorOpts.NestingDirection
+const SPAN_NESTING_OFFSET = SPAN_BASE_WIDTH / 2.
+
+// size of spans drawn with ActivationStyleInline
+const INLINE_SPAN_HEIGHT = 12.
+
+const INLINE_SPAN_WIDTH = 6.
+
 const LIFELINE_STROKE_WIDTH int = 2
 
 const LIFELINE_STROKE_DASH int = 6
 
+// dash of the lifelines of external actors, longer than LIFELINE_STROKE_DASH to tell them apart, see ActorOpts.External
+const EXTERNAL_LIFELINE_STROKE_DASH int = 12
+
+// class of the lifelines of external actors
+const EXTERNAL_CLASS = "external"
+
+// space between the label of a state invariant and its box, see NoteOpts.State
+const STATE_PADDING = 8.
+
+const STATE_BORDER_RADIUS int = 12
+
 // pad when the actor has the label placed OutsideMiddleBottom so that the lifeline is not so close to the text
 const LIFELINE_LABEL_PAD = 5.
 
+// layers of the elements placed by the layout, higher z-indexes are drawn on top
+const (
+	BACKGROUND_Z_INDEX = 0
+	LIFELINE_Z_INDEX   = 1
+	SPAN_Z_INDEX       = 2
+	GROUP_Z_INDEX      = 3
+	MESSAGE_Z_INDEX    = 4
+	NOTE_Z_INDEX       = 5
+	// labels the layout adds next to elements, like message guards
+	LABEL_Z_INDEX = 6
+	// markers on top of everything, like gates
+	MARKER_Z_INDEX = 7
+)
+
+// owner of the decorations placed by the layout, which removes them when laying out the graph again,
+// see d2graph.Decoration.Owner
+const DECORATION_OWNER = "d2sequence"
+
+// kinds of the decorations placed by the layout, see d2graph.Decoration
+const (
+	BAND_DECORATION        = "band"
+	GUARD_DECORATION       = "guard"
+	ACTOR_GROUP_DECORATION = "actor_group"
+	FRAME_DECORATION       = "frame"
+	FRAME_TAB_DECORATION   = "frame_tab"
+	GATE_DECORATION        = "gate"
+	HALO_DECORATION        = "halo"
+	// the bracket is drawn on the left side of the box and the label on its right, see placeDurationBrackets
+	DURATION_BRACKET_DECORATION = "duration_bracket"
+	// anchors are not drawn, their label is their name, see Anchors
+	ANCHOR_DECORATION = "anchor"
+	// the box is the space between two shared spans, over their height
+	SHARED_ACTIVATION_DECORATION = "shared_activation"
+	// the operator of a combined fragment, its class is the operator
+	FRAGMENT_TAB_DECORATION = "fragment_tab"
+	// the stereotype of an actor, see ActorOpts.Stereotype
+	STEREOTYPE_DECORATION = "stereotype"
+	// a horizontal stub from the bottom of the span of an unanswered call towards the caller,
+	// drawn from the side of the box next to the span
+	LOST_RETURN_DECORATION = "lost_return"
+	// the brackets of a coregion are drawn at the top and bottom of the box, across the lifeline
+	COREGION_DECORATION = "coregion"
+	// the return value of a reply above its arrowhead, see MessageOpts.ReturnValue
+	RETURN_VALUE_DECORATION = "return_value"
+	// an icon before a message label, the label is the icon, see MessageOpts.LabelIcon
+	LABEL_ICON_DECORATION = "label_icon"
+	// the shading of an actor column over an active period, see ActivationStyleLane
+	LANE_DECORATION = "lane"
+	// the line is drawn down the center of the box, see ActorGroup.DividerAfter
+	DIVIDER_DECORATION = "divider"
+	// the title and subtitle of the diagram, the label is the text, see ConfigurableOpts.Title
+	TITLE_DECORATION    = "title"
+	SUBTITLE_DECORATION = "subtitle"
+)
+
+// radius of the rounded corners of self messages in RoutePaths
+const SELF_MESSAGE_CORNER_RADIUS = 6.
+
+// class of every other background band
+const ALTERNATE_BAND_CLASS = "alternate"
+
+// class and fill pattern of suspended spans, see SpanOpts.Suspended
 const (
-	LIFELINE_Z_INDEX = 1
-	SPAN_Z_INDEX     = 2
-	GROUP_Z_INDEX    = 3
-	MESSAGE_Z_INDEX  = 4
-	NOTE_Z_INDEX     = 5
+	SUSPENDED_CLASS        = "suspended"
+	SUSPENDED_FILL_PATTERN = "lines"
 )
+
+// class of the messages in a coregion, which can happen in any order, see ActorOpts.Coregions
+const UNORDERED_CLASS = "unordered"
+
+// width of the brackets of a coregion, centered on the lifeline
+const COREGION_WIDTH = 24.
+
+// ends the message labels cut by ConfigurableOpts.TruncateLabels
+const ELLIPSIS = "…"
+
+// starts the labels of replies with ConfigurableOpts.ReplyLabelPrefix
+const REPLY_LABEL_PREFIX = ": "
+
+// horizontal space between a message guard and its label
+const GUARD_LABEL_GAP = 4.
+
+// size of the icon before a message label, see MessageOpts.LabelIcon
+const LABEL_ICON_SIZE = 16.
+
+// horizontal space between a message label icon and the label
+const LABEL_ICON_GAP = 4.
+
+// space between the return value of a reply and its arrowhead, above and to the side
+const RETURN_VALUE_GAP = 4.
+
+// space between the return value of a reply and its dashed border
+const RETURN_VALUE_PADDING = 2.
+
+// length of the stub of an unanswered call, see ConfigurableOpts.LostReturnStubs
+const LOST_RETURN_STUB_LENGTH = 30.
+
+// min vertical distance between groups stacked one above the other, see ConfigurableOpts.FragmentGap
+const FRAGMENT_GAP = 10.
+
+// space around the operator in the tab of a combined fragment
+const FRAGMENT_TAB_PADDING = 4.
+
+// stroke width critical fragments are drawn with unless they have one
+const CRITICAL_STROKE_WIDTH int = 3
+
+// space between an actor group border and the actors or nested groups in it
+const ACTOR_GROUP_PADDING = 10.
+
+// space added between an actor group and the actors after it for a divider, see ActorGroup.DividerAfter
+const DIVIDER_GAP = 20.
+
+// width of the box of a divider, its line is drawn down the center
+const DIVIDER_WIDTH = 2.
+
+// min space kept at the top of an actor group for its title, taller titles get the height they measure
+const ACTOR_GROUP_LABEL_HEIGHT = 24.
+
+// space between the diagram frame and its content
+const FRAME_MARGIN = 20.
+
+// space between the title block and the diagram below it, see ConfigurableOpts.Title
+const TITLE_MARGIN = 20.
+
+// space between the title and the subtitle
+const SUBTITLE_GAP = 4.
+
+// space around the title in the diagram frame tab
+const FRAME_TAB_PADDING = 8.
+
+// size of the marker of a gate on a group border
+const GATE_SIZE = 8.
+
+// space between a message label and the halo behind it
+const LABEL_HALO_PADDING = 4.
+
+// stroke dash of dashed messages, see ConfigurableOpts.MessageTypeStyles
+const MESSAGE_STROKE_DASH int = 3
+
+// space between the diagram content and the duration brackets, and between columns of overlapping brackets
+const DURATION_BRACKET_GAP = 20.
+
+// length of the ticks at the ends of a duration bracket
+const DURATION_BRACKET_WIDTH = 8.
+
+// horizontal space between a duration bracket and its label
+const DURATION_BRACKET_LABEL_GAP = 4.
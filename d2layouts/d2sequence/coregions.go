@@ -0,0 +1,75 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// placeCoregions places the brackets of the coregions of each actor around its messages in the range
+// and marks them unordered
+// . ┌─────┐      ┌─────┐
+// . │  a  │      │  b  │
+// . └──┬──┘      └──┬──┘
+// .    │         ┌──┴──┐
+// .    ├────────►│     │
+// .    ├────────►│     │
+// .    │         └──┬──┘
+func (sd *sequenceDiagram) placeCoregions() error {
+	messageIndex := make(map[string]int, len(sd.messages))
+	for i, message := range sd.messages {
+		messageIndex[sd.opts.messageID(message)] = i
+	}
+	actorOf := func(obj *d2graph.Object) *d2graph.Object {
+		for obj.Parent != sd.root {
+			obj = obj.Parent
+		}
+		return obj
+	}
+
+	for _, actor := range sd.actors {
+		for _, coregion := range sd.actorOpts(actor).Coregions {
+			from, hasFrom := messageIndex[coregion.From]
+			if !hasFrom {
+				return errorf(VALIDATE_STAGE, actor, "coregion on %s references %#v which is not a message", actor.AbsID(), coregion.From)
+			}
+			to, hasTo := messageIndex[coregion.To]
+			if !hasTo {
+				return errorf(VALIDATE_STAGE, actor, "coregion on %s references %#v which is not a message", actor.AbsID(), coregion.To)
+			}
+			if to < from {
+				return edgeErrorf(VALIDATE_STAGE, sd.messages[to], "coregion on %s ends at %s before it starts at %s", actor.AbsID(), coregion.To, coregion.From)
+			}
+
+			top := math.Inf(1)
+			bottom := math.Inf(-1)
+			for _, message := range sd.messages[from : to+1] {
+				if actorOf(message.Src) != actor && actorOf(message.Dst) != actor {
+					continue
+				}
+				for _, p := range message.Route {
+					top = math.Min(top, p.Y)
+					bottom = math.Max(bottom, p.Y)
+				}
+				if !go2.Contains(message.Classes, UNORDERED_CLASS) {
+					message.Classes = append(message.Classes, UNORDERED_CLASS)
+				}
+			}
+			if math.IsInf(top, 1) {
+				return errorf(VALIDATE_STAGE, actor, "coregion on %s from %s to %s has no messages of the actor", actor.AbsID(), coregion.From, coregion.To)
+			}
+			top -= SPAN_MESSAGE_PAD
+			bottom += SPAN_MESSAGE_PAD
+			sd.decorations = append(sd.decorations, &d2graph.Decoration{
+				Kind:   COREGION_DECORATION,
+				Box:    geo.NewBox(geo.NewPoint(actor.Center().X-COREGION_WIDTH/2., top), COREGION_WIDTH, bottom-top),
+				Object: actor,
+				ZIndex: MARKER_Z_INDEX,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestCoregion(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b: open
+a -> b: first
+c -> b: second
+a -> c: elsewhere
+b -> a: done
+`), nil)
+	assert.Nil(t, err)
+	open, first, second, elsewhere, done := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3], g.Edges[4]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"b": {Coregions: []d2sequence.Coregion{{From: first.AbsID(), To: elsewhere.AbsID()}}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := g.Root.HasChild([]string{"b"})
+
+	var coregion *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.COREGION_DECORATION {
+			coregion = d
+		}
+	}
+	if coregion == nil {
+		t.Fatal("expected a coregion")
+	}
+	assert.Equal(t, b, coregion.Object)
+	assert.Equal(t, b.Center().X, coregion.Center().X)
+	assert.Equal(t, first.Route[0].Y-d2sequence.SPAN_MESSAGE_PAD, coregion.TopLeft.Y)
+	// the last message in the range is not on b
+	assert.Equal(t, second.Route[0].Y+d2sequence.SPAN_MESSAGE_PAD, coregion.TopLeft.Y+coregion.Height)
+
+	assert.Equal(t, []string{d2sequence.UNORDERED_CLASS}, first.Classes)
+	assert.Equal(t, []string{d2sequence.UNORDERED_CLASS}, second.Classes)
+	for _, e := range []*d2graph.Edge{open, elsewhere, done} {
+		assert.Empty(t, e.Classes)
+	}
+}
@@ -0,0 +1,28 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// curveMessages adds a midpoint to the route of straight messages, offset from the line by the curvature
+// times the message length so that the message is drawn as a slight arc
+func (sd *sequenceDiagram) curveMessages() {
+	for _, message := range sd.messages {
+		if len(message.Route) != 2 {
+			continue
+		}
+		start, end := message.Route[0], message.Route[1]
+		length := geo.EuclideanDistance(start.X, start.Y, end.X, end.Y)
+		if length == 0 {
+			continue
+		}
+		// perpendicular to the message, pointing up for messages going right and left alike
+		nx, ny := (end.Y-start.Y)/length, -(end.X-start.X)/length
+		if ny > 0 {
+			nx, ny = -nx, -ny
+		}
+		offset := sd.opts.Curvature * length
+		mid := geo.NewPoint((start.X+end.X)/2+nx*offset, (start.Y+end.Y)/2+ny*offset)
+		message.Route = []*geo.Point{start, mid, end}
+	}
+}
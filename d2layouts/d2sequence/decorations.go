@@ -0,0 +1,83 @@
+package d2sequence
+
+import (
+	"math"
+	"sort"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// placeBands places a background band behind each message row, spanning from the first to the last actor.
+// Concurrent messages share the band of their row, and bands start below the actor headers
+// . ┌─────┐     ┌─────┐
+// . │  a  │     │  b  │
+// . └──┬──┘     └──┬──┘
+// . ░░░├──────────►░░░ band
+// .    │           │
+// . ░░░◄──────────┤░░░ alternate band
+func (sd *sequenceDiagram) placeBands() {
+	firstActor := sd.actors[0]
+	lastActor := sd.actors[len(sd.actors)-1]
+	minX := firstActor.TopLeft.X
+	maxX := lastActor.TopLeft.X + lastActor.Width
+
+	var rows []float64
+	rowMessages := make(map[float64]*d2graph.Edge)
+	for _, message := range sd.messages {
+		y := message.Route[0].Y
+		if _, has := rowMessages[y]; !has {
+			rowMessages[y] = message
+			rows = append(rows, y)
+		}
+	}
+	sort.Float64s(rows)
+
+	for i, y := range rows {
+		top := math.Max(y-sd.yStep/2., sd.maxActorHeight)
+		bottom := y + sd.yStep/2.
+		// rows can be closer than yStep when compacted
+		if i > 0 {
+			top = math.Max(top, (rows[i-1]+y)/2.)
+		}
+		if i < len(rows)-1 {
+			bottom = math.Min(bottom, (y+rows[i+1])/2.)
+		}
+		band := &d2graph.Decoration{
+			Kind:   BAND_DECORATION,
+			Box:    geo.NewBox(geo.NewPoint(minX, top), maxX-minX, bottom-top),
+			Edge:   rowMessages[y],
+			ZIndex: BACKGROUND_Z_INDEX,
+		}
+		if i%2 == 1 {
+			band.Classes = []string{ALTERNATE_BAND_CLASS}
+		}
+		sd.decorations = append(sd.decorations, band)
+	}
+}
+
+// placeHalos places a background behind each message label, above lifelines and spans but below the label text
+// . ┌─────┐           ┌─────┐
+// . │  a  │           │  b  │
+// . └──┬──┘           └──┬──┘
+// .    │   ┌─────────┐   │
+// .    ├───┤  label  ├──►│
+// .    │   └─────────┘   │
+func (sd *sequenceDiagram) placeHalos() {
+	for _, message := range sd.messages {
+		labelBox := edgeLabelBox(message)
+		if labelBox == nil {
+			continue
+		}
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind: HALO_DECORATION,
+			Box: geo.NewBox(
+				geo.NewPoint(labelBox.TopLeft.X-LABEL_HALO_PADDING, labelBox.TopLeft.Y-LABEL_HALO_PADDING),
+				labelBox.Width+LABEL_HALO_PADDING*2,
+				labelBox.Height+LABEL_HALO_PADDING*2,
+			),
+			Edge:   message,
+			ZIndex: GROUP_Z_INDEX,
+		})
+	}
+}
@@ -0,0 +1,371 @@
+package d2sequence_test
+
+import (
+	"context"
+	"testing"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestBackgroundBands(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 30, 30)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: a}, {Src: a, Dst: b}}
+	messages := append([]*d2graph.Edge{}, g.Edges...)
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{BackgroundBands: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Decorations) != len(messages) {
+		t.Fatalf("expected %d bands, got %d", len(messages), len(g.Decorations))
+	}
+	headerBottom := a.TopLeft.Y + a.Height
+	for i, band := range g.Decorations {
+		if band.Kind != d2sequence.BAND_DECORATION {
+			t.Fatalf("expected decoration[%d] to be a band, got %s", i, band.Kind)
+		}
+		if band.Edge != messages[i] {
+			t.Fatalf("expected band[%d] to belong to message[%d]", i, i)
+		}
+		if band.Center().Y != messages[i].Route[0].Y {
+			t.Fatalf("expected band[%d] to be centered on its message", i)
+		}
+		if band.HasClass(d2sequence.ALTERNATE_BAND_CLASS) != (i%2 == 1) {
+			t.Fatalf("expected band[%d] alternate flag to be %v", i, i%2 == 1)
+		}
+		if band.TopLeft.X != a.TopLeft.X || band.TopLeft.X+band.Width != b.TopLeft.X+b.Width {
+			t.Fatalf("expected band[%d] to span all actors", i)
+		}
+		if band.TopLeft.Y < headerBottom {
+			t.Fatalf("expected band[%d] not to overlap the actor headers", i)
+		}
+		if band.ZIndex >= d2sequence.LIFELINE_Z_INDEX {
+			t.Fatalf("expected band[%d] to be behind lifelines", i)
+		}
+		if i > 0 && band.TopLeft.Y < g.Decorations[i-1].TopLeft.Y+g.Decorations[i-1].Height {
+			t.Fatalf("expected band[%d] not to overlap the previous band", i)
+		}
+	}
+}
+
+func TestBackgroundBandRows(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	c := g.Root.EnsureChild([]string{"c"})
+	c.Box = geo.NewBox(nil, 100, 100)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: c}, {Src: c, Dst: a}}
+	messages := append([]*d2graph.Edge{}, g.Edges...)
+
+	// the first two messages are drawn side by side, right below the headers
+	ctx := log.WithTB(context.Background(), t, nil)
+	headerGap := 5.
+	opts := &d2sequence.ConfigurableOpts{
+		BackgroundBands: true,
+		HeaderGap:       &headerGap,
+		Messages: map[string]d2sequence.MessageOpts{
+			messages[0].AbsID(): {ConcurrencyGroup: "fork"},
+			messages[1].AbsID(): {ConcurrencyGroup: "fork"},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Decorations) != 2 {
+		t.Fatalf("expected a band per row, got %d", len(g.Decorations))
+	}
+	first, second := g.Decorations[0], g.Decorations[1]
+	if first.Edge != messages[0] || second.Edge != messages[2] {
+		t.Fatal("expected the bands to belong to the first message of their row")
+	}
+	if first.HasClass(d2sequence.ALTERNATE_BAND_CLASS) || !second.HasClass(d2sequence.ALTERNATE_BAND_CLASS) {
+		t.Fatal("expected the bands to alternate per row")
+	}
+	if first.TopLeft.Y != a.TopLeft.Y+a.Height {
+		t.Fatalf("expected the first band to start at the header bottom %v, got %v", a.TopLeft.Y+a.Height, first.TopLeft.Y)
+	}
+	if second.TopLeft.Y != first.TopLeft.Y+first.Height {
+		t.Fatal("expected the bands to touch")
+	}
+}
+
+func TestFrame(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	// the label below the actor overhangs its box
+	a.Label.Value = "a"
+	a.LabelDimensions = d2target.TextDimensions{Width: 20, Height: 20}
+	a.Shape = d2graph.Scalar{Value: d2target.ShapePerson}
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	self := &d2graph.Edge{Src: b, Dst: b}
+	self.Label.Value = "self message with a wide label"
+	self.LabelDimensions = d2target.TextDimensions{Width: 300, Height: 20}
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, self}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{FrameTitle: "sd checkout"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Decorations) != 2 {
+		t.Fatalf("expected a frame and its tab, got %d decorations", len(g.Decorations))
+	}
+	frame, tab := g.Decorations[0], g.Decorations[1]
+	if frame.Kind != d2sequence.FRAME_DECORATION || tab.Kind != d2sequence.FRAME_TAB_DECORATION {
+		t.Fatalf("unexpected decorations %s and %s", frame.Kind, tab.Kind)
+	}
+	if tab.Label != "sd checkout" || tab.Width <= 0 || tab.Height <= 0 {
+		t.Fatal("expected the tab to fit the frame title")
+	}
+	if tab.TopLeft.X != frame.TopLeft.X || tab.TopLeft.Y != frame.TopLeft.Y {
+		t.Fatal("expected the tab to be at the frame top left")
+	}
+
+	// everything, labels included, must be inside the frame with a margin and below the tab
+	framed := d2graph.NewGraph()
+	framed.Root.Box = geo.NewBox(
+		geo.NewPoint(frame.TopLeft.X+d2sequence.FRAME_MARGIN, tab.TopLeft.Y+tab.Height+d2sequence.FRAME_MARGIN),
+		frame.Width-d2sequence.FRAME_MARGIN*2,
+		frame.Height-tab.Height-d2sequence.FRAME_MARGIN*2,
+	)
+	framed.Objects = g.Objects
+	framed.Edges = g.Edges
+	if err := d2sequence.CheckBounds(framed); err != nil {
+		t.Fatalf("expected the frame to enclose everything: %v", err)
+	}
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatalf("expected the diagram to enclose the frame: %v", err)
+	}
+}
+
+func TestFrameGates(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	in := &d2graph.Edge{Src: a, Dst: b}
+	out := &d2graph.Edge{Src: a, Dst: b, Index: 1}
+	back := &d2graph.Edge{Src: b, Dst: a}
+	internal := &d2graph.Edge{Src: b, Dst: a, Index: 1}
+	g.Edges = []*d2graph.Edge{in, out, back, internal}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		FrameTitle: "sd checkout",
+		Messages: map[string]d2sequence.MessageOpts{
+			in.AbsID():   {FromFrameGate: true},
+			out.AbsID():  {ToFrameGate: true},
+			back.AbsID(): {FromFrameGate: true},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := g.Decorations[0]
+	if frame.Kind != d2sequence.FRAME_DECORATION {
+		t.Fatalf("expected the frame first, got %s", frame.Kind)
+	}
+	left, right := frame.TopLeft.X, frame.TopLeft.X+frame.Width
+	gates := make(map[*d2graph.Edge]*d2graph.Decoration)
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.GATE_DECORATION {
+			gates[d.Edge] = d
+		}
+	}
+	if len(gates) != 3 {
+		t.Fatalf("expected a gate for each external message, got %d", len(gates))
+	}
+
+	for _, c := range []struct {
+		message *d2graph.Edge
+		end     *geo.Point
+		x       float64
+	}{
+		{in, in.Route[0], left},
+		{out, out.Route[1], right},
+		{back, back.Route[0], right},
+	} {
+		if c.end.X != c.x {
+			t.Fatalf("expected %s to cross the frame at %v, got %v", c.message.AbsID(), c.x, c.end.X)
+		}
+		if center := gates[c.message].Center(); center.X != c.x || center.Y != c.end.Y {
+			t.Fatalf("expected the gate of %s on the frame border at %v, got %v", c.message.AbsID(), c.end, center)
+		}
+	}
+	aX, _ := d2sequence.LifelineX(g, a)
+	if internal.Route[1].X != aX {
+		t.Fatal("expected the internal message to stay on the lifeline")
+	}
+	if _, has := gates[internal]; has {
+		t.Fatal("expected no gate on the internal message")
+	}
+}
+
+func TestTitle(t *testing.T) {
+	layout := func(opts *d2sequence.ConfigurableOpts) *d2graph.Graph {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}}
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	plain := layout(nil)
+	titled := layout(&d2sequence.ConfigurableOpts{Title: "Checkout", Subtitle: "an order from the cart"})
+
+	if len(titled.Decorations) != 2 {
+		t.Fatalf("expected a title and a subtitle, got %d decorations", len(titled.Decorations))
+	}
+	title, subtitle := titled.Decorations[0], titled.Decorations[1]
+	if title.Kind != d2sequence.TITLE_DECORATION || subtitle.Kind != d2sequence.SUBTITLE_DECORATION {
+		t.Fatalf("unexpected decorations %s and %s", title.Kind, subtitle.Kind)
+	}
+	if title.Label != "Checkout" || subtitle.Label != "an order from the cart" {
+		t.Fatalf("unexpected labels %q and %q", title.Label, subtitle.Label)
+	}
+	if subtitle.TopLeft.Y != title.TopLeft.Y+title.Height+d2sequence.SUBTITLE_GAP {
+		t.Fatal("expected the subtitle below the title")
+	}
+
+	// the content moves down by the title block
+	blockHeight := title.Height + d2sequence.SUBTITLE_GAP + subtitle.Height + d2sequence.TITLE_MARGIN
+	actor, plainActor := titled.Root.ChildrenArray[0], plain.Root.ChildrenArray[0]
+	if actor.TopLeft.Y != plainActor.TopLeft.Y+blockHeight {
+		t.Fatalf("expected the actors %v lower, got %v", blockHeight, actor.TopLeft.Y-plainActor.TopLeft.Y)
+	}
+	if titled.Root.Height != plain.Root.Height+blockHeight {
+		t.Fatalf("expected the diagram %v taller, got %v", blockHeight, titled.Root.Height-plain.Root.Height)
+	}
+	if titled.Edges[0].Route[0].Y != plain.Edges[0].Route[0].Y+blockHeight {
+		t.Fatal("expected the messages to move down with the actors")
+	}
+	// centered over the diagram
+	left, right := titled.Root.ChildrenArray[0], titled.Root.ChildrenArray[1]
+	if title.Center().X != (left.TopLeft.X+right.TopLeft.X+right.Width)/2 {
+		t.Fatalf("expected the title centered over the actors, got %v", title.Center().X)
+	}
+	if err := d2sequence.CheckBounds(titled); err != nil {
+		t.Fatalf("expected the diagram to enclose the title: %v", err)
+	}
+}
+
+func TestLabelsAboveBoxes(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	span := b.EnsureChild([]string{"t"})
+	span.Box = geo.NewBox(nil, 100, 100)
+	call := &d2graph.Edge{Src: a, Dst: span}
+	g.Edges = []*d2graph.Edge{call, {Src: span, Dst: a}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		BackgroundBands: true,
+		Messages: map[string]d2sequence.MessageOpts{
+			call.AbsID(): {Guard: "x > 0"},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var labels, bands []*d2graph.Decoration
+	for _, d := range g.Decorations {
+		switch d.Kind {
+		case d2sequence.GUARD_DECORATION:
+			labels = append(labels, d)
+		case d2sequence.BAND_DECORATION:
+			bands = append(bands, d)
+		}
+	}
+	if len(labels) != 1 || len(bands) != 2 {
+		t.Fatalf("expected 1 guard and 2 bands, got %d and %d", len(labels), len(bands))
+	}
+	for _, l := range labels {
+		if l.ZIndex <= span.ZIndex || l.ZIndex <= a.ZIndex {
+			t.Fatal("expected labels above boxes")
+		}
+		for _, band := range bands {
+			if l.ZIndex <= band.ZIndex {
+				t.Fatal("expected labels above bands")
+			}
+		}
+		for _, m := range g.Edges {
+			if l.ZIndex <= m.ZIndex {
+				t.Fatal("expected labels above messages and lifelines")
+			}
+		}
+	}
+}
+
+func TestLabelHalos(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	call := &d2graph.Edge{Src: a, Dst: b}
+	call.Label.Value = "call"
+	call.LabelDimensions = d2target.TextDimensions{Width: 40, Height: 20}
+	reply := &d2graph.Edge{Src: b, Dst: a}
+	reply.Label.Value = "a longer reply"
+	reply.LabelDimensions = d2target.TextDimensions{Width: 120, Height: 24}
+	g.Edges = []*d2graph.Edge{call, {Src: a, Dst: b}, reply}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{LabelHalos: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	labeled := []*d2graph.Edge{call, reply}
+	if len(g.Decorations) != len(labeled) {
+		t.Fatalf("expected %d halos, got %d", len(labeled), len(g.Decorations))
+	}
+	for i, halo := range g.Decorations {
+		message := labeled[i]
+		if halo.Kind != d2sequence.HALO_DECORATION || halo.Edge != message {
+			t.Fatalf("expected halo[%d] to belong to a labeled message", i)
+		}
+		if halo.Width != float64(message.LabelDimensions.Width)+d2sequence.LABEL_HALO_PADDING*2 ||
+			halo.Height != float64(message.LabelDimensions.Height)+d2sequence.LABEL_HALO_PADDING*2 {
+			t.Fatalf("expected halo[%d] to be the label size plus padding, got %vx%v", i, halo.Width, halo.Height)
+		}
+		if center := halo.Center(); center.X != (message.Route[0].X+message.Route[1].X)/2. || center.Y != message.Route[0].Y {
+			t.Fatalf("expected halo[%d] to be centered on its label", i)
+		}
+		if halo.ZIndex <= d2sequence.LIFELINE_Z_INDEX || halo.ZIndex >= message.ZIndex {
+			t.Fatalf("expected halo[%d] between lifelines and the label", i)
+		}
+	}
+}
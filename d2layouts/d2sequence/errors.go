@@ -0,0 +1,47 @@
+package d2sequence
+
+import (
+	"fmt"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// stages of the layout a LayoutError can come from
+const (
+	VALIDATE_STAGE     = "validate"
+	ACTORS_STAGE       = "actors"
+	ACTOR_GROUPS_STAGE = "actor_groups"
+	MEASURE_STAGE      = "measure"
+	ROUTE_STAGE        = "route"
+	ACTIVATIONS_STAGE  = "activations"
+	BOUNDS_STAGE       = "bounds"
+)
+
+// LayoutError is returned when a sequence diagram cannot be laid out.
+// Object or Edge is the element at fault so that callers can point to it, e.g. highlight it in an editor
+type LayoutError struct {
+	Stage   string
+	Object  *d2graph.Object
+	Edge    *d2graph.Edge
+	Message string
+}
+
+func (e *LayoutError) Error() string {
+	return e.Message
+}
+
+func errorf(stage string, obj *d2graph.Object, f string, v ...interface{}) error {
+	return &LayoutError{
+		Stage:   stage,
+		Object:  obj,
+		Message: fmt.Sprintf(f, v...),
+	}
+}
+
+func edgeErrorf(stage string, edge *d2graph.Edge, f string, v ...interface{}) error {
+	return &LayoutError{
+		Stage:   stage,
+		Edge:    edge,
+		Message: fmt.Sprintf(f, v...),
+	}
+}
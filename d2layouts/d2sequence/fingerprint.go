@@ -0,0 +1,39 @@
+package d2sequence
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// Fingerprint hashes the logical content of a sequence diagram: its actors in declaration order and its messages
+// as the actors they go from and to with their label. It ignores geometry, so it is the same before and after layout
+// and for diagrams that only differ in sizes, e.g. to tell semantic changes from cosmetic ones
+func Fingerprint(g *d2graph.Graph) string {
+	h := fnv.New64a()
+	actorIndex := make(map[*d2graph.Object]int)
+	for _, obj := range g.Root.ChildrenArray {
+		if obj.IsSequenceDiagramGroup() {
+			continue
+		}
+		actorIndex[obj] = len(actorIndex)
+		fmt.Fprintf(h, "actor %q\n", obj.AbsID())
+	}
+	actorOf := func(obj *d2graph.Object) int {
+		for obj.Parent != nil && obj.Parent != g.Root {
+			obj = obj.Parent
+		}
+		if i, has := actorIndex[obj]; has {
+			return i
+		}
+		return -1
+	}
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			continue
+		}
+		fmt.Fprintf(h, "message %d %d %q\n", actorOf(edge.Src), actorOf(edge.Dst), edge.Label.Value)
+	}
+	return fmt.Sprintf("%016x", h.Sum64())
+}
@@ -0,0 +1,188 @@
+package d2sequence
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+type FragmentOperator string
+
+const (
+	// FragmentAlt is a choice between alternatives, only one of them happens
+	FragmentAlt FragmentOperator = "alt"
+	// FragmentOpt happens only if its condition holds
+	FragmentOpt FragmentOperator = "opt"
+	// FragmentLoop is repeated
+	FragmentLoop FragmentOperator = "loop"
+	// FragmentPar happens in parallel with other fragments
+	FragmentPar FragmentOperator = "par"
+	// FragmentCritical is an atomic region that cannot be interleaved with other messages,
+	// drawn with a CRITICAL_STROKE_WIDTH border by default
+	FragmentCritical FragmentOperator = "critical"
+)
+
+// FragmentBounds returns the box a group of a laid out sequence diagram is drawn in, e.g. to highlight it, with its
+// operator tab and its gates. It returns nil if the object is not a placed group
+func FragmentBounds(g *d2graph.Graph, fragment *d2graph.Object) *geo.Box {
+	if !fragment.IsSequenceDiagramGroup() || fragment.Box == nil || fragment.TopLeft == nil {
+		return nil
+	}
+	tl := fragment.TopLeft.Copy()
+	br := geo.NewPoint(fragment.TopLeft.X+fragment.Width, fragment.TopLeft.Y+fragment.Height)
+	for _, d := range g.Decorations {
+		if d.Object != fragment {
+			continue
+		}
+		tl.X = math.Min(tl.X, d.TopLeft.X)
+		tl.Y = math.Min(tl.Y, d.TopLeft.Y)
+		br.X = math.Max(br.X, d.TopLeft.X+d.Width)
+		br.Y = math.Max(br.Y, d.TopLeft.Y+d.Height)
+	}
+	return geo.NewBox(tl, br.X-tl.X, br.Y-tl.Y)
+}
+
+// measureFragmentTabs measures the operator tabs of the groups that are fragments, so that the group headers fit them,
+// and sets the default style of their operator
+func (sd *sequenceDiagram) measureFragmentTabs() error {
+	for _, group := range sd.groups {
+		operator := sd.opts.Groups[group.AbsID()].Operator
+		switch operator {
+		case "":
+			continue
+		case FragmentAlt, FragmentOpt, FragmentLoop, FragmentPar, FragmentCritical:
+		default:
+			return errorf(VALIDATE_STAGE, group, "unknown fragment operator %#v on %s", operator, group.AbsID())
+		}
+
+		dims, err := sd.measureText(&d2target.MText{
+			Text:     string(operator),
+			FontSize: d2fonts.FONT_SIZE_M,
+			IsBold:   true,
+		})
+		if err != nil {
+			return err
+		}
+		sd.fragmentTabs[group] = geo.NewBox(nil, float64(dims.Width)+FRAGMENT_TAB_PADDING*2, float64(dims.Height)+FRAGMENT_TAB_PADDING*2)
+		group.LabelPosition = go2.Pointer(label.InsideTopCenter.String())
+		if operator == FragmentCritical && group.Style.StrokeWidth == nil {
+			group.Style.StrokeWidth = &d2graph.Scalar{Value: fmt.Sprintf("%d", CRITICAL_STROKE_WIDTH)}
+		}
+	}
+	return nil
+}
+
+// placeFragmentTabs places the operator tab of each fragment at its top left, in the header space left for it
+// . ┌──────────┬──────────────────┐
+// . │ critical │  [label]         │
+// . ├──────────┘                  │
+// . │     ├──────────────►│       │
+// . └─────┼───────────────┼───────┘
+func (sd *sequenceDiagram) placeFragmentTabs() {
+	for _, group := range sd.groups {
+		tab, has := sd.fragmentTabs[group]
+		if !has {
+			continue
+		}
+		operator := string(sd.opts.Groups[group.AbsID()].Operator)
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:    FRAGMENT_TAB_DECORATION,
+			Box:     geo.NewBox(group.TopLeft.Copy(), tab.Width, tab.Height),
+			Label:   operator,
+			Classes: []string{operator},
+			Object:  group,
+			ZIndex:  GROUP_Z_INDEX,
+		})
+	}
+}
+
+// groupSpacing is the GroupOpts.Spacing of the innermost group with one that contains both messages, 0 if none does
+func (sd *sequenceDiagram) groupSpacing(prev, message *d2graph.Edge) float64 {
+	var innermost *d2graph.Object
+	for _, group := range sd.groups {
+		if sd.opts.Groups[group.AbsID()].Spacing <= 0 || !prev.ContainedBy(group) || !message.ContainedBy(group) {
+			continue
+		}
+		if innermost == nil || group.Level() > innermost.Level() {
+			innermost = group
+		}
+	}
+	if innermost == nil {
+		return 0
+	}
+	return sd.opts.Groups[innermost.AbsID()].Spacing
+}
+
+func (sd *sequenceDiagram) fragmentGap() float64 {
+	if sd.opts.FragmentGap != nil {
+		return *sd.opts.FragmentGap
+	}
+	return FRAGMENT_GAP
+}
+
+// separateStackedGroups moves groups down, with everything below them, when they are closer than the fragment gap
+// to a group above them that they are not nested in
+// . ┌────────────┐
+// . │ first      │
+// . └────────────┘
+// .        gap
+// . ┌────────────┐
+// . │ second     │
+// . └────────────┘
+func (sd *sequenceDiagram) separateStackedGroups() {
+	gap := sd.fragmentGap()
+	groups := append([]*d2graph.Object{}, sd.groups...)
+	sort.SliceStable(groups, func(i, j int) bool {
+		return groups[i].TopLeft.Y < groups[j].TopLeft.Y
+	})
+	for i, lower := range groups {
+		dy := 0.
+		for _, upper := range groups[:i] {
+			if lower.IsDescendantOf(upper) || upper.IsDescendantOf(lower) {
+				continue
+			}
+			bottom := upper.TopLeft.Y + upper.Height
+			overlapsX := upper.TopLeft.X < lower.TopLeft.X+lower.Width && lower.TopLeft.X < upper.TopLeft.X+upper.Width
+			if overlapsX && lower.TopLeft.Y >= bottom {
+				dy = math.Max(dy, bottom+gap-lower.TopLeft.Y)
+			}
+		}
+		if dy > 0 {
+			sd.moveDownFrom(lower.TopLeft.Y, dy)
+		}
+	}
+}
+
+// moveDownFrom moves everything from y down by dy, the groups and spans that y crosses grow by dy
+func (sd *sequenceDiagram) moveDownFrom(y, dy float64) {
+	for _, m := range sd.messages {
+		if math.Min(m.Route[0].Y, m.Route[len(m.Route)-1].Y) >= y {
+			for _, p := range m.Route {
+				p.Y += dy
+			}
+		}
+	}
+	objects := append([]*d2graph.Object{}, sd.groups...)
+	objects = append(objects, sd.spans...)
+	objects = append(objects, sd.notes...)
+	for _, obj := range objects {
+		if obj.TopLeft.Y >= y {
+			obj.TopLeft.Y += dy
+		} else if obj.TopLeft.Y+obj.Height > y {
+			obj.Height += dy
+		}
+	}
+	for _, d := range sd.decorations {
+		if d.TopLeft.Y >= y {
+			d.TopLeft.Y += dy
+		}
+	}
+}
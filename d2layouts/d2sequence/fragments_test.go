@@ -0,0 +1,222 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestCriticalFragment(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: before
+transfer: {
+  a -> b: debit
+  b -> a: credit
+}
+`), nil)
+	assert.Nil(t, err)
+	transfer, _ := g.Root.HasChild([]string{"transfer"})
+	transfer.LabelDimensions.Width = 60
+	transfer.LabelDimensions.Height = 20
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Groups: map[string]d2sequence.GroupOpts{
+			"transfer": {Operator: d2sequence.FragmentCritical},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tab *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.FRAGMENT_TAB_DECORATION {
+			tab = d
+		}
+	}
+	if tab == nil {
+		t.Fatal("expected an operator tab")
+	}
+	assert.Equal(t, "critical", tab.Label)
+	assert.True(t, tab.HasClass(string(d2sequence.FragmentCritical)))
+	assert.Equal(t, transfer, tab.Object)
+	assert.Equal(t, *transfer.TopLeft, *tab.TopLeft)
+
+	// the tab is in the header of the fragment, above its messages
+	for _, e := range g.Edges[1:3] {
+		if e.Route[0].Y <= tab.TopLeft.Y+tab.Height {
+			t.Fatalf("expected %s below the operator tab", e.AbsID())
+		}
+	}
+	assert.Equal(t, label.InsideTopCenter.String(), *transfer.LabelPosition)
+	if transfer.Style.StrokeWidth == nil || transfer.Style.StrokeWidth.Value != "3" {
+		t.Fatal("expected the critical fragment to have a thicker border")
+	}
+
+	g, _, err = d2compiler.Compile("", strings.NewReader("shape: sequence_diagram\na; b\ng: {\n  a -> b\n}\n"), nil)
+	assert.Nil(t, err)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Groups: map[string]d2sequence.GroupOpts{"g": {Operator: "atomic"}},
+	})
+	assert.Error(t, err)
+}
+
+func TestFragmentGap(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b
+first: {
+  a -> b
+}
+second: {
+  b -> a
+}
+`
+	layout := func(gap float64) (*d2graph.Graph, float64) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{FragmentGap: &gap})
+		if err != nil {
+			t.Fatal(err)
+		}
+		first, _ := g.Root.HasChild([]string{"first"})
+		second, _ := g.Root.HasChild([]string{"second"})
+		return g, second.TopLeft.Y - (first.TopLeft.Y + first.Height)
+	}
+
+	_, defaultGap := layout(d2sequence.FRAGMENT_GAP)
+	if defaultGap < d2sequence.FRAGMENT_GAP {
+		t.Fatalf("expected a gap of at least %v between the fragments, got %v", d2sequence.FRAGMENT_GAP, defaultGap)
+	}
+
+	// a gap larger than the one the layout leaves pushes the second fragment and its message down
+	wide := defaultGap + 50
+	g, gap := layout(wide)
+	assert.Equal(t, wide, gap)
+	second, _ := g.Root.HasChild([]string{"second"})
+	message := g.Edges[1]
+	if message.Route[0].Y <= second.TopLeft.Y || message.Route[0].Y >= second.TopLeft.Y+second.Height {
+		t.Fatalf("expected %s inside the second fragment", message.AbsID())
+	}
+	// lifelines end below the pushed fragment
+	for _, e := range g.Edges[2:] {
+		if e.Route[len(e.Route)-1].Y <= second.TopLeft.Y+second.Height {
+			t.Fatalf("expected %s to end below the second fragment", e.AbsID())
+		}
+	}
+}
+
+func TestGroupSpacing(t *testing.T) {
+	layout := func(spacing float64) []*d2graph.Edge {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: before
+b -> a: after before
+loop: {
+  a -> b: first
+  b -> a: second
+  a -> b: third
+}
+`), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+			Groups: map[string]d2sequence.GroupOpts{"loop": {Spacing: spacing}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g.Edges
+	}
+	gap := func(edges []*d2graph.Edge, i int) float64 {
+		return edges[i+1].Route[0].Y - edges[i].Route[0].Y
+	}
+
+	normal := layout(0)
+	tight := layout(0.5)
+	// the messages in the fragment are half as far apart
+	assert.Equal(t, gap(normal, 2)/2, gap(tight, 2))
+	assert.Equal(t, gap(normal, 3)/2, gap(tight, 3))
+	// the messages outside are not
+	assert.Equal(t, gap(normal, 0), gap(tight, 0))
+	assert.Equal(t, gap(normal, 1), gap(tight, 1))
+}
+
+func TestFragmentBounds(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d
+a -> b: before
+retry: {
+  b -> c: attempt
+  c -> b: failure
+  a -> c: cancel
+}
+c -> d: after
+`), nil)
+	assert.Nil(t, err)
+	retry, _ := g.Root.HasChild([]string{"retry"})
+	assert.Nil(t, d2sequence.FragmentBounds(g, retry))
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	gate := g.Edges[3].AbsID()
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Groups:   map[string]d2sequence.GroupOpts{"retry": {Operator: d2sequence.FragmentLoop}},
+		Messages: map[string]d2sequence.MessageOpts{gate: {Gate: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	box := d2sequence.FragmentBounds(g, retry)
+	if box == nil {
+		t.Fatal("expected the bounds of the fragment")
+	}
+	contains := func(p *geo.Point) bool {
+		return box.TopLeft.X <= p.X && p.X <= box.TopLeft.X+box.Width && box.TopLeft.Y <= p.Y && p.Y <= box.TopLeft.Y+box.Height
+	}
+	for _, edge := range g.Edges[1:4] {
+		for _, p := range edge.Route {
+			if !contains(p) {
+				t.Fatalf("expected %s in the fragment bounds %v, got %v", edge.AbsID(), box, p)
+			}
+		}
+	}
+	// the lifelines of the participants go through the fragment
+	for _, id := range []string{"b", "c"} {
+		actor, _ := g.Root.HasChild([]string{id})
+		x, _ := d2sequence.LifelineX(g, actor)
+		if !contains(geo.NewPoint(x, box.TopLeft.Y)) {
+			t.Fatalf("expected the lifeline of %s in the fragment bounds", id)
+		}
+	}
+	for _, edge := range []*d2graph.Edge{g.Edges[0], g.Edges[4]} {
+		if contains(edge.Route[0]) {
+			t.Fatalf("expected %s out of the fragment bounds", edge.AbsID())
+		}
+	}
+	// the gate sticks out of the group border
+	for _, d := range g.Decorations {
+		if d.Object == retry && (d.TopLeft.X < box.TopLeft.X || d.TopLeft.X+d.Width > box.TopLeft.X+box.Width) {
+			t.Fatalf("expected the %s in the fragment bounds", d.Kind)
+		}
+	}
+	assert.True(t, box.TopLeft.X < retry.TopLeft.X)
+
+	a, _ := g.Root.HasChild([]string{"a"})
+	assert.Nil(t, d2sequence.FragmentBounds(g, a))
+}
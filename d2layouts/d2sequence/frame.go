@@ -0,0 +1,138 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// placeFrame places a frame around everything in the sequence diagram, with its title in a tab at the top left
+// . ┌──────────┬──────────────┐
+// . │ sd title │              │
+// . ├──────────┘              │
+// . │  ┌─────┐     ┌─────┐    │
+// . │  │  a  │     │  b  │    │
+// . │  └──┬──┘     └──┬──┘    │
+// . │     ├──────────►│       │
+// . └─────────────────────────┘
+func (sd *sequenceDiagram) placeFrame() error {
+	dims, err := sd.measureText(&d2target.MText{
+		Text:     sd.opts.FrameTitle,
+		FontSize: d2fonts.FONT_SIZE_M,
+		IsBold:   true,
+	})
+	if err != nil {
+		return err
+	}
+	tabWidth := float64(dims.Width) + FRAME_TAB_PADDING*2
+	tabHeight := float64(dims.Height) + FRAME_TAB_PADDING*2
+
+	content := sd.contentBounds()
+	frameTL := geo.NewPoint(content.TopLeft.X-FRAME_MARGIN, content.TopLeft.Y-FRAME_MARGIN-tabHeight)
+	frame := &d2graph.Decoration{
+		Kind:   FRAME_DECORATION,
+		Box:    geo.NewBox(frameTL, math.Max(content.Width+FRAME_MARGIN*2, tabWidth), content.Height+FRAME_MARGIN*2+tabHeight),
+		ZIndex: BACKGROUND_Z_INDEX,
+	}
+	tab := &d2graph.Decoration{
+		Kind:   FRAME_TAB_DECORATION,
+		Box:    geo.NewBox(frameTL.Copy(), tabWidth, tabHeight),
+		Label:  sd.opts.FrameTitle,
+		ZIndex: BACKGROUND_Z_INDEX,
+	}
+	// the frame is drawn first, below everything
+	sd.decorations = append([]*d2graph.Decoration{frame, tab}, sd.decorations...)
+	sd.frame = frame
+	sd.placeFrameGates()
+	return nil
+}
+
+// placeFrameGates makes the messages that come from or go to outside the frame start or end on a gate
+// on the frame border
+// . ┌──────────┬──────────────┐
+// . │ sd title │              │
+// . ├──────────┘              │
+// . │  ┌─────┐     ┌─────┐    │
+// . │  │  a  │     │  b  │    │
+// . │  └──┬──┘     └──┬──┘    │
+// . ■─────┼──────────►│       │
+// . │     │           ├──────►■
+// . └─────────────────────────┘
+func (sd *sequenceDiagram) placeFrameGates() {
+	left := sd.frame.TopLeft.X
+	right := sd.frame.TopLeft.X + sd.frame.Width
+	for _, message := range sd.messages {
+		messageOpts := sd.opts.messageOpts(message)
+		if len(message.Route) != 2 || (!messageOpts.FromFrameGate && !messageOpts.ToFrameGate) {
+			continue
+		}
+		start, end := message.Route[0], message.Route[1]
+		toRight := start.X <= end.X
+		if messageOpts.FromFrameGate {
+			x := left
+			if !toRight {
+				x = right
+			}
+			message.Route[0] = geo.NewPoint(x, start.Y)
+			sd.addFrameGate(message, message.Route[0])
+		}
+		if messageOpts.ToFrameGate {
+			x := right
+			if !toRight {
+				x = left
+			}
+			message.Route[1] = geo.NewPoint(x, end.Y)
+			sd.addFrameGate(message, message.Route[1])
+		}
+	}
+}
+
+func (sd *sequenceDiagram) addFrameGate(message *d2graph.Edge, at *geo.Point) {
+	sd.decorations = append(sd.decorations, &d2graph.Decoration{
+		Kind:   GATE_DECORATION,
+		Box:    geo.NewBox(geo.NewPoint(at.X-GATE_SIZE/2., at.Y-GATE_SIZE/2.), GATE_SIZE, GATE_SIZE),
+		Edge:   message,
+		ZIndex: MARKER_Z_INDEX,
+	})
+}
+
+// contentBounds returns the box around everything placed in the sequence diagram, including labels
+func (sd *sequenceDiagram) contentBounds() *geo.Box {
+	minX := math.Inf(1)
+	minY := math.Inf(1)
+	maxX := math.Inf(-1)
+	maxY := math.Inf(-1)
+	add := func(b *geo.Box) {
+		if b == nil || b.TopLeft == nil {
+			return
+		}
+		minX = math.Min(minX, b.TopLeft.X)
+		minY = math.Min(minY, b.TopLeft.Y)
+		maxX = math.Max(maxX, b.TopLeft.X+b.Width)
+		maxY = math.Max(maxY, b.TopLeft.Y+b.Height)
+	}
+
+	objects := append([]*d2graph.Object{}, sd.actors...)
+	objects = append(objects, sd.spans...)
+	objects = append(objects, sd.groups...)
+	objects = append(objects, sd.notes...)
+	for _, obj := range objects {
+		add(obj.Box)
+		add(objectLabelBox(obj))
+	}
+	edges := append([]*d2graph.Edge{}, sd.messages...)
+	edges = append(edges, sd.lifelines...)
+	for _, edge := range edges {
+		for _, p := range edge.Route {
+			add(geo.NewBox(p, 0, 0))
+		}
+		add(edgeLabelBox(edge))
+	}
+	for _, d := range sd.decorations {
+		add(d.Box)
+	}
+	return geo.NewBox(geo.NewPoint(minX, minY), maxX-minX, maxY-minY)
+}
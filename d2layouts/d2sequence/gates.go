@@ -0,0 +1,56 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// isGate tells if the message enters its group from outside, only straight messages can
+func (sd *sequenceDiagram) isGate(message *d2graph.Edge) bool {
+	return sd.opts.messageOpts(message).Gate && len(message.Route) == 2
+}
+
+// placeGates places a gate where a message enters its innermost group and makes the message start from it
+// . ┌───────┐      ┌───────┐
+// . │ actor │      │ actor │
+// . └───┬───┘      └───┬───┘
+// .     │     ┌────────┼────┐
+// .     │     │group   │    │
+// .     │     ■───────►│    │
+// .     │     └────────┼────┘
+func (sd *sequenceDiagram) placeGates() {
+	for _, message := range sd.messages {
+		if !sd.isGate(message) {
+			continue
+		}
+		var group *d2graph.Object
+		for _, g := range sd.groups {
+			if message.ContainedBy(g) && (group == nil || g.Level() > group.Level()) {
+				group = g
+			}
+		}
+		if group == nil {
+			continue
+		}
+
+		start := message.Route[0]
+		var gateX float64
+		if start.X < group.TopLeft.X {
+			gateX = group.TopLeft.X
+		} else if start.X > group.TopLeft.X+group.Width {
+			gateX = group.TopLeft.X + group.Width
+		} else {
+			// the sender is in the group, nothing to enter
+			continue
+		}
+		message.Route[0] = geo.NewPoint(gateX, start.Y)
+
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   GATE_DECORATION,
+			Box:    geo.NewBox(geo.NewPoint(gateX-GATE_SIZE/2., start.Y-GATE_SIZE/2.), GATE_SIZE, GATE_SIZE),
+			Object: group,
+			Edge:   message,
+			ZIndex: MARKER_Z_INDEX,
+		})
+	}
+}
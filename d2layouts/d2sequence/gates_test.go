@@ -0,0 +1,65 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestMessageGate(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c
+fragment: {
+  a -> c
+  b -> c
+}
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	fragment, _ := g.Root.HasChild([]string{"fragment"})
+	a, _ := g.Root.HasChild([]string{"a"})
+	entering := g.Edges[0]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		Messages: map[string]d2sequence.MessageOpts{
+			entering.AbsID(): {Gate: true},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if fragment.TopLeft.X <= a.Center().X {
+		t.Fatal("expected the fragment not to enclose the sender")
+	}
+	var gates int
+	for _, d := range g.Decorations {
+		if d.Kind != d2sequence.GATE_DECORATION {
+			continue
+		}
+		gates++
+		if d.Edge != entering || d.Object != fragment {
+			t.Fatal("expected the gate of the entering message on the fragment")
+		}
+		if d.Center().X != fragment.TopLeft.X {
+			t.Fatalf("expected the gate on the fragment border, got %.5f instead of %.5f", d.Center().X, fragment.TopLeft.X)
+		}
+		if d.Center().Y != entering.Route[0].Y {
+			t.Fatal("expected the gate at the message height")
+		}
+	}
+	if gates != 1 {
+		t.Fatalf("expected 1 gate, got %d", gates)
+	}
+	if entering.Route[0].X != fragment.TopLeft.X {
+		t.Fatal("expected the message to start from the gate")
+	}
+}
@@ -0,0 +1,93 @@
+package d2sequence
+
+import (
+	"strconv"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+func (sd *sequenceDiagram) guardText(message *d2graph.Edge) string {
+	return "[" + sd.opts.messageOpts(message).Guard + "]"
+}
+
+// measureGuards measures the guards of the messages so that they count towards the space between actors
+func (sd *sequenceDiagram) measureGuards() error {
+	for _, message := range sd.messages {
+		msgOpts := sd.opts.messageOpts(message)
+		if msgOpts.Guard == "" {
+			continue
+		}
+
+		// the guard is drawn like the message label unless styled otherwise
+		mtext := message.Text()
+		mtext.Text = sd.guardText(message)
+		if msgOpts.GuardStyle.FontSize != nil {
+			mtext.FontSize, _ = strconv.Atoi(msgOpts.GuardStyle.FontSize.Value)
+		}
+		if msgOpts.GuardStyle.Bold != nil {
+			mtext.IsBold, _ = strconv.ParseBool(msgOpts.GuardStyle.Bold.Value)
+		}
+		if msgOpts.GuardStyle.Italic != nil {
+			mtext.IsItalic, _ = strconv.ParseBool(msgOpts.GuardStyle.Italic.Value)
+		}
+		dims, err := sd.measureText(mtext)
+		if err != nil {
+			return err
+		}
+		sd.guards[message] = dims
+	}
+	return nil
+}
+
+// placeGuards places message guards right before their labels, with the guard and label centered together on the message
+// . ┌───────┐                    ┌───────┐
+// . │ actor │                    │ actor │
+// . └───┬───┘                    └───┬───┘
+// .     │    [x > 0] label           │
+// .     ├───────────────────────────►│
+func (sd *sequenceDiagram) placeGuards() {
+	for _, message := range sd.messages {
+		guard, has := sd.guards[message]
+		if !has {
+			continue
+		}
+		guardWidth := float64(guard.Width)
+		guardHeight := float64(guard.Height)
+		route := geo.Route(message.Route)
+
+		var guardTL *geo.Point
+		if message.Label.Value == "" {
+			guardTL, _ = label.InsideMiddleCenter.GetPointOnRoute(route, 0, 0, guardWidth, guardHeight)
+		} else {
+			labelWidth := float64(message.LabelDimensions.Width)
+			labelHeight := float64(message.LabelDimensions.Height)
+			labelPercentage := 0.5
+			if !isSelfMessage(message) {
+				// moves the label forward in reading direction to make room for the guard
+				shift := (guardWidth + GUARD_LABEL_GAP + sd.labelIconWidth(message)) / 2. / route.Length()
+				if route[0].X <= route[len(route)-1].X {
+					labelPercentage += shift
+				} else {
+					labelPercentage -= shift
+				}
+				message.LabelPosition = go2.Pointer(label.UnlockedMiddle.String())
+				message.LabelPercentage = go2.Pointer(labelPercentage)
+			}
+			labelTL, _ := label.FromString(*message.LabelPosition).GetPointOnRoute(route, 0, labelPercentage, labelWidth, labelHeight)
+			guardTL = geo.NewPoint(labelTL.X-sd.labelIconWidth(message)-GUARD_LABEL_GAP-guardWidth, labelTL.Y+labelHeight/2.-guardHeight/2.)
+		}
+
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   GUARD_DECORATION,
+			Box:    geo.NewBox(guardTL, guardWidth, guardHeight),
+			Label:  sd.guardText(message),
+			Style:  sd.opts.messageOpts(message).GuardStyle,
+			Edge:   message,
+			ZIndex: LABEL_Z_INDEX,
+		})
+	}
+}
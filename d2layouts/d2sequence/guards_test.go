@@ -0,0 +1,145 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestMessageGuard(t *testing.T) {
+	newGraph := func() *d2graph.Graph {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		message := &d2graph.Edge{Src: a, Dst: b}
+		message.Label.Value = "a very long message label"
+		message.LabelDimensions = d2target.TextDimensions{Width: 200, Height: 20}
+		g.Edges = []*d2graph.Edge{message}
+		return g
+	}
+	actorGap := func(g *d2graph.Graph) float64 {
+		a, b := g.Root.ChildrenArray[0], g.Root.ChildrenArray[1]
+		return b.Center().X - a.Center().X
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	base := newGraph()
+	if err := d2sequence.Layout(ctx, base, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(base.Decorations) != 0 {
+		t.Fatal("expected no guard without options")
+	}
+
+	g := newGraph()
+	message := g.Edges[0]
+	opts := &d2sequence.ConfigurableOpts{
+		Messages: map[string]d2sequence.MessageOpts{
+			message.AbsID(): {
+				Guard:      "x > 0",
+				GuardStyle: d2graph.Style{Bold: &d2graph.Scalar{Value: "true"}},
+			},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(g.Decorations) != 1 {
+		t.Fatalf("expected 1 guard, got %d", len(g.Decorations))
+	}
+	guard := g.Decorations[0]
+	if guard.Kind != d2sequence.GUARD_DECORATION || guard.Label != "[x > 0]" || guard.Edge != message {
+		t.Fatalf("unexpected guard %s %q", guard.Kind, guard.Label)
+	}
+	if guard.Style.Bold == nil || guard.Style.Bold.Value != "true" {
+		t.Fatal("expected the guard to keep its own style")
+	}
+	if message.Style.Bold != nil {
+		t.Fatal("expected the guard style not to apply to the label")
+	}
+
+	combinedWidth := guard.Width + d2sequence.GUARD_LABEL_GAP + float64(message.LabelDimensions.Width)
+	if actorGap(g) != combinedWidth+d2sequence.HORIZONTAL_PAD {
+		t.Fatalf("expected actor gap %.5f to fit guard and label %.5f", actorGap(g), combinedWidth+d2sequence.HORIZONTAL_PAD)
+	}
+	if actorGap(g) <= actorGap(base) {
+		t.Fatal("expected the guard to widen the actor gap")
+	}
+
+	if message.LabelPercentage == nil {
+		t.Fatal("expected the label to be moved to make room for the guard")
+	}
+	labelTL, _ := label.FromString(*message.LabelPosition).GetPointOnRoute(message.Route, 0, *message.LabelPercentage, float64(message.LabelDimensions.Width), float64(message.LabelDimensions.Height))
+	if guard.TopLeft.X+guard.Width+d2sequence.GUARD_LABEL_GAP != labelTL.X {
+		t.Fatalf("expected guard to be right before the label, got guard right %.5f and label left %.5f", guard.TopLeft.X+guard.Width, labelTL.X)
+	}
+	mid := (message.Route[0].X + message.Route[1].X) / 2.
+	if center := (guard.TopLeft.X + labelTL.X + float64(message.LabelDimensions.Width)) / 2.; center-mid > 0.5 || mid-center > 0.5 {
+		t.Fatalf("expected guard and label to be centered on the message, got %.5f instead of %.5f", center, mid)
+	}
+}
+
+func TestLabelIcon(t *testing.T) {
+	layout := func(icon string) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: a label long enough to space the actors
+`), nil)
+		assert.Nil(t, err)
+		g.Edges[0].LabelDimensions = d2target.TextDimensions{Width: 300, Height: 20}
+		ctx := log.WithTB(context.Background(), t, nil)
+		opts := &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{
+			g.Edges[0].AbsID(): {LabelIcon: icon},
+		}}
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	distance := func(g *d2graph.Graph) float64 {
+		route := g.Edges[0].Route
+		return route[1].X - route[0].X
+	}
+
+	plain := layout("")
+	g := layout("https://icons.terrastruct.com/essentials/092-lock.svg")
+	// the actors are further apart by the icon and its gap
+	assert.Equal(t, distance(plain)+d2sequence.LABEL_ICON_SIZE+d2sequence.LABEL_ICON_GAP, distance(g))
+
+	var icon *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.LABEL_ICON_DECORATION {
+			icon = d
+		}
+	}
+	if icon == nil {
+		t.Fatal("expected a label icon")
+	}
+	assert.Equal(t, "https://icons.terrastruct.com/essentials/092-lock.svg", icon.Label)
+	assert.Equal(t, g.Edges[0], icon.Edge)
+	// right before the label, the icon and the label centered together on the message
+	message := g.Edges[0]
+	labelPercentage := *message.LabelPercentage
+	labelTL, _ := label.FromString(*message.LabelPosition).GetPointOnRoute(message.Route, 0, labelPercentage, 300, 20)
+	assert.InDelta(t, labelTL.X-d2sequence.LABEL_ICON_GAP, icon.TopLeft.X+icon.Width, 1e-9)
+	route := message.Route
+	assert.InDelta(t, (route[0].X+route[1].X)/2, (icon.TopLeft.X+labelTL.X+300)/2, 1e-9)
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+}
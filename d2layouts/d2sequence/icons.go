@@ -0,0 +1,59 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/label"
+)
+
+// labelIconWidth is the space the label icon of a message takes before its label, 0 if it has none
+func (sd *sequenceDiagram) labelIconWidth(message *d2graph.Edge) float64 {
+	if sd.opts.messageOpts(message).LabelIcon == "" || message.Label.Value == "" {
+		return 0
+	}
+	return LABEL_ICON_SIZE + LABEL_ICON_GAP
+}
+
+// placeLabelIcons places message label icons right before their labels, after the guard if any,
+// with the icon and label centered together on the message
+// . ┌───────┐                    ┌───────┐
+// . │ actor │                    │ actor │
+// . └───┬───┘                    └───┬───┘
+// .     │    [x > 0] ▣ label         │
+// .     ├───────────────────────────►│
+func (sd *sequenceDiagram) placeLabelIcons() {
+	for _, message := range sd.messages {
+		if sd.labelIconWidth(message) == 0 {
+			continue
+		}
+		route := geo.Route(message.Route)
+		labelPercentage := 0.5
+		if message.LabelPercentage != nil {
+			labelPercentage = *message.LabelPercentage
+		}
+		if _, has := sd.guards[message]; !has && !isSelfMessage(message) {
+			// moves the label forward in reading direction to make room for the icon, guards already did
+			shift := sd.labelIconWidth(message) / 2. / route.Length()
+			if route[0].X <= route[len(route)-1].X {
+				labelPercentage += shift
+			} else {
+				labelPercentage -= shift
+			}
+			message.LabelPosition = go2.Pointer(label.UnlockedMiddle.String())
+			message.LabelPercentage = go2.Pointer(labelPercentage)
+		}
+		labelHeight := float64(message.LabelDimensions.Height)
+		labelTL, _ := label.FromString(*message.LabelPosition).GetPointOnRoute(route, 0, labelPercentage, float64(message.LabelDimensions.Width), labelHeight)
+		iconTL := geo.NewPoint(labelTL.X-LABEL_ICON_GAP-LABEL_ICON_SIZE, labelTL.Y+labelHeight/2.-LABEL_ICON_SIZE/2.)
+
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   LABEL_ICON_DECORATION,
+			Box:    geo.NewBox(iconTL, LABEL_ICON_SIZE, LABEL_ICON_SIZE),
+			Label:  sd.opts.messageOpts(message).LabelIcon,
+			Edge:   message,
+			ZIndex: LABEL_Z_INDEX,
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// LabelSide is the side of a message route renderers should place an external label on, drawing a leader line
+// from the label to the anchor returned by LabelAnchor
+type LabelSide string
+
+const (
+	LabelSideAbove LabelSide = "above"
+	LabelSideBelow LabelSide = "below"
+	LabelSideLeft  LabelSide = "left"
+	LabelSideRight LabelSide = "right"
+)
+
+// LabelAnchor returns the midpoint of the route of a laid out message and the side to place its label on.
+// The side is the left of the direction the message travels at the midpoint, so messages to the right get their
+// labels above and their replies below, and self messages get theirs to the right, outside of the loop
+// . ┌─────┐    label   ┌─────┐
+// . │  a  │      ┆     │  b  │
+// . └──┬──┘      ┆     └──┬──┘
+// .    ├─────────●───────►│
+// .    ◄─────────●────────┤
+// .    │         ┆        │
+// .    │       label      │
+// messages without a route, or with a route of no length, have no anchor
+func LabelAnchor(message *d2graph.Edge) (*geo.Point, LabelSide) {
+	route := geo.Route(message.Route)
+	if len(route) < 2 || route.Length() == 0 {
+		return nil, ""
+	}
+	anchor, i := route.GetPointAtDistance(route.Length() / 2)
+	dx := route[i+1].X - route[i].X
+	dy := route[i+1].Y - route[i].Y
+
+	side := LabelSideAbove
+	switch {
+	case dx*dx >= dy*dy && dx < 0:
+		side = LabelSideBelow
+	case dx*dx < dy*dy && dy > 0:
+		side = LabelSideRight
+	case dx*dx < dy*dy:
+		side = LabelSideLeft
+	}
+	return anchor, side
+}
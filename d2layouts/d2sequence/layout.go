@@ -2,6 +2,10 @@ package d2sequence
 
 import (
 	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
 	"strings"
 
 	"oss.terrastruct.com/util-go/go2"
@@ -10,21 +14,455 @@ import (
 	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/geo"
 	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/textmeasure"
 )
 
+type ActivationStyle string
+
+const (
+	// ActivationStyleBox draws spans as boxes over all of their messages
+	ActivationStyleBox ActivationStyle = "box"
+	// ActivationStyleInline draws spans as fixed height markers on the lifeline where they are activated
+	ActivationStyleInline ActivationStyle = "inline"
+	// ActivationStyleLane shades the column of the actor over the active periods instead of drawing boxes, with a
+	// LANE_DECORATION for each outermost span. Spans keep their height but are made transparent and 0 wide
+	ActivationStyleLane ActivationStyle = "lane"
+)
+
+// NestingDirection is the side nested spans of an actor step to, see ActorOpts.NestingDirection
+type NestingDirection string
+
+const (
+	// NestingCentered centers nested spans on the lifeline, wider than the span they are in
+	NestingCentered NestingDirection = ""
+	// NestingRight steps each nested span SPAN_NESTING_OFFSET to the right of the span it is in
+	NestingRight NestingDirection = "right"
+	// NestingLeft steps each nested span SPAN_NESTING_OFFSET to the left of the span it is in
+	NestingLeft NestingDirection = "left"
+)
+
+type ConfigurableOpts struct {
+	// InferActivations creates spans from matched call/return message pairs between actors
+	// instead of requiring them to be declared. The messages then end on the spans, but options keep referring
+	// to them by their declared IDs, e.g. "(a -> b)[0]"
+	InferActivations bool
+
+	// SnapGrid moves every coordinate of the laid out diagram to the nearest multiple of SnapGrid as a last pass,
+	// e.g. 8 to draw crisp lines on a pixel grid. Boxes snap both corners so their sizes are multiples too.
+	// 0 keeps coordinates as laid out
+	SnapGrid float64
+
+	// LegendReserve is empty space kept on the sides of the diagram for a separately rendered legend.
+	// The content is shifted by it but its internal spacing is unchanged
+	LegendReserve geo.Spacing
+
+	// Canvas centers the diagram in a canvas of fixed size, the size of the root, with equal margins on opposite sides.
+	// A diagram larger than the canvas is an error unless CanvasOpts.ScaleDown is set. nil sizes the root to the diagram
+	Canvas *CanvasOpts
+
+	ActivationStyle ActivationStyle
+
+	// LostReturnStubs draws a short stub back from the spans inferred for calls that are never returned,
+	// see LOST_RETURN_DECORATION. It needs InferActivations
+	LostReturnStubs bool
+
+	// MergeActivations replaces the spans of each actor by a single summary box from its first to its last activation
+	MergeActivations bool
+
+	// ClampActivations cuts the spans that extend below the end of their actor lifeline, e.g. with
+	// ActorOpts.LifelineEndY, so that they end with it. Without it, such spans fail the layout
+	ClampActivations bool
+
+	// SpanEndAnchors connects the message that opens a span to its top and the one that closes it to its bottom,
+	// instead of leaving some padding around them
+	SpanEndAnchors bool
+
+	// SkipLifelines leaves the lifeline edges out of the graph for renderers that draw lifelines themselves,
+	// so that the graph edges are only the messages. The diagram is still sized to fit them
+	SkipLifelines bool
+
+	// TruncateLabels is the max width of message labels, longer labels are cut with an ellipsis and measured again
+	// so that the spacing fits the cut label. The full label is kept as the message tooltip, unless it has one.
+	// 0 keeps labels whole
+	TruncateLabels float64
+
+	// SpanColorFromMessage fills spans with the stroke color of the message that opens them
+	// so that call chains can be followed. Spans with their own fill are left as is
+	SpanColorFromMessage bool
+
+	// VerticalScale multiplies the vertical distance between messages, e.g. 0.75 to compress or 1.5 to expand.
+	// It is clamped so that messages and their labels don't overlap. 0 means no scaling.
+	// The gap between the actor headers and the first message, and the lifelines below the last one, are not scaled
+	VerticalScale float64
+
+	// HeaderGap is the distance between the bottom of the actor headers and the first message, it must not be negative.
+	// nil keeps the default of the distance between messages
+	HeaderGap *float64
+
+	// FragmentGap is the minimum vertical distance between groups stacked one above the other, so that their borders
+	// don't touch. nil keeps the default of FRAGMENT_GAP
+	FragmentGap *float64
+
+	// CompactVertical removes the vertical space messages and notes don't need after they are placed,
+	// e.g. when a tall label makes every row taller. Their order is preserved, the gaps made by SpacingHook, TimeScale
+	// and GroupOpts.Spacing are kept and messages stay below the headers of actors with an ActorOpts.EntryY
+	CompactVertical bool
+
+	// BackgroundBands places a band behind each message row, alternately flagged with ALTERNATE_BAND_CLASS
+	// so that tools drawing the decorations can zebra-stripe tall diagrams, see d2graph.Decoration
+	BackgroundBands bool
+
+	// LabelHalos places a background behind each message label, LABEL_HALO_PADDING larger than the label,
+	// so that labels stay readable over lifelines
+	LabelHalos bool
+
+	// Curvature bends the straight messages into arcs: their route gets a midpoint raised by Curvature times
+	// their length, lowered when negative. Self messages keep their loop. 0 keeps messages straight
+	Curvature float64
+
+	// MessageTypeStyles styles messages by their MessageOpts.Type: replies and creates are dashed,
+	// asyncs and creates have an open arrowhead. Stroke dash and arrowheads declared on the message are kept
+	MessageTypeStyles bool
+
+	// StatusColors override DefaultStatusColors for messages with a MessageOpts.Status
+	StatusColors map[MessageStatus]string
+
+	// ReplyLabelPrefix starts the labels of replies with REPLY_LABEL_PREFIX, UML style, unless they start with a colon.
+	// Replies are the messages with MessageOpts.Type MessageReply or inferred like with MessageTypeStyles
+	ReplyLabelPrefix bool
+
+	// MarkerSet is the arrowheads MessageTypeStyles draws for each message type, e.g. HighContrastMarkerSet
+	// for accessible themes. nil is DefaultMarkerSet
+	MarkerSet *MarkerSet
+
+	// TimeScale spaces consecutive messages that both have a MessageOpts.Timestamp proportionally to the time
+	// between them, in pixels per time unit, instead of uniformly. Messages stay at least MIN_MESSAGE_DISTANCE apart
+	TimeScale float64
+
+	// SpacingHook returns the vertical gap between the bottom of a message and the top of the next one, overriding
+	// the default spacing when it is positive. Notes between the messages add to the gap. Concurrent messages
+	// stay side by side
+	SpacingHook func(prev, message *d2graph.Edge) float64
+
+	// UniformActorWidth makes every actor header as wide as the widest one, ActorOpts.Width included,
+	// with the actors evenly spaced as far apart as the ones that need the most space
+	UniformActorWidth bool
+
+	// ReorderActors places the actors in the order that keeps messages short instead of their declaration order,
+	// with a barycenter heuristic: each actor moves towards the actors it exchanges messages with, then pairs of actors
+	// are swapped while it makes messages shorter.
+	// Pinned actors and actors in ActorGroups keep their column
+	ReorderActors bool
+
+	// Actors are options for specific actors, keyed by their absolute ID
+	Actors map[string]ActorOpts
+
+	// ActorGroups draw boxes around the headers of adjacent actors. They can be nested
+	ActorGroups []ActorGroup
+
+	// DurationBrackets draw brackets in a gutter on the right of the diagram, each covering a range of messages
+	DurationBrackets []DurationBracket
+
+	// FrameTitle draws a frame around the diagram with the title in a tab at its top left, e.g. "sd checkout"
+	FrameTitle string
+
+	// Title is drawn centered above the diagram, and the frame if any, with the Subtitle below it.
+	// The diagram moves down to make room for them
+	Title    string
+	Subtitle string
+
+	// Spans are options for specific spans, keyed by their absolute ID
+	Spans map[string]SpanOpts
+
+	// Messages are options for specific messages, keyed by their absolute ID
+	Messages map[string]MessageOpts
+
+	// Notes are options for specific notes, keyed by their absolute ID
+	Notes map[string]NoteOpts
+
+	// Groups are options for specific groups, keyed by their absolute ID
+	Groups map[string]GroupOpts
+
+	// Seed breaks the ties the layout has to choose between, like the drawing order of concurrent messages with
+	// the same priority, in a pseudo-random order that is the same for the same seed. nil keeps the declaration order
+	Seed *int64
+
+	// Ruler measures text the layout adds to the diagram, like message guards.
+	// A new one is created when needed if it is nil
+	Ruler *textmeasure.Ruler
+
+	// actorOffsets keeps actors at these distances from the first actor center, by absolute ID, see ReflowMessages
+	actorOffsets map[string]float64
+	// messageIDs are the IDs of the messages before the layout, see withMessageIDs
+	messageIDs map[*d2graph.Edge]string
+}
+
+// ActorOpts are options that only apply to a single actor
+type ActorOpts struct {
+	// LifelineEndMessage ends the actor lifeline after the message at that index instead of after the last message
+	LifelineEndMessage *int
+	// LifelineEndY ends the actor lifeline at the given y, relative to the top of the sequence diagram.
+	// It takes precedence over LifelineEndMessage
+	LifelineEndY *float64
+	// NestingDirection is the side the nested spans of the actor step to, e.g. NestingLeft for an actor that receives
+	// its messages from the right. Stepped spans keep SPAN_BASE_WIDTH
+	NestingDirection NestingDirection
+	// Pinned keeps the actor in its declared column with ReorderActors
+	Pinned bool
+	// External marks a participant outside of the system: its lifeline is given EXTERNAL_CLASS and drawn with
+	// EXTERNAL_LIFELINE_STROKE_DASH, unless the actor declares a stroke dash
+	External bool
+	// Width is the width of the actor header instead of the one that fits its label, e.g. to align columns with
+	// a grid. The label may overflow a narrow header, the lifeline stays at its center. People, ovals, squares,
+	// circles and images keep their proportions
+	Width float64
+	// MinNextDistance is the minimum distance between the centers of the actor and the actor on its right,
+	// like MIN_ACTOR_DISTANCE but only for that pair. The actors can still be further apart to fit message labels
+	MinNextDistance float64
+	// PlaceholdersAfter reserves that many empty columns on the right of the actor, spaced like actors
+	// but without header or lifeline, e.g. to align with the columns of another diagram
+	PlaceholdersAfter int
+	// EntryY places the top of the actor header at the given y, relative to the top of the sequence diagram,
+	// instead of aligning it with the other headers, for actors that join the interaction later.
+	// The actor cannot have messages above its header
+	EntryY *float64
+	// Anchors are named points on the actor lifeline for external tooling to find with Anchors, e.g. to annotate
+	// or link diagrams
+	Anchors []LifelineAnchor
+	// Stereotype is the kind of participant drawn in guillemets above the actor label, e.g. "boundary" as «boundary».
+	// The header is made taller to fit it
+	Stereotype string
+	// BorderRadius rounds the corners of the actor header, like style.border-radius. It is clamped to half the
+	// smaller side of the header so the lifeline still meets the bottom border
+	BorderRadius *int
+	// Coregions mark ranges of messages to or from the actor whose order does not matter, drawn as brackets
+	// on the lifeline at the top and bottom of the range. The messages are given UNORDERED_CLASS
+	Coregions []Coregion
+	// BorderWidth is the stroke width of the actor header, like style.stroke-width.
+	// The lifeline starts at the outside of the bottom border
+	BorderWidth *int
+}
+
+// Coregion is a range of messages on an actor lifeline, from the absolute ID of the first message to the last
+type Coregion struct {
+	From string
+	To   string
+}
+
+// LifelineAnchor is a named point on a lifeline at the height of a message, or at the top of the lifeline
+// if Message is empty, moved down by Offset
+type LifelineAnchor struct {
+	Name string
+	// Message is the absolute ID of the message
+	Message string
+	Offset  float64
+}
+
+// ActorGroup is a titled group of actors that are declared next to each other
+type ActorGroup struct {
+	Label string
+	// Style is the style of the title, e.g. a larger FontSize. The group leaves room for the title it measures
+	Style d2graph.Style
+	// Actors are the absolute IDs of the actors directly in the group
+	Actors []string
+	// Groups are nested in the group, drawn inside its box
+	Groups []ActorGroup
+	// DividerAfter draws a vertical line over the whole height of the diagram between the group and the actors after
+	// it, with DIVIDER_GAP more space between them
+	DividerAfter bool
+}
+
+// DurationBracket is a labeled bracket on the right of the diagram from the top of a message to the bottom of another,
+// e.g. "200ms" for the time a call took
+type DurationBracket struct {
+	Label string
+	// From and To are the absolute IDs of the first and last message the bracket covers, the same for a single message
+	From string
+	To   string
+}
+
+// SpanOpts are options that only apply to a single span
+type SpanOpts struct {
+	// LeadIn starts the span that many units above its first message instead of the default padding,
+	// e.g. to show processing before the message. It has no effect with ActivationStyleInline
+	LeadIn float64
+	// BorderRadius is passed to renderers through the span style to round its corners, like style.border-radius
+	BorderRadius *int
+	// Suspended marks a blocked activation: the span is given SUSPENDED_CLASS and is hatched with
+	// SUSPENDED_FILL_PATTERN unless it declares a fill pattern
+	Suspended bool
+	// SharedWith is the absolute ID of a span on another actor for the same operation: both spans are stretched
+	// to the same top and bottom. It has no effect with ActivationStyleInline
+	SharedWith string
+	// SharedConnector connects the span to the one it is shared with, see SHARED_ACTIVATION_DECORATION
+	SharedConnector bool
+}
+
+// MessageOpts are options that only apply to a single message
+type MessageOpts struct {
+	// Guard is a condition drawn in brackets before the message label, e.g. "x > 0" as "[x > 0]".
+	// It is placed as a separate decoration so it can be styled independently of the label
+	Guard      string
+	GuardStyle d2graph.Style
+
+	// LabelIcon is a small icon drawn before the message label, e.g. a lock for a secure message, as a
+	// LABEL_ICON_DECORATION labeled with it. The label moves over to make room, and the space between actors fits both.
+	// It needs the message to have a label
+	LabelIcon string
+
+	// ReturnValue is a small annotation of what a reply returns, e.g. "200 OK", drawn as a RETURN_VALUE_DECORATION
+	// with a dashed border just above the arrowhead, apart from the label. The space between actors fits it
+	ReturnValue string
+
+	// ConcurrencyGroup draws the messages with the same group at the same height, the height of the first one
+	ConcurrencyGroup string
+	// Broadcast makes the messages with the same broadcast one message sent to all of their receivers:
+	// they must have the same sender and are drawn in a single row as branches from the sender lifeline
+	Broadcast string
+	// Priority orders the drawing of concurrent messages, higher priorities are drawn on top.
+	// Messages with the same priority are drawn in declaration order, see ConfigurableOpts.Seed
+	Priority int
+
+	// SequenceIndex is the position of the message in the interaction, e.g. from a trace, used to validate that
+	// replies come after their call. Messages are still drawn in declaration order
+	SequenceIndex *int
+
+	// Timestamp is a logical time that orders the message from top to bottom instead of its declaration,
+	// e.g. to merge diagrams on a shared timeline. Messages without one stay after the message declared before them.
+	// Notes are still placed in declaration order
+	Timestamp *float64
+
+	// Type is the kind of message styled with MessageTypeStyles, inferred from the diagram when empty
+	Type MessageType
+
+	// Status colors the message stroke, unless it declares one, and is added to the message classes
+	Status MessageStatus
+
+	// Gate makes the message enter the innermost group containing it from outside: the group only encloses the
+	// message receiving end and the message starts from a gate on the group border
+	Gate bool
+
+	// ToActorGroup sends the message to the innermost actor group of its receiver as a whole: it ends on the group
+	// border, on the side of the sender, at the height of the message. FromActorGroup does the same for its sender.
+	// The receiver or sender must be in one of ConfigurableOpts.ActorGroups
+	ToActorGroup   bool
+	FromActorGroup bool
+
+	// FromFrameGate makes the message come from outside the FrameTitle frame: it starts from a gate on the frame border,
+	// the left border for a message to the right and the right border for a message to the left.
+	// ToFrameGate makes it go out of the frame through a gate on the border it heads to
+	FromFrameGate bool
+	ToFrameGate   bool
+
+	// SelfMessageHeight is the vertical size of the loop of a self message instead of SELF_MESSAGE_HEIGHT.
+	// The messages below move down to fit taller loops
+	SelfMessageHeight float64
+}
+
+// NoteOpts are options that only apply to a single note
+type NoteOpts struct {
+	// State draws the note as a state invariant, a rounded box on the lifeline that fits its label
+	// instead of a page. Like notes, it is placed between the messages declared before and after it
+	State bool
+}
+
+// GroupOpts are options that only apply to a single group
+type GroupOpts struct {
+	// Operator makes the group a combined fragment, e.g. FragmentLoop, drawn with the operator in a tab
+	// at its top left. The group label moves to the top center to leave room for the tab
+	Operator FragmentOperator
+	// Spacing multiplies the vertical gap between consecutive messages in the group, e.g. 0.5 to draw them closer
+	// together than the messages around the group. The innermost group with a Spacing applies. 0 keeps the gap
+	Spacing float64
+}
+
+// withMessageIDs returns a copy of the options that finds the options of the messages by the IDs they have before
+// the layout, since InferActivations moves their ends to the spans it infers and so changes their IDs
+func (opts *ConfigurableOpts) withMessageIDs(messages []*d2graph.Edge) *ConfigurableOpts {
+	c := *opts
+	c.messageIDs = make(map[*d2graph.Edge]string, len(messages))
+	for _, message := range messages {
+		c.messageIDs[message] = message.AbsID()
+	}
+	return &c
+}
+
+// messageID is the ID options refer to the message by
+func (opts *ConfigurableOpts) messageID(message *d2graph.Edge) string {
+	if id, has := opts.messageIDs[message]; has {
+		return id
+	}
+	return message.AbsID()
+}
+
+// declaredMessageID is the ID a message of a laid out graph had before the layout, the one options refer to it by,
+// for the queries on laid out graphs that have no options
+func declaredMessageID(g *d2graph.Graph, message *d2graph.Edge) string {
+	src, dst := g.DeclaredEndpoints(message)
+	if src == message.Src && dst == message.Dst {
+		return message.AbsID()
+	}
+	declared := *message
+	declared.Src, declared.Dst = src, dst
+	return declared.AbsID()
+}
+
+// messageOpts are the options of the message, see messageID
+func (opts *ConfigurableOpts) messageOpts(message *d2graph.Edge) MessageOpts {
+	return opts.Messages[opts.messageID(message)]
+}
+
+// concurrencyGroup is the group of messages drawn at the same height, broadcasts are drawn like concurrent messages
+func (opts MessageOpts) concurrencyGroup() string {
+	if opts.ConcurrencyGroup == "" && opts.Broadcast != "" {
+		return "broadcast " + opts.Broadcast
+	}
+	return opts.ConcurrencyGroup
+}
+
+var DefaultOpts = ConfigurableOpts{
+	ActivationStyle: ActivationStyleBox,
+}
+
 // Layout runs the sequence diagram layout engine on objects of shape sequence_diagram
 //
 // 1. Run layout on sequence diagrams
 // 2. Set the resulting dimensions to the main graph shape
+//
+// Only the geometry of messages is set, their style attributes (e.g. stroke-dash, stroke-width) are left
+// as declared for renderers to draw any line style, unless ConfigurableOpts.MessageTypeStyles is set.
+// Message tooltips are kept too, they are hover notes that take no space, unlike notes.
+// The frames, bands, gates and other decorations the options add are placed in g.Decorations, which only exist on
+// the graph and are not exported to d2target, see d2graph.Decoration
 func Layout(ctx context.Context, g *d2graph.Graph, layout d2graph.LayoutGraph) error {
+	return LayoutWithOpts(ctx, g, layout, nil)
+}
+
+// LayoutWithOpts is Layout with non-default options. A nil opts uses DefaultOpts
+func LayoutWithOpts(ctx context.Context, g *d2graph.Graph, layout d2graph.LayoutGraph, opts *ConfigurableOpts) error {
+	if opts == nil {
+		opts = &DefaultOpts
+	}
 	// used in layout code
 	g.Root.Shape.Value = d2target.ShapeSequenceDiagram
+	// laying out an already laid out graph starts over instead of stacking another set of lifelines
+	removeLayoutElements(g)
+	saveLayoutInputs(g)
+	if err := ValidateWithOpts(g, opts); err != nil {
+		return err
+	}
+	opts = opts.withMessageIDs(g.Edges)
 
-	sd, err := layoutSequenceDiagram(g, g.Root)
+	sd, err := layoutSequenceDiagram(ctx, g, g.Root, opts)
 	if err != nil {
 		return err
 	}
-	g.Root.Box = geo.NewBox(nil, sd.getWidth()+GROUP_CONTAINER_PADDING*2, sd.getHeight()+GROUP_CONTAINER_PADDING*2)
+	reserve := opts.LegendReserve
+	bounds := sd.getBounds()
+	g.Root.Box = geo.NewBox(nil,
+		bounds.Width+GROUP_CONTAINER_PADDING*2+reserve.Left+reserve.Right,
+		bounds.Height+GROUP_CONTAINER_PADDING*2+reserve.Top+reserve.Bottom,
+	)
 
 	// the sequence diagram is the only layout engine if the whole diagram is
 	// shape: sequence_diagram
@@ -37,14 +475,23 @@ func Layout(ctx context.Context, g *d2graph.Graph, layout d2graph.LayoutGraph) e
 	// shift the sequence diagrams as they are always placed at (0, 0) with some padding
 	sd.shift(
 		geo.NewPoint(
-			obj.TopLeft.X+GROUP_CONTAINER_PADDING,
-			obj.TopLeft.Y+GROUP_CONTAINER_PADDING,
+			obj.TopLeft.X+GROUP_CONTAINER_PADDING+reserve.Left-bounds.TopLeft.X,
+			obj.TopLeft.Y+GROUP_CONTAINER_PADDING+reserve.Top-bounds.TopLeft.Y,
 		),
 	)
 
+	if opts.Canvas != nil {
+		if err := sd.fitCanvas(obj, *opts.Canvas); err != nil {
+			return err
+		}
+	}
+
 	obj.Children = make(map[string]*d2graph.Object)
 	obj.ChildrenArray = make([]*d2graph.Object, 0)
 	for _, child := range sd.actors {
+		if sd.placeholders[child] {
+			continue
+		}
 		obj.Children[strings.ToLower(child.ID)] = child
 		obj.ChildrenArray = append(obj.ChildrenArray, child)
 	}
@@ -55,13 +502,244 @@ func Layout(ctx context.Context, g *d2graph.Graph, layout d2graph.LayoutGraph) e
 		}
 	}
 
-	g.Edges = append(g.Edges, sd.lifelines...)
+	sortConcurrentMessages(g, opts)
+	if !opts.SkipLifelines {
+		g.Edges = append(g.Edges, sd.lifelines...)
+	}
+	for _, d := range sd.decorations {
+		d.Owner = DECORATION_OWNER
+	}
+	g.Decorations = append(g.Decorations, sd.decorations...)
+	if opts.SnapGrid > 0 {
+		snapToGrid(g, opts.SnapGrid)
+	}
+	// what is changed after the layout is kept by the next one, see saveLayoutInputs
+	g.SaveLayoutOutputs()
+
+	return nil
+}
+
+// Validate checks that the graph is a sequence diagram that can be laid out.
+// Like Layout, it returns a *LayoutError pointing to the offending object
+func Validate(g *d2graph.Graph) error {
+	return ValidateWithOpts(g, nil)
+}
+
+// ValidateWithOpts is Validate with the checks that depend on options, like the order of MessageOpts.SequenceIndex
+func ValidateWithOpts(g *d2graph.Graph, opts *ConfigurableOpts) error {
+	if opts == nil {
+		opts = &DefaultOpts
+	}
+	if !g.Root.IsSequenceDiagram() {
+		return errorf(VALIDATE_STAGE, g.Root, "%s is not a sequence diagram", g.Root.AbsID())
+	}
+	if opts.HeaderGap != nil && *opts.HeaderGap < 0 {
+		return errorf(VALIDATE_STAGE, g.Root, "header gap of %v must not be negative", *opts.HeaderGap)
+	}
+	hasActor := false
+	for _, obj := range g.Root.ChildrenArray {
+		if !obj.IsSequenceDiagramGroup() {
+			hasActor = true
+			break
+		}
+	}
+	if !hasActor {
+		return errorf(VALIDATE_STAGE, g.Root, "no actors declared in sequence diagram")
+	}
+	// the compiler merges objects with the same ID, graphs built by hand may not
+	actors := make(map[string]*d2graph.Object)
+	for _, obj := range g.Root.ChildrenArray {
+		if obj.IsSequenceDiagramGroup() {
+			continue
+		}
+		if _, has := actors[obj.AbsID()]; has {
+			return errorf(VALIDATE_STAGE, obj, "actor %s is declared more than once", obj.AbsID())
+		}
+		actors[obj.AbsID()] = obj
+	}
+
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			continue
+		}
+		for _, endpoint := range []*d2graph.Object{edge.Src, edge.Dst} {
+			// messages connect actors or their descendants, never groups
+			top := endpoint
+			for top != nil && top.Parent != g.Root {
+				top = top.Parent
+			}
+			if top == nil || top.IsSequenceDiagramGroup() {
+				return errorf(VALIDATE_STAGE, endpoint, "could not find center of %s. Is it declared as an actor?", endpoint.ID)
+			}
+		}
+	}
+	if err := validateBroadcasts(g, opts); err != nil {
+		return err
+	}
+	return validateCausality(g.Root, g.Edges, opts)
+}
 
+func validateBroadcasts(g *d2graph.Graph, opts *ConfigurableOpts) error {
+	senders := make(map[string]*d2graph.Object)
+	for _, edge := range g.Edges {
+		broadcast := opts.messageOpts(edge).Broadcast
+		if broadcast == "" {
+			continue
+		}
+		if sender, has := senders[broadcast]; has && sender != edge.Src {
+			return edgeErrorf(VALIDATE_STAGE, edge, "message %s of broadcast %#v must be sent by %s like the others", edge.AbsID(), broadcast, sender.AbsID())
+		}
+		senders[broadcast] = edge.Src
+	}
 	return nil
 }
 
+// validateCausality checks that replies from a span to the message that opened it do not come before it
+// when messages have a SequenceIndex. The layout checks the messages again once InferActivations gave them spans
+func validateCausality(root *d2graph.Object, messages []*d2graph.Edge, opts *ConfigurableOpts) error {
+	calls := openingCalls(root, messages)
+	for _, edge := range messages {
+		call, isReply := replyTo(calls, edge)
+		if !isReply {
+			continue
+		}
+		callIndex := opts.messageOpts(call).SequenceIndex
+		replyIndex := opts.messageOpts(edge).SequenceIndex
+		if callIndex != nil && replyIndex != nil && *replyIndex < *callIndex {
+			return edgeErrorf(VALIDATE_STAGE, edge, "reply %s comes before its call %s", edge.AbsID(), call.AbsID())
+		}
+	}
+	return nil
+}
+
+// removeLayoutElements removes the lifelines and decorations added by a previous Layout on the graph
+// and gives the objects and messages back the inputs it changed in place, see d2graph.Graph.RestoreLayoutInputs
+func removeLayoutElements(g *d2graph.Graph) {
+	g.RestoreLayoutInputs()
+
+	var edges []*d2graph.Edge
+	for _, edge := range g.Edges {
+		if !IsLifelineEnd(edge.Dst) {
+			edges = append(edges, edge)
+		}
+	}
+	g.Edges = edges
+
+	var decorations []*d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Owner != DECORATION_OWNER {
+			decorations = append(decorations, d)
+		}
+	}
+	g.Decorations = decorations
+}
+
+// saveLayoutInputs keeps the objects and messages as they are before the layout changes them in place, e.g. the
+// sizes of scaled labels or the styles of MessageTypeStyles, for removeLayoutElements to give them back on the next
+// layout, so that they are not taken for declared ones. Values changed after the layout, e.g. a label edited before
+// ReflowMessages, are kept
+func saveLayoutInputs(g *d2graph.Graph) {
+	// the root gets its children in the order of the actors
+	g.SaveObjectInputs(g.Root)
+	for _, obj := range g.Objects {
+		g.SaveObjectInputs(obj)
+	}
+	for _, edge := range g.Edges {
+		g.SaveEdgeInputs(edge)
+	}
+}
+
+// DedupLifelines removes the lifelines that repeat another lifeline of the same actor with the same route,
+// e.g. when lifelines were added again to a laid out graph without going through Layout. It returns how many
+// were removed
+func DedupLifelines(g *d2graph.Graph) int {
+	type key struct {
+		actor *d2graph.Object
+		route string
+	}
+	seen := make(map[key]bool)
+	var edges []*d2graph.Edge
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			var route strings.Builder
+			for _, p := range edge.Route {
+				fmt.Fprintf(&route, "%v,%v;", p.X, p.Y)
+			}
+			k := key{edge.Src, route.String()}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		edges = append(edges, edge)
+	}
+	removed := len(g.Edges) - len(edges)
+	g.Edges = edges
+	return removed
+}
+
+// sortConcurrentMessages reorders the edges of each concurrency group by priority, since renderers draw edges
+// with the same z-index in order. Ties are broken with ConfigurableOpts.Seed.
+// The edges are in their declaration order, since laying out the graph again undoes the reordering,
+// see d2graph.Graph.ReorderEdges
+func sortConcurrentMessages(g *d2graph.Graph, opts *ConfigurableOpts) {
+	edges := append([]*d2graph.Edge(nil), g.Edges...)
+	indices := make(map[string][]int)
+	var groups []string
+	for i, edge := range edges {
+		group := opts.messageOpts(edge).concurrencyGroup()
+		if group == "" {
+			continue
+		}
+		if _, exists := indices[group]; !exists {
+			groups = append(groups, group)
+		}
+		indices[group] = append(indices[group], i)
+	}
+
+	var rng *rand.Rand
+	if opts.Seed != nil {
+		rng = rand.New(rand.NewSource(*opts.Seed))
+	}
+	for _, group := range groups {
+		var messages []*d2graph.Edge
+		for _, i := range indices[group] {
+			messages = append(messages, edges[i])
+		}
+		if rng != nil {
+			// the stable sort keeps the shuffled order of messages with the same priority
+			rng.Shuffle(len(messages), func(i, j int) {
+				messages[i], messages[j] = messages[j], messages[i]
+			})
+		}
+		sort.SliceStable(messages, func(i, j int) bool {
+			return opts.messageOpts(messages[i]).Priority < opts.messageOpts(messages[j]).Priority
+		})
+		for k, i := range indices[group] {
+			edges[i] = messages[k]
+		}
+	}
+	g.ReorderEdges(edges)
+}
+
+// sortByTimestamp orders the messages by MessageOpts.Timestamp, messages without one take the timestamp
+// of the message before them
+func sortByTimestamp(messages []*d2graph.Edge, opts *ConfigurableOpts) {
+	timestamps := make(map[*d2graph.Edge]float64, len(messages))
+	timestamp := math.Inf(-1)
+	for _, message := range messages {
+		if t := opts.messageOpts(message).Timestamp; t != nil {
+			timestamp = *t
+		}
+		timestamps[message] = timestamp
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return timestamps[messages[i]] < timestamps[messages[j]]
+	})
+}
+
 // layoutSequenceDiagram finds the edges inside the sequence diagram and performs the layout on the object descendants
-func layoutSequenceDiagram(g *d2graph.Graph, obj *d2graph.Object) (*sequenceDiagram, error) {
+func layoutSequenceDiagram(ctx context.Context, g *d2graph.Graph, obj *d2graph.Object, opts *ConfigurableOpts) (*sequenceDiagram, error) {
 	var edges []*d2graph.Edge
 	for _, edge := range g.Edges {
 		// both Src and Dst must be inside the sequence diagram
@@ -70,10 +748,32 @@ func layoutSequenceDiagram(g *d2graph.Graph, obj *d2graph.Object) (*sequenceDiag
 		}
 	}
 
-	sd, err := newSequenceDiagram(obj.ChildrenArray, edges)
+	sortByTimestamp(edges, opts)
+
+	var unanswered map[*d2graph.Object]*d2graph.Edge
+	if opts.InferActivations {
+		unanswered = inferActivations(obj, edges)
+		if err := validateCausality(obj, edges, opts); err != nil {
+			return nil, err
+		}
+	}
+	if opts.MessageTypeStyles {
+		applyMessageTypeStyles(obj, edges, opts)
+	}
+	if err := applyMessageStatuses(edges, opts); err != nil {
+		return nil, err
+	}
+
+	sd, err := newSequenceDiagram(obj.ChildrenArray, edges, opts)
 	if err != nil {
 		return nil, err
 	}
+	if opts.LostReturnStubs {
+		sd.unanswered = unanswered
+	}
+	if opts.VerticalScale > 0 && opts.VerticalScale != 1 {
+		sd.scaleYStep(ctx, opts.VerticalScale)
+	}
 	err = sd.layout()
 	return sd, err
 }
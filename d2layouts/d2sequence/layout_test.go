@@ -2,19 +2,30 @@ package d2sequence_test
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
+	"oss.terrastruct.com/util-go/go2"
+
 	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2exporter"
 	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts"
+	"oss.terrastruct.com/d2/d2layouts/d2dagrelayout"
 	"oss.terrastruct.com/d2/d2layouts/d2sequence"
 	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/geo"
 	"oss.terrastruct.com/d2/lib/label"
 	"oss.terrastruct.com/d2/lib/log"
 	"oss.terrastruct.com/d2/lib/shape"
+	"oss.terrastruct.com/d2/lib/textmeasure"
 )
 
 func TestBasicSequenceDiagram(t *testing.T) {
@@ -462,3 +473,2601 @@ func TestSequenceToDescendant(t *testing.T) {
 		t.Fatal("expected route to end at `a` lifeline")
 	}
 }
+
+func TestActorWithoutBox(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+
+	g.Edges = []*d2graph.Edge{
+		{
+			Src: a,
+			Dst: b,
+		},
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.Box == nil || b.TopLeft == nil {
+		t.Fatal("expected `b` to have been given a default box and placed")
+	}
+	if b.Width != d2graph.DEFAULT_SHAPE_SIZE || b.Height != d2graph.DEFAULT_SHAPE_SIZE {
+		t.Fatalf("expected `b` to have the default size, got %.5fx%.5f", b.Width, b.Height)
+	}
+	if g.Edges[0].Route[1].X != b.Center().X {
+		t.Fatal("expected the message to end at `b` lifeline")
+	}
+}
+
+func TestInferActivations(t *testing.T) {
+	//   ┌─────┐                 ┌─────┐
+	//   │  a  │                 │  b  │
+	//   └──┬──┘                 └──┬──┘
+	//      ├──────── call ───────►┌┤
+	//      │                      ││ inferred
+	//      ◄─────── return ───────└┤
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+
+	call := &d2graph.Edge{Src: a, Dst: b, DstArrow: true}
+	ret := &d2graph.Edge{Src: b, Dst: a, DstArrow: true}
+	g.Edges = []*d2graph.Edge{call, ret}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{InferActivations: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b.ChildrenArray) != 1 {
+		t.Fatalf("expected 1 inferred span on b, got %d", len(b.ChildrenArray))
+	}
+	span := b.ChildrenArray[0]
+	if call.Dst != span || ret.Src != span {
+		t.Fatal("expected the call and its return to be connected to the inferred span")
+	}
+	if call.Src != a || ret.Dst != a {
+		t.Fatal("expected the caller side to stay on the actor")
+	}
+	if span.ZIndex != d2sequence.SPAN_Z_INDEX {
+		t.Fatalf("expected span ZIndex=%d, got %d", d2sequence.SPAN_Z_INDEX, span.ZIndex)
+	}
+	if span.TopLeft.Y+d2sequence.SPAN_MESSAGE_PAD != call.Route[0].Y {
+		t.Fatal("expected the span to start at the call")
+	}
+	if span.TopLeft.Y+span.Height-d2sequence.SPAN_MESSAGE_PAD != ret.Route[0].Y {
+		t.Fatal("expected the span to end at the return")
+	}
+	if span.Center().X != b.Center().X {
+		t.Fatal("expected the span to be on b lifeline")
+	}
+	if len(a.ChildrenArray) != 0 {
+		t.Fatal("expected no span to be inferred on the caller")
+	}
+}
+
+func TestInferActivationsMessageOpts(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: call
+b -> a: return
+a -> b: again
+`), nil)
+	assert.Nil(t, err)
+	call, ret := g.Edges[0], g.Edges[1]
+
+	// options refer to the messages by their declared IDs, not by the IDs they get once they end on inferred spans
+	opts := &d2sequence.ConfigurableOpts{
+		InferActivations: true,
+		Messages: map[string]d2sequence.MessageOpts{
+			"(a -> b)[0]": {Guard: "ready"},
+			"(b -> a)[0]": {ReturnValue: "ok"},
+		},
+		Actors: map[string]d2sequence.ActorOpts{
+			"a": {Coregions: []d2sequence.Coregion{{From: "(a -> b)[0]", To: "(a -> b)[1]"}}},
+		},
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+	for i := 0; i < 2; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "(a -> b.activation-0)[0]", call.AbsID())
+
+		kinds := make(map[string][]*d2graph.Decoration)
+		for _, d := range g.Decorations {
+			kinds[d.Kind] = append(kinds[d.Kind], d)
+		}
+		if len(kinds[d2sequence.GUARD_DECORATION]) != 1 || kinds[d2sequence.GUARD_DECORATION][0].Edge != call {
+			t.Fatalf("expected a guard on the call after %d layouts, got %v", i+1, kinds[d2sequence.GUARD_DECORATION])
+		}
+		if len(kinds[d2sequence.RETURN_VALUE_DECORATION]) != 1 || kinds[d2sequence.RETURN_VALUE_DECORATION][0].Edge != ret {
+			t.Fatalf("expected a return value on the return after %d layouts, got %v", i+1, kinds[d2sequence.RETURN_VALUE_DECORATION])
+		}
+		assert.Equal(t, 1, len(kinds[d2sequence.COREGION_DECORATION]))
+	}
+}
+
+func TestLegendReserve(t *testing.T) {
+	newGraph := func() *d2graph.Graph {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}}
+		return g
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	base := newGraph()
+	if err := d2sequence.Layout(ctx, base, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reserve := geo.Spacing{Top: 50, Left: 70, Right: 20}
+	g := newGraph()
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{LegendReserve: reserve}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, obj := range g.Objects {
+		if obj.TopLeft.X != base.Objects[i].TopLeft.X+reserve.Left || obj.TopLeft.Y != base.Objects[i].TopLeft.Y+reserve.Top {
+			t.Fatalf("expected %s to be offset by the reserved space", obj.AbsID())
+		}
+	}
+	for i, edge := range g.Edges {
+		for j, p := range edge.Route {
+			baseP := base.Edges[i].Route[j]
+			if p.X != baseP.X+reserve.Left || p.Y != baseP.Y+reserve.Top {
+				t.Fatalf("expected edge[%d] to be offset by the reserved space", i)
+			}
+		}
+	}
+	if g.Root.Width != base.Root.Width+reserve.Left+reserve.Right {
+		t.Fatalf("expected width to grow by the reserved space, got %.5f", g.Root.Width)
+	}
+	if g.Root.Height != base.Root.Height+reserve.Top {
+		t.Fatalf("expected height to grow by the reserved space, got %.5f", g.Root.Height)
+	}
+}
+
+func TestMixedHeightActorsBottomAligned(t *testing.T) {
+	// ┌────────┐
+	// │        │
+	// │   a    │  ┌────────┐
+	// │        │  │   b    │
+	// └────┬───┘  └────┬───┘
+	//      │           │
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 30)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	aBottom := a.TopLeft.Y + a.Height
+	bBottom := b.TopLeft.Y + b.Height
+	if aBottom != bBottom {
+		t.Fatalf("expected actors to be bottom aligned, got %.5f and %.5f", aBottom, bBottom)
+	}
+	if b.TopLeft.Y-a.TopLeft.Y != a.Height-b.Height {
+		t.Fatal("expected the shorter actor to be padded from the top")
+	}
+
+	lifelines := map[*d2graph.Object]*d2graph.Edge{}
+	for _, e := range g.Edges {
+		if d2sequence.IsLifelineEnd(e.Dst) {
+			lifelines[e.Src] = e
+		}
+	}
+	if lifelines[a].Route[0].Y != aBottom {
+		t.Fatalf("expected a lifeline to start at its bottom %.5f, got %.5f", aBottom, lifelines[a].Route[0].Y)
+	}
+	if lifelines[b].Route[0].Y != bBottom {
+		t.Fatalf("expected b lifeline to start at its bottom %.5f, got %.5f", bBottom, lifelines[b].Route[0].Y)
+	}
+	if lifelines[a].Route[1].Y != lifelines[b].Route[1].Y {
+		t.Fatal("expected lifelines to end at the same y")
+	}
+}
+
+func TestInlineActivationStyle(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a
+b
+a -> b.t1
+b.t1 -> a
+a -> b.t1
+b.t1 -> a
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+
+	a, _ := g.Root.HasChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b, _ := g.Root.HasChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	b_t1, _ := b.HasChild([]string{"t1"})
+	b_t1.Box = geo.NewBox(nil, 100, 100)
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{ActivationStyle: d2sequence.ActivationStyleInline})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b_t1.Height != d2sequence.INLINE_SPAN_HEIGHT {
+		t.Fatalf("expected inline span height %.5f, got %.5f", d2sequence.INLINE_SPAN_HEIGHT, b_t1.Height)
+	}
+	if b_t1.Width != d2sequence.INLINE_SPAN_WIDTH {
+		t.Fatalf("expected inline span width %.5f, got %.5f", d2sequence.INLINE_SPAN_WIDTH, b_t1.Width)
+	}
+	if b_t1.Center().Y != g.Edges[0].Route[0].Y {
+		t.Fatal("expected the inline span to be centered on its first message")
+	}
+	lastMessageY := g.Edges[3].Route[0].Y
+	if b_t1.TopLeft.Y+b_t1.Height >= lastMessageY {
+		t.Fatal("expected the inline span not to span over its messages")
+	}
+}
+
+func TestVerticalScale(t *testing.T) {
+	// the gap between the headers and the first message keeps its size
+	var headerGaps []float64
+	layoutGaps := func(scale float64) []float64 {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: a}, {Src: a, Dst: b}}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{VerticalScale: scale}); err != nil {
+			t.Fatal(err)
+		}
+		headerGaps = append(headerGaps, g.Edges[0].Route[0].Y-(a.TopLeft.Y+a.Height))
+		var gaps []float64
+		for i := 1; i < 3; i++ {
+			gaps = append(gaps, g.Edges[i].Route[0].Y-g.Edges[i-1].Route[0].Y)
+		}
+		return gaps
+	}
+
+	base := layoutGaps(0)
+	for _, scale := range []float64{0.75, 1.5} {
+		for i, gap := range layoutGaps(scale) {
+			if gap != base[i]*scale {
+				t.Fatalf("expected gap[%d] to be %.5f with scale %.2f, got %.5f", i, base[i]*scale, scale, gap)
+			}
+		}
+	}
+
+	for i, gap := range layoutGaps(0.01) {
+		if gap != d2sequence.MIN_MESSAGE_DISTANCE {
+			t.Fatalf("expected gap[%d] to be clamped to %.5f, got %.5f", i, d2sequence.MIN_MESSAGE_DISTANCE, gap)
+		}
+	}
+	for _, gap := range headerGaps[1:] {
+		assert.Equal(t, headerGaps[0], gap)
+	}
+}
+
+func TestLifelineEnd(t *testing.T) {
+	newGraph := func() *d2graph.Graph {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		c := g.Root.EnsureChild([]string{"c"})
+		c.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: c}, {Src: c, Dst: a}}
+		return g
+	}
+	lifelineEnds := func(g *d2graph.Graph) map[string]float64 {
+		ends := make(map[string]float64)
+		for _, e := range g.Edges {
+			if d2sequence.IsLifelineEnd(e.Dst) {
+				ends[e.Src.ID] = e.Route[1].Y
+			}
+		}
+		return ends
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	base := newGraph()
+	if err := d2sequence.Layout(ctx, base, nil); err != nil {
+		t.Fatal(err)
+	}
+	baseEnds := lifelineEnds(base)
+
+	g := newGraph()
+	opts := &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"b": {LifelineEndMessage: go2.Pointer(1)},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	ends := lifelineEnds(g)
+
+	if ends["b"] >= baseEnds["b"] {
+		t.Fatalf("expected b lifeline to be shortened, got %.5f (full length %.5f)", ends["b"], baseEnds["b"])
+	}
+	if ends["b"] <= g.Edges[1].Route[0].Y {
+		t.Fatal("expected b lifeline to end after its last message")
+	}
+	if ends["a"] != baseEnds["a"] || ends["c"] != baseEnds["c"] {
+		t.Fatal("expected other lifelines to keep their length")
+	}
+	if g.Root.Height != base.Root.Height {
+		t.Fatal("expected the diagram height to be unchanged")
+	}
+
+	g = newGraph()
+	opts.Actors["b"] = d2sequence.ActorOpts{LifelineEndY: go2.Pointer(baseEnds["b"] + 100)}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if g.Root.Height <= base.Root.Height {
+		t.Fatal("expected an extended lifeline to grow the diagram")
+	}
+}
+
+func TestLayoutIdempotent(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c
+a -> b.t1: call
+b.t1 -> c
+c -> c: self
+b.t1 -> a: return
+g: {
+  c -> a
+}
+a.note: a note
+a -> c: ask
+c -> a: answer
+a -> b: lost
+`
+	newGraph := func() *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range g.Objects {
+			obj.LabelDimensions = d2target.TextDimensions{Width: 37, Height: 13}
+		}
+		for _, edge := range g.Edges {
+			edge.LabelDimensions = d2target.TextDimensions{Width: 41, Height: 13}
+		}
+		return g
+	}
+	scalar := func(s *d2graph.Scalar) string {
+		if s == nil {
+			return "-"
+		}
+		return s.Value
+	}
+	style := func(s d2graph.Style) string {
+		return fmt.Sprintf("font-size=%s stroke=%s stroke-dash=%s stroke-width=%s fill=%s fill-pattern=%s border-radius=%s",
+			scalar(s.FontSize), scalar(s.Stroke), scalar(s.StrokeDash), scalar(s.StrokeWidth), scalar(s.Fill), scalar(s.FillPattern), scalar(s.BorderRadius))
+	}
+	snapshot := func(g *d2graph.Graph) string {
+		var sb strings.Builder
+		for _, obj := range g.Objects {
+			fmt.Fprintf(&sb, "%s %v %v %v %v %s %v\n", obj.AbsID(), obj.TopLeft, obj.Width, obj.Height, obj.LabelDimensions, style(obj.Style), obj.Classes)
+		}
+		for _, edge := range g.Edges {
+			fmt.Fprintf(&sb, "%s %q %v %s %v %s", edge.AbsID(), edge.Label.Value, edge.LabelDimensions, style(edge.Style), edge.Classes, scalar(edge.Tooltip))
+			if edge.DstArrowhead != nil {
+				fmt.Fprintf(&sb, " arrowhead=%s", edge.DstArrowhead.Shape.Value)
+			}
+			if edge.LabelPosition != nil {
+				fmt.Fprintf(&sb, " label-position=%s", *edge.LabelPosition)
+			}
+			for _, p := range edge.Route {
+				fmt.Fprintf(&sb, " %v", *p)
+			}
+			sb.WriteString("\n")
+		}
+		for _, d := range g.Decorations {
+			fmt.Fprintf(&sb, "%s %q %v %v %v\n", d.Kind, d.Label, d.TopLeft, d.Width, d.Height)
+		}
+		fmt.Fprintf(&sb, "%v %v\n", g.Root.Width, g.Root.Height)
+		return sb.String()
+	}
+
+	// the options that change the graph in place, beyond placing its elements
+	testCases := []struct {
+		name string
+		opts d2sequence.ConfigurableOpts
+	}{
+		{name: "background bands", opts: d2sequence.ConfigurableOpts{BackgroundBands: true, LabelHalos: true}},
+		{name: "stereotypes", opts: d2sequence.ConfigurableOpts{Actors: map[string]d2sequence.ActorOpts{"a": {Stereotype: "boundary"}}}},
+		{name: "actor width", opts: d2sequence.ConfigurableOpts{UniformActorWidth: true, Actors: map[string]d2sequence.ActorOpts{"b": {Width: 160}}}},
+		{name: "truncated labels", opts: d2sequence.ConfigurableOpts{TruncateLabels: 20}},
+		{name: "message types", opts: d2sequence.ConfigurableOpts{MessageTypeStyles: true, ReplyLabelPrefix: true, SpanColorFromMessage: true}},
+		{name: "merged activations", opts: d2sequence.ConfigurableOpts{MergeActivations: true}},
+		{name: "activation lanes", opts: d2sequence.ConfigurableOpts{ActivationStyle: d2sequence.ActivationStyleLane}},
+		{name: "frame and title", opts: d2sequence.ConfigurableOpts{FrameTitle: "sd", Title: "title", Subtitle: "subtitle"}},
+		{name: "canvas scaled down", opts: d2sequence.ConfigurableOpts{Canvas: &d2sequence.CanvasOpts{Width: 300, Height: 300, ScaleDown: true}}},
+		{name: "snapped to grid", opts: d2sequence.ConfigurableOpts{SnapGrid: 8}},
+		{name: "lost returns", opts: d2sequence.ConfigurableOpts{InferActivations: true, LostReturnStubs: true}},
+		{name: "reordered actors", opts: d2sequence.ConfigurableOpts{ReorderActors: true}},
+		{name: "guards and icons", opts: d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{
+			"(a -> b.t1)[0]": {Guard: "x > 0", LabelIcon: "lock"},
+		}}},
+		{name: "actor and span styles", opts: d2sequence.ConfigurableOpts{
+			Actors: map[string]d2sequence.ActorOpts{"a": {BorderRadius: go2.Pointer(4), BorderWidth: go2.Pointer(3)}},
+			Spans:  map[string]d2sequence.SpanOpts{"b.t1": {Suspended: true, BorderRadius: go2.Pointer(2)}},
+		}},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := log.WithTB(context.Background(), t, nil)
+			once := newGraph()
+			if err := d2sequence.LayoutWithOpts(ctx, once, nil, &tc.opts); err != nil {
+				t.Fatal(err)
+			}
+
+			twice := newGraph()
+			for i := 0; i < 2; i++ {
+				if err := d2sequence.LayoutWithOpts(ctx, twice, nil, &tc.opts); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(twice.Edges) != len(once.Edges) {
+				t.Fatalf("expected %d edges after laying out twice, got %d", len(once.Edges), len(twice.Edges))
+			}
+			if len(twice.Decorations) != len(once.Decorations) {
+				t.Fatalf("expected %d decorations after laying out twice, got %d", len(once.Decorations), len(twice.Decorations))
+			}
+			assert.Equal(t, snapshot(once), snapshot(twice))
+
+			// laying out again without the options starts over from the declared graph, not from what they changed
+			plain := newGraph()
+			if err := d2sequence.Layout(ctx, plain, nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := d2sequence.Layout(ctx, twice, nil); err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, snapshot(plain), snapshot(twice))
+		})
+	}
+}
+
+func TestLayoutKeepsEdits(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.t: call
+b.t -> a: reply
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{MessageTypeStyles: true}); err != nil {
+		t.Fatal(err)
+	}
+	reply := g.Edges[1]
+	assert.NotNil(t, reply.Style.StrokeDash)
+
+	// an editor changes the reply after the layout, the next layout keeps the change but not the dash it set
+	reply.Label.Value = "edited"
+	reply.Style.Stroke = &d2graph.Scalar{Value: "red"}
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "edited", reply.Label.Value)
+	assert.Equal(t, "red", reply.Style.Stroke.Value)
+	assert.Nil(t, reply.Style.StrokeDash)
+}
+
+func TestLayoutError(t *testing.T) {
+	// b is only declared in a group so it has no lifeline for the message to connect to
+	input := `
+shape: sequence_diagram
+a
+group: {
+  inner_group: {
+    a -> b
+  }
+}
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, has := g.Root.HasChild([]string{"group", "inner_group", "b"})
+	if !has {
+		t.Fatal("expected b to be compiled in the group")
+	}
+
+	var layoutErr *d2sequence.LayoutError
+	err = d2sequence.Validate(g)
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a LayoutError, got %v", err)
+	}
+	if layoutErr.Object != b {
+		t.Fatalf("expected the error to point to b, got %v", layoutErr.Object)
+	}
+	if layoutErr.Stage != d2sequence.VALIDATE_STAGE {
+		t.Fatalf("expected a validation error, got %s", layoutErr.Stage)
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.Layout(ctx, g, nil)
+	if !errors.As(err, &layoutErr) || layoutErr.Object != b {
+		t.Fatalf("expected Layout to fail with an error pointing to b, got %v", err)
+	}
+	assert.Equal(t, "could not find center of b. Is it declared as an actor?", err.Error())
+
+	g = d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	c := g.Root.EnsureChild([]string{"c"})
+	c.Box = geo.NewBox(nil, 100, 100)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: c}}
+	opts := &d2sequence.ConfigurableOpts{
+		ActorGroups: []d2sequence.ActorGroup{{Label: "one", Actors: []string{"a"}}, {Label: "two", Actors: []string{"a", "c"}}},
+	}
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, opts)
+	if !errors.As(err, &layoutErr) || layoutErr.Object != a || layoutErr.Stage != d2sequence.ACTOR_GROUPS_STAGE {
+		t.Fatalf("expected an actor group error pointing to a, got %v", err)
+	}
+}
+
+func TestConcurrentMessagePriority(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	c := g.Root.EnsureChild([]string{"c"})
+	c.Box = geo.NewBox(nil, 100, 100)
+	first := &d2graph.Edge{Src: a, Dst: b}
+	important := &d2graph.Edge{Src: a, Dst: c}
+	next := &d2graph.Edge{Src: b, Dst: c}
+	g.Edges = []*d2graph.Edge{first, important, next}
+
+	base := d2graph.NewGraph()
+	base.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	baseA := base.Root.EnsureChild([]string{"a"})
+	baseA.Box = geo.NewBox(nil, 100, 100)
+	baseB := base.Root.EnsureChild([]string{"b"})
+	baseB.Box = geo.NewBox(nil, 100, 100)
+	baseC := base.Root.EnsureChild([]string{"c"})
+	baseC.Box = geo.NewBox(nil, 100, 100)
+	base.Edges = []*d2graph.Edge{{Src: baseA, Dst: baseB}, {Src: baseB, Dst: baseC}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, base, nil); err != nil {
+		t.Fatal(err)
+	}
+	opts := &d2sequence.ConfigurableOpts{
+		Messages: map[string]d2sequence.MessageOpts{
+			first.AbsID():     {ConcurrencyGroup: "request", Priority: 0},
+			important.AbsID(): {ConcurrencyGroup: "request", Priority: 1},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if first.Route[0].Y != important.Route[0].Y {
+		t.Fatal("expected concurrent messages to be at the same height")
+	}
+	if next.Route[0].Y != base.Edges[1].Route[0].Y {
+		t.Fatalf("expected concurrent messages to take a single row, got next message at %.5f instead of %.5f", next.Route[0].Y, base.Edges[1].Route[0].Y)
+	}
+
+	opts.Messages[first.AbsID()] = d2sequence.MessageOpts{ConcurrencyGroup: "request", Priority: 2}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	drawIndex := func(e *d2graph.Edge) int {
+		for i, edge := range g.Edges {
+			if edge == e {
+				return i
+			}
+		}
+		return -1
+	}
+	if drawIndex(first) <= drawIndex(important) {
+		t.Fatal("expected the higher priority concurrent message to be drawn last")
+	}
+	if drawIndex(next) != 2 {
+		t.Fatal("expected messages outside of the concurrency group to keep their draw order")
+	}
+	if first.Route[0].Y != important.Route[0].Y || next.Route[0].Y != base.Edges[1].Route[0].Y {
+		t.Fatal("expected the draw order not to change the message heights")
+	}
+
+	// ties are drawn in declaration order, not in the order of the previous layout
+	opts.Messages[first.AbsID()] = d2sequence.MessageOpts{ConcurrencyGroup: "request", Priority: 1}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	if drawIndex(first) != 0 || drawIndex(important) != 1 {
+		t.Fatal("expected concurrent messages with the same priority to be drawn in declaration order")
+	}
+}
+
+func TestHeaderGap(t *testing.T) {
+	layout := func(headerGap *float64) (*d2graph.Object, *d2graph.Edge, *d2graph.Edge) {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: a}}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{HeaderGap: headerGap}); err != nil {
+			t.Fatal(err)
+		}
+		return a, g.Edges[0], g.Edges[1]
+	}
+
+	_, baseFirst, baseSecond := layout(nil)
+	for _, headerGap := range []float64{20, 200} {
+		a, first, second := layout(go2.Pointer(headerGap))
+		if first.Route[0].Y != a.TopLeft.Y+a.Height+headerGap {
+			t.Fatalf("expected first message at %.5f below the actor headers, got %.5f", headerGap, first.Route[0].Y-(a.TopLeft.Y+a.Height))
+		}
+		if second.Route[0].Y-first.Route[0].Y != baseSecond.Route[0].Y-baseFirst.Route[0].Y {
+			t.Fatal("expected the header gap not to change the distance between messages")
+		}
+	}
+
+	// the first message cannot go up into the headers
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	err = d2sequence.ValidateWithOpts(g, &d2sequence.ConfigurableOpts{HeaderGap: go2.Pointer(-10.)})
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Stage != d2sequence.VALIDATE_STAGE {
+		t.Fatalf("expected a negative header gap to be a validation error, got %v", err)
+	}
+	assert.Nil(t, d2sequence.ValidateWithOpts(g, &d2sequence.ConfigurableOpts{HeaderGap: go2.Pointer(0.)}))
+}
+
+func TestSpanLeadIn(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a
+b
+a -> b.t1
+b.t1 -> a
+`
+	layout := func(opts *d2sequence.ConfigurableOpts) (*d2graph.Object, *d2graph.Graph) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		b_t1, _ := g.Root.HasChild([]string{"b", "t1"})
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return b_t1, g
+	}
+
+	baseSpan, _ := layout(nil)
+	span, g := layout(&d2sequence.ConfigurableOpts{
+		Spans: map[string]d2sequence.SpanOpts{"b.t1": {LeadIn: 50}},
+	})
+	firstMessageY := g.Edges[0].Route[0].Y
+	if span.TopLeft.Y != firstMessageY-50 {
+		t.Fatalf("expected span to start 50 above its first message, got %.5f", firstMessageY-span.TopLeft.Y)
+	}
+	if span.TopLeft.Y+span.Height != baseSpan.TopLeft.Y+baseSpan.Height {
+		t.Fatal("expected the lead in not to change the span bottom")
+	}
+}
+
+func TestActorMinNextDistance(t *testing.T) {
+	layout := func(opts *d2sequence.ConfigurableOpts) []float64 {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		c := g.Root.EnsureChild([]string{"c"})
+		c.Box = geo.NewBox(nil, 100, 100)
+		d := g.Root.EnsureChild([]string{"d"})
+		d.Box = geo.NewBox(nil, 100, 100)
+		label := &d2graph.Edge{Src: c, Dst: d}
+		label.Label.Value = "long label"
+		label.LabelDimensions = d2target.TextDimensions{Width: 300, Height: 20}
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, label}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return []float64{b.Center().X - a.Center().X, c.Center().X - b.Center().X, d.Center().X - c.Center().X}
+	}
+
+	base := layout(nil)
+	gaps := layout(&d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"b": {MinNextDistance: 400},
+			// smaller than what the label needs
+			"c": {MinNextDistance: 200},
+		},
+	})
+	if gaps[0] != base[0] {
+		t.Fatalf("expected a-b gap to be unchanged, got %.5f instead of %.5f", gaps[0], base[0])
+	}
+	if gaps[1] != 400 {
+		t.Fatalf("expected b-c gap to be expanded to 400, got %.5f", gaps[1])
+	}
+	if gaps[2] != base[2] {
+		t.Fatalf("expected c-d gap to still fit the label, got %.5f instead of %.5f", gaps[2], base[2])
+	}
+}
+
+func TestCompactVertical(t *testing.T) {
+	layout := func(compact bool) *d2graph.Graph {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		// the tall label makes every row as tall
+		tall := &d2graph.Edge{Src: a, Dst: b}
+		tall.Label.Value = "tall\nlabel"
+		tall.LabelDimensions = d2target.TextDimensions{Width: 50, Height: 150}
+		g.Edges = []*d2graph.Edge{tall, {Src: b, Dst: a}, {Src: a, Dst: a}, {Src: a, Dst: b}}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{CompactVertical: compact}); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	base := layout(false)
+	g := layout(true)
+	if g.Root.Height >= base.Root.Height {
+		t.Fatalf("expected compacting to shrink the diagram, got height %.5f from %.5f", g.Root.Height, base.Root.Height)
+	}
+	if g.Edges[0].Route[0].Y != base.Edges[0].Route[0].Y {
+		t.Fatal("expected the first message not to move")
+	}
+	for i := 1; i < 4; i++ {
+		prev := g.Edges[i-1].Route[len(g.Edges[i-1].Route)-1].Y
+		curr := g.Edges[i].Route[0].Y
+		if curr <= prev {
+			t.Fatalf("expected message %d to stay below message %d", i, i-1)
+		}
+		if curr-g.Edges[i-1].Route[0].Y < d2sequence.MIN_MESSAGE_DISTANCE+d2sequence.VERTICAL_PAD {
+			t.Fatalf("expected message %d to keep the minimum distance from message %d", i, i-1)
+		}
+	}
+	// the tall label still needs its room
+	if g.Edges[1].Route[0].Y-g.Edges[0].Route[0].Y < 150/2.+d2sequence.MIN_MESSAGE_DISTANCE/2. {
+		t.Fatal("expected the message after the tall label not to overlap it")
+	}
+}
+
+func TestCompactVerticalKeepsGaps(t *testing.T) {
+	layout := func(opts *d2sequence.ConfigurableOpts) *d2graph.Graph {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		c := g.Root.EnsureChild([]string{"c"})
+		c.Box = geo.NewBox(nil, 100, 100)
+		tall := &d2graph.Edge{Src: a, Dst: b}
+		tall.Label.Value = "tall\nlabel"
+		tall.LabelDimensions = d2target.TextDimensions{Width: 50, Height: 150}
+		g.Edges = []*d2graph.Edge{tall, {Src: b, Dst: a}, {Src: a, Dst: b}, {Src: a, Dst: c}}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	hook := func(prev, message *d2graph.Edge) float64 {
+		if prev.Label.Value != "" {
+			return 500
+		}
+		return 0
+	}
+
+	// the gap the spacing hook asks for is not compacted away
+	base := layout(&d2sequence.ConfigurableOpts{SpacingHook: hook})
+	g := layout(&d2sequence.ConfigurableOpts{SpacingHook: hook, CompactVertical: true})
+	assert.Equal(t, base.Edges[1].Route[0].Y-base.Edges[0].Route[0].Y, g.Edges[1].Route[0].Y-g.Edges[0].Route[0].Y)
+	assert.True(t, g.Edges[2].Route[0].Y < base.Edges[2].Route[0].Y)
+
+	// the message creating an actor that enters later stays below its header
+	entry := &d2sequence.ConfigurableOpts{
+		CompactVertical: true,
+		Actors:          map[string]d2sequence.ActorOpts{"c": {EntryY: go2.Pointer(500.)}},
+	}
+	g = layout(entry)
+	c, _ := g.Root.HasChild([]string{"c"})
+	assert.True(t, g.Edges[3].Route[0].Y >= c.TopLeft.Y+c.Height)
+	assert.True(t, g.Edges[2].Route[0].Y < layout(&d2sequence.ConfigurableOpts{Actors: entry.Actors}).Edges[2].Route[0].Y)
+}
+
+func TestMessageStylePreserved(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b
+a -> b: dotted {
+  style.stroke-dash: 2
+}
+b -> a: bold {
+  style.stroke-width: 6
+  style.stroke: red
+}
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	dotted, bold := g.Edges[0], g.Edges[1]
+	// patterns the compiler doesn't know about are passed through to the renderer too
+	dotted.Style.StrokeDash.Value = "4 2 1 2"
+	dotted.Style.DoubleBorder = &d2graph.Scalar{Value: "true"}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	// inferred activations rewire the message endpoints and relayout goes over the same messages again
+	opts := &d2sequence.ConfigurableOpts{InferActivations: true}
+	for i := 0; i < 2; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	assert.Equal(t, "4 2 1 2", dotted.Style.StrokeDash.Value)
+	assert.Equal(t, "true", dotted.Style.DoubleBorder.Value)
+	assert.Equal(t, "6", bold.Style.StrokeWidth.Value)
+	assert.Equal(t, "red", bold.Style.Stroke.Value)
+	assert.Nil(t, dotted.Style.StrokeWidth)
+	assert.Nil(t, bold.Style.StrokeDash)
+}
+
+func TestSpanEndAnchors(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c
+a -> b.t1: open
+b.t1 -> c
+c -> b.t1
+b.t1 -> a: close
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	b_t1, _ := g.Root.HasChild([]string{"b", "t1"})
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{SpanEndAnchors: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	open, close := g.Edges[0], g.Edges[3]
+	if end := open.Route[len(open.Route)-1]; end.Y != b_t1.TopLeft.Y {
+		t.Fatalf("expected the opening message to end at the span top %.5f, got %.5f", b_t1.TopLeft.Y, end.Y)
+	}
+	if start := close.Route[0]; start.Y != b_t1.TopLeft.Y+b_t1.Height {
+		t.Fatalf("expected the closing message to start at the span bottom %.5f, got %.5f", b_t1.TopLeft.Y+b_t1.Height, start.Y)
+	}
+	if end := open.Route[len(open.Route)-1]; end.X != b_t1.TopLeft.X {
+		t.Fatal("expected the opening message to still connect to the span side")
+	}
+}
+
+func TestSpanBorderRadius(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b
+a -> b.t1
+b.t1 -> b.t1.t2
+b.t1.t2 -> a
+b.t1 -> a
+a -> b.t3
+b.t3.style.border-radius: 2
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	b_t1, _ := g.Root.HasChild([]string{"b", "t1"})
+	b_t1_t2, _ := g.Root.HasChild([]string{"b", "t1", "t2"})
+	b_t3, _ := g.Root.HasChild([]string{"b", "t3"})
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		Spans: map[string]d2sequence.SpanOpts{
+			"b.t1":    {BorderRadius: go2.Pointer(4)},
+			"b.t1.t2": {BorderRadius: go2.Pointer(6)},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if b_t1_t2.Width <= b_t1.Width {
+		t.Fatal("expected the nested span to be wider")
+	}
+	assert.Equal(t, "4", b_t1.Style.BorderRadius.Value)
+	assert.Equal(t, "6", b_t1_t2.Style.BorderRadius.Value)
+	// declared on the span itself
+	assert.Equal(t, "2", b_t3.Style.BorderRadius.Value)
+}
+
+func TestActorPlaceholder(t *testing.T) {
+	layout := func(opts *d2sequence.ConfigurableOpts) (*d2graph.Graph, float64) {
+		g := d2graph.NewGraph()
+		g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+		a := g.Root.EnsureChild([]string{"a"})
+		a.Box = geo.NewBox(nil, 100, 100)
+		b := g.Root.EnsureChild([]string{"b"})
+		b.Box = geo.NewBox(nil, 100, 100)
+		g.Edges = []*d2graph.Edge{{Src: a, Dst: b}}
+
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g, b.Center().X - a.Center().X
+	}
+
+	_, base := layout(nil)
+	g, gap := layout(&d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"a": {PlaceholdersAfter: 1},
+		},
+	})
+	if gap != base*2 {
+		t.Fatalf("expected a-b gap to leave room for a column, got %.5f instead of %.5f", gap, base*2)
+	}
+
+	if len(g.Objects) != 2 || len(g.Root.ChildrenArray) != 2 || len(g.Root.Children) != 2 {
+		t.Fatal("expected no header for the placeholder")
+	}
+	lifelines := 0
+	for _, e := range g.Edges {
+		if strings.Contains(e.Dst.ID, "-lifeline-end-") {
+			lifelines++
+		}
+	}
+	if lifelines != 2 {
+		t.Fatalf("expected a lifeline for a and b only, got %d", lifelines)
+	}
+}
+
+func TestSpanColorFromMessage(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c
+a -> b.t1: {style.stroke: red}
+b.t1 -> c.t2: {style.stroke: blue}
+c.t2 -> b.t1
+b.t1 -> a
+a -> c.t3
+c.t3.style.fill: green
+a -> c.t3: {style.stroke: red}
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	b_t1, _ := g.Root.HasChild([]string{"b", "t1"})
+	c_t2, _ := g.Root.HasChild([]string{"c", "t2"})
+	c_t3, _ := g.Root.HasChild([]string{"c", "t3"})
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{SpanColorFromMessage: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, g.Edges[0].Style.Stroke.Value, b_t1.Style.Fill.Value)
+	assert.Equal(t, g.Edges[1].Style.Stroke.Value, c_t2.Style.Fill.Value)
+	// declared on the span itself
+	assert.Equal(t, "green", c_t3.Style.Fill.Value)
+}
+
+func TestActorEntryY(t *testing.T) {
+	layout := func(input string) (*d2graph.Graph, error) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		opts := &d2sequence.ConfigurableOpts{
+			Actors: map[string]d2sequence.ActorOpts{
+				"c": {EntryY: go2.Pointer(200.)},
+			},
+		}
+		return g, d2sequence.LayoutWithOpts(ctx, g, nil, opts)
+	}
+
+	g, err := layout(`
+shape: sequence_diagram
+a; b; c
+a -> b
+b -> a
+a -> b
+a -> c
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.Root.HasChild([]string{"a"})
+	c, _ := g.Root.HasChild([]string{"c"})
+	// the other headers are placed VERTICAL_PAD from the top
+	if c.TopLeft.Y-a.TopLeft.Y != 200-d2sequence.VERTICAL_PAD {
+		t.Fatalf("expected c header at the entry y, got %.5f", c.TopLeft.Y-a.TopLeft.Y+d2sequence.VERTICAL_PAD)
+	}
+	lifelineTop, lifelineBottom := math.Inf(1), math.Inf(-1)
+	for _, e := range g.Edges {
+		if e.Src == c && d2sequence.IsLifelineEnd(e.Dst) {
+			lifelineTop, lifelineBottom = e.Route[0].Y, e.Route[1].Y
+		}
+	}
+	if lifelineTop != c.TopLeft.Y+c.Height {
+		t.Fatalf("expected c lifeline to start below its header, got %.5f instead of %.5f", lifelineTop, c.TopLeft.Y+c.Height)
+	}
+	if lifelineBottom <= g.Edges[3].Route[1].Y {
+		t.Fatal("expected c lifeline to go past its message")
+	}
+
+	_, err = layout(`
+shape: sequence_diagram
+a; b; c
+a -> c
+`)
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Stage != d2sequence.ROUTE_STAGE {
+		t.Fatalf("expected a route error for a message before c enters, got %v", err)
+	}
+}
+
+func TestReplyBeforeCall(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b
+a -> b.t
+b.t -> c
+c -> b.t
+b.t -> a
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	call, reply := g.Edges[0], g.Edges[3]
+	opts := func(callIndex, replyIndex int) *d2sequence.ConfigurableOpts {
+		return &d2sequence.ConfigurableOpts{
+			Messages: map[string]d2sequence.MessageOpts{
+				call.AbsID():       {SequenceIndex: go2.Pointer(callIndex)},
+				g.Edges[1].AbsID(): {SequenceIndex: go2.Pointer(2)},
+				g.Edges[2].AbsID(): {SequenceIndex: go2.Pointer(1)},
+				reply.AbsID():      {SequenceIndex: go2.Pointer(replyIndex)},
+			},
+		}
+	}
+
+	// c -> b.t before b.t -> c is not a reply to b.t's call
+	if err := d2sequence.ValidateWithOpts(g, opts(0, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	err = d2sequence.ValidateWithOpts(g, opts(3, 0))
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a LayoutError, got %v", err)
+	}
+	if layoutErr.Stage != d2sequence.VALIDATE_STAGE || layoutErr.Edge != reply {
+		t.Fatalf("expected a validation error pointing to the reply, got %s %v", layoutErr.Stage, layoutErr.Edge)
+	}
+	assert.Equal(t, "reply (b.t -> a)[0] comes before its call (a -> b.t)[0]", err.Error())
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts(3, 0)); !errors.As(err, &layoutErr) {
+		t.Fatalf("expected Layout to fail on the reversed pair, got %v", err)
+	}
+
+	// replies are also checked once InferActivations gave them spans
+	g, _, err = d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+b -> a
+`), nil)
+	assert.Nil(t, err)
+	call, reply = g.Edges[0], g.Edges[1]
+	inferred := &d2sequence.ConfigurableOpts{
+		InferActivations: true,
+		Messages: map[string]d2sequence.MessageOpts{
+			call.AbsID():  {SequenceIndex: go2.Pointer(1)},
+			reply.AbsID(): {SequenceIndex: go2.Pointer(0)},
+		},
+	}
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, inferred)
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected Layout to fail on the reversed inferred pair, got %v", err)
+	}
+	if layoutErr.Stage != d2sequence.VALIDATE_STAGE || layoutErr.Edge != reply {
+		t.Fatalf("expected a validation error pointing to the reply, got %s %v", layoutErr.Stage, layoutErr.Edge)
+	}
+}
+
+func TestSelfMessageOnLastActor(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	loop := &d2graph.Edge{Src: b, Dst: b}
+	loop.Label.Value = "retry"
+	loop.LabelDimensions = d2target.TextDimensions{Width: 60, Height: 20}
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, loop}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	loopRight := math.Inf(-1)
+	for _, p := range loop.Route {
+		loopRight = math.Max(loopRight, p.X)
+	}
+	if loopRight-b.Center().X != d2sequence.SELF_MESSAGE_HORIZONTAL_TRAVEL || loopRight <= b.TopLeft.X+b.Width {
+		t.Fatal("expected the loop to go past the last actor")
+	}
+	labelRight := loopRight + float64(loop.LabelDimensions.Width)/2.
+	if g.Root.TopLeft.X+g.Root.Width < labelRight+d2sequence.GROUP_CONTAINER_PADDING {
+		t.Fatalf("expected the diagram right bound %.5f to include the loop and its label %.5f", g.Root.TopLeft.X+g.Root.Width, labelRight)
+	}
+}
+
+func TestStrokeGradientPreserved(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	span := b.EnsureChild([]string{"t"})
+	span.Box = geo.NewBox(nil, 100, 100)
+	call := &d2graph.Edge{Src: a, Dst: span, StrokeGradient: &d2graph.Gradient{Start: "#ff0000", End: "#0000ff"}}
+	reply := &d2graph.Edge{Src: span, Dst: a}
+	g.Edges = []*d2graph.Edge{call, reply}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{MessageTypeStyles: true, LabelHalos: true}
+	for i := 0; i < 2; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, &d2graph.Gradient{Start: "#ff0000", End: "#0000ff"}, call.StrokeGradient)
+		assert.Nil(t, reply.StrokeGradient)
+	}
+
+	// and reaches the renderers
+	diagram, err := d2exporter.Export(ctx, g, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gradients := make(map[string]*d2target.Gradient)
+	for _, connection := range diagram.Connections {
+		gradients[connection.ID] = connection.StrokeGradient
+	}
+	assert.Equal(t, &d2target.Gradient{Start: "#ff0000", End: "#0000ff"}, gradients[call.AbsID()])
+	assert.Nil(t, gradients[reply.AbsID()])
+}
+
+func TestBroadcast(t *testing.T) {
+	layout := func(broadcast bool) (*d2graph.Graph, error) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d
+a -> b: ping
+a -> c
+a -> d
+d -> a
+`), nil)
+		assert.Nil(t, err)
+		opts := &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{}}
+		if broadcast {
+			for _, e := range g.Edges[:3] {
+				opts.Messages[e.AbsID()] = d2sequence.MessageOpts{Broadcast: "ping"}
+			}
+		}
+		ctx := log.WithTB(context.Background(), t, nil)
+		return g, d2sequence.LayoutWithOpts(ctx, g, nil, opts)
+	}
+
+	g, err := layout(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := g.Edges[0].Route[0]
+	var ends []float64
+	for _, e := range g.Edges[:3] {
+		assert.Equal(t, *start, *e.Route[0])
+		assert.Equal(t, start.Y, e.Route[len(e.Route)-1].Y)
+		ends = append(ends, e.Route[len(e.Route)-1].X)
+	}
+	if !(ends[0] < ends[1] && ends[1] < ends[2]) {
+		t.Fatal("expected a branch to each receiver")
+	}
+
+	base, err := layout(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rowGap := base.Edges[1].Route[0].Y - base.Edges[0].Route[0].Y
+	if g.Edges[3].Route[0].Y-start.Y != rowGap {
+		t.Fatal("expected the broadcast to take a single row")
+	}
+
+	g, _, err = d2compiler.Compile("", strings.NewReader("shape: sequence_diagram\na; b; c\na -> b\nc -> b\n"), nil)
+	assert.Nil(t, err)
+	err = d2sequence.ValidateWithOpts(g, &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{
+		g.Edges[0].AbsID(): {Broadcast: "x"},
+		g.Edges[1].AbsID(): {Broadcast: "x"},
+	}})
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Edge != g.Edges[1] {
+		t.Fatalf("expected an error for a broadcast with several senders, got %v", err)
+	}
+}
+
+func TestSkipLifelines(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b
+b -> c
+`), nil)
+	assert.Nil(t, err)
+	nEdges := len(g.Edges)
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{SkipLifelines: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Edges) != nEdges {
+		t.Fatalf("expected %d edges, got %d", nEdges, len(g.Edges))
+	}
+	for _, edge := range g.Edges {
+		if d2sequence.IsLifelineEnd(edge.Dst) {
+			t.Fatalf("expected no lifeline, got %s", edge.AbsID())
+		}
+	}
+	// the diagram still has room for lifelines below the last message
+	if g.Root.TopLeft.Y+g.Root.Height <= g.Edges[nEdges-1].Route[0].Y {
+		t.Fatal("expected the diagram to extend below the last message")
+	}
+	// the lifelines left to the renderer are still at the center of their actors
+	for _, actor := range g.Root.ChildrenArray {
+		x, ok := d2sequence.LifelineX(g, actor)
+		assert.True(t, ok)
+		assert.Equal(t, actor.Center().X, x)
+	}
+}
+
+func TestSelfMessageHeight(t *testing.T) {
+	layout := func(height float64) []*d2graph.Edge {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> a: think
+a -> b
+`), nil)
+		assert.Nil(t, err)
+		opts := &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{
+			g.Edges[0].AbsID(): {SelfMessageHeight: height},
+		}}
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g.Edges
+	}
+
+	edges := layout(0)
+	loop := edges[0].Route
+	assert.Equal(t, d2sequence.SELF_MESSAGE_HEIGHT, loop[2].Y-loop[1].Y)
+	assert.Equal(t, loop[0].Y, loop[1].Y)
+	assert.Equal(t, loop[2].Y, loop[3].Y)
+	defaultGap := edges[1].Route[0].Y - loop[3].Y
+
+	edges = layout(120)
+	loop = edges[0].Route
+	assert.Equal(t, 120., loop[2].Y-loop[1].Y)
+	// the next message keeps the same distance from the bottom of the taller loop
+	assert.Equal(t, defaultGap, edges[1].Route[0].Y-loop[3].Y)
+}
+
+func TestStateInvariant(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: first
+b.idle: "waiting for input"
+b -> a: second
+`), nil)
+	assert.Nil(t, err)
+	b, _ := g.Root.HasChild([]string{"b"})
+	idle, _ := b.HasChild([]string{"idle"})
+	idle.LabelDimensions = d2target.TextDimensions{Width: 120, Height: 20}
+	first, second := g.Edges[0], g.Edges[1]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Notes: map[string]d2sequence.NoteOpts{idle.AbsID(): {State: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, 120+d2sequence.STATE_PADDING*2, idle.Width)
+	assert.Equal(t, 20+d2sequence.STATE_PADDING*2, idle.Height)
+	lifelineX, _ := d2sequence.LifelineX(g, b)
+	assert.Equal(t, lifelineX, idle.Center().X)
+	// the state box is between the messages around it, which are pushed down by its height
+	if idle.TopLeft.Y <= first.Route[0].Y || idle.TopLeft.Y+idle.Height >= second.Route[0].Y {
+		t.Fatalf("expected the state at %v to %v to be between the messages at %v and %v",
+			idle.TopLeft.Y, idle.TopLeft.Y+idle.Height, first.Route[0].Y, second.Route[0].Y)
+	}
+	assert.NotNil(t, idle.Style.BorderRadius)
+}
+
+func TestTruncateLabels(t *testing.T) {
+	long := "a label that is much too long to fit between the actors"
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: "`+long+`"
+b -> a: short
+`), nil)
+	assert.Nil(t, err)
+	truncated, short := g.Edges[0], g.Edges[1]
+	truncated.LabelDimensions = d2target.TextDimensions{Width: 400, Height: 21}
+	short.LabelDimensions = d2target.TextDimensions{Width: 40, Height: 21}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{TruncateLabels: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasSuffix(truncated.Label.Value, d2sequence.ELLIPSIS) || len(truncated.Label.Value) >= len(long) {
+		t.Fatalf("expected the label to be truncated, got %#v", truncated.Label.Value)
+	}
+	assert.True(t, strings.HasPrefix(long, strings.TrimSuffix(truncated.Label.Value, d2sequence.ELLIPSIS)))
+	if truncated.LabelDimensions.Width > 120 {
+		t.Fatalf("expected the truncated label to be measured at most 120 wide, got %d", truncated.LabelDimensions.Width)
+	}
+	if truncated.Tooltip == nil || truncated.Tooltip.Value != long {
+		t.Fatal("expected the full label in the tooltip")
+	}
+	assert.Equal(t, "short", short.Label.Value)
+	assert.Nil(t, short.Tooltip)
+}
+
+func TestSeed(t *testing.T) {
+	type result struct {
+		order  []string
+		routes map[string]string
+	}
+	layout := func(seed *int64) result {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d; e
+a -> b
+a -> c
+a -> d
+a -> e
+`), nil)
+		assert.Nil(t, err)
+		opts := &d2sequence.ConfigurableOpts{Seed: seed, Messages: map[string]d2sequence.MessageOpts{}}
+		for i, e := range g.Edges {
+			// the last message is drawn on top of the others, the rest are ties
+			opts.Messages[e.AbsID()] = d2sequence.MessageOpts{ConcurrencyGroup: "fanout", Priority: i / 3}
+		}
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		r := result{routes: make(map[string]string)}
+		for _, e := range g.Edges[:4] {
+			r.order = append(r.order, e.AbsID())
+			r.routes[e.AbsID()] = fmt.Sprint(*e.Route[0], *e.Route[1])
+		}
+		return r
+	}
+
+	declared := layout(nil)
+	assert.Equal(t, []string{"(a -> b)[0]", "(a -> c)[0]", "(a -> d)[0]", "(a -> e)[0]"}, declared.order)
+	assert.Equal(t, layout(go2.Pointer(int64(7))), layout(go2.Pointer(int64(7))))
+
+	differs := false
+	for seed := int64(0); seed < 10; seed++ {
+		r := layout(&seed)
+		// only the drawing order of the ties changes
+		assert.Equal(t, declared.routes, r.routes)
+		assert.Equal(t, "(a -> e)[0]", r.order[3])
+		assert.ElementsMatch(t, declared.order[:3], r.order[:3])
+		if !reflect.DeepEqual(declared.order, r.order) {
+			differs = true
+		}
+	}
+	if !differs {
+		t.Fatal("expected some seed to break ties in another order")
+	}
+}
+
+func TestSeedRelayout(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d; e; f
+a -> b
+a -> c
+a -> d
+a -> e
+a -> f
+`), nil)
+	assert.Nil(t, err)
+	opts := &d2sequence.ConfigurableOpts{Seed: go2.Pointer(int64(3)), Messages: map[string]d2sequence.MessageOpts{}}
+	for _, e := range g.Edges {
+		opts.Messages[e.AbsID()] = d2sequence.MessageOpts{ConcurrencyGroup: "fanout"}
+	}
+	order := func() []string {
+		var ids []string
+		for _, e := range g.Edges {
+			if !d2sequence.IsLifelineEnd(e.Dst) {
+				ids = append(ids, e.AbsID())
+			}
+		}
+		return ids
+	}
+
+	// every layout shuffles the declared order with the seed, not the order of the previous layout
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	first := order()
+	for i := 0; i < 3; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, first, order())
+		if err := d2sequence.ReflowMessagesWithOpts(ctx, g, opts); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, first, order())
+	}
+}
+
+func TestMergeByTimestamp(t *testing.T) {
+	// two traces of the same interaction, merged into one diagram
+	client := `
+a -> b: request
+b -> a: response
+`
+	server := `
+b -> c: query
+c -> b: rows
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader("shape: sequence_diagram\na; b; c\n"+client+server), nil)
+	assert.Nil(t, err)
+	request, response, query, rows := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3]
+	timestamps := map[*d2graph.Edge]float64{request: 1, response: 4, query: 2, rows: 3}
+	opts := &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{}}
+	for e, ts := range timestamps {
+		opts.Messages[e.AbsID()] = d2sequence.MessageOpts{Timestamp: go2.Pointer(ts)}
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	order := []*d2graph.Edge{request, query, rows, response}
+	for i := 1; i < len(order); i++ {
+		if order[i].Route[0].Y <= order[i-1].Route[0].Y {
+			t.Fatalf("expected %s below %s", order[i].AbsID(), order[i-1].AbsID())
+		}
+	}
+	// the edges are still in declaration order
+	assert.Equal(t, []*d2graph.Edge{request, response, query, rows}, g.Edges[:4])
+}
+
+func TestLostReturnStubs(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b: notify
+a -> c: get
+c -> a: value
+`), nil)
+	assert.Nil(t, err)
+	b, _ := g.Root.HasChild([]string{"b"})
+	notify := g.Edges[0]
+
+	objects := len(g.Objects)
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	// laying out again infers the same spans and keeps the stub
+	for i := 0; i < 2; i++ {
+		err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{InferActivations: true, LostReturnStubs: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var stubs []*d2graph.Decoration
+		for _, d := range g.Decorations {
+			if d.Kind == d2sequence.LOST_RETURN_DECORATION {
+				stubs = append(stubs, d)
+			}
+		}
+		if len(stubs) != 1 {
+			t.Fatalf("expected a stub for the unanswered call only after %d layouts, got %d", i+1, len(stubs))
+		}
+		stub := stubs[0]
+		span := notify.Dst
+		assert.Equal(t, b, span.Parent)
+		assert.Equal(t, span, stub.Object)
+		assert.Equal(t, notify, stub.Edge)
+		assert.Equal(t, d2sequence.MIN_SPAN_HEIGHT, span.Height)
+		// from the bottom of the span towards the caller on the left
+		assert.Equal(t, span.TopLeft.Y+span.Height, stub.TopLeft.Y)
+		assert.Equal(t, span.TopLeft.X, stub.TopLeft.X+stub.Width)
+		assert.Equal(t, d2sequence.LOST_RETURN_STUB_LENGTH, stub.Width)
+		// the spans inferred by the first layout are replaced, not added to
+		assert.Equal(t, objects+2, len(g.Objects))
+	}
+}
+
+func TestMergeActivations(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b
+a -> b.t1: one
+b.t1 -> a
+a -> b.t2: two
+b.t2 -> a
+a -> b.t3: three
+b.t3 -> a
+`
+	layout := func(merge bool) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{MergeActivations: merge}); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	spans := func(g *d2graph.Graph) []*d2graph.Object {
+		b, _ := g.Root.HasChild([]string{"b"})
+		return b.ChildrenArray
+	}
+
+	separate := spans(layout(false))
+	top := separate[0].TopLeft.Y
+	last := separate[len(separate)-1]
+	bottom := last.TopLeft.Y + last.Height
+
+	g := layout(true)
+	merged := spans(g)
+	assert.Equal(t, 3, len(merged))
+	for _, span := range merged {
+		assert.Equal(t, *merged[0].Box.TopLeft, *span.TopLeft)
+		assert.Equal(t, merged[0].Width, span.Width)
+		assert.Equal(t, merged[0].Height, span.Height)
+	}
+	assert.Equal(t, top, merged[0].TopLeft.Y)
+	assert.Equal(t, bottom, merged[0].TopLeft.Y+merged[0].Height)
+
+	// messages connect to the side of the summary box
+	assert.Equal(t, merged[0].TopLeft.X, g.Edges[2].Route[len(g.Edges[2].Route)-1].X)
+}
+
+func TestSpacingHook(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: one
+b -> a: two
+a -> b: three
+`), nil)
+	assert.Nil(t, err)
+
+	var calls [][2]string
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		SpacingHook: func(prev, message *d2graph.Edge) float64 {
+			calls = append(calls, [2]string{prev.Label.Value, message.Label.Value})
+			if message.Label.Value == "three" {
+				// keeps the default spacing
+				return 0
+			}
+			return 200
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, [][2]string{{"one", "two"}, {"two", "three"}}, calls)
+	one, two, three := g.Edges[0], g.Edges[1], g.Edges[2]
+	assert.Equal(t, 200., two.Route[0].Y-one.Route[0].Y)
+	assert.True(t, three.Route[0].Y-two.Route[0].Y < 200)
+}
+
+func TestTimeScale(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: sent
+b -> a: ack
+a -> b: retry
+b -> a: ack
+`), nil)
+	assert.Nil(t, err)
+	timestamps := []float64{0, 2, 10, 10.1}
+	opts := &d2sequence.ConfigurableOpts{
+		TimeScale: 40,
+		Messages:  map[string]d2sequence.MessageOpts{},
+	}
+	for i, ts := range timestamps {
+		opts.Messages[g.Edges[i].AbsID()] = d2sequence.MessageOpts{Timestamp: go2.Pointer(ts)}
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	y := func(i int) float64 {
+		return g.Edges[i].Route[0].Y
+	}
+	assert.Equal(t, 80., y(1)-y(0))
+	assert.Equal(t, 320., y(2)-y(1))
+	// 4px apart in time, pushed to the minimum distance
+	assert.Equal(t, d2sequence.MIN_MESSAGE_DISTANCE, y(3)-y(2))
+}
+
+func TestDuplicateActors(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	duplicate := &d2graph.Object{
+		Graph:  g,
+		Parent: g.Root,
+		ID:     "a",
+		IDVal:  "a",
+		Box:    geo.NewBox(nil, 100, 100),
+	}
+	g.Root.ChildrenArray = append(g.Root.ChildrenArray, duplicate)
+	g.Objects = append(g.Objects, duplicate)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}}
+
+	var layoutErr *d2sequence.LayoutError
+	err := d2sequence.Validate(g)
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a LayoutError, got %v", err)
+	}
+	assert.Equal(t, duplicate, layoutErr.Object)
+	assert.Equal(t, d2sequence.VALIDATE_STAGE, layoutErr.Stage)
+	assert.Equal(t, "actor a is declared more than once", err.Error())
+
+	// Layout validates before placing anything
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.Layout(ctx, g, nil)
+	if !errors.As(err, &layoutErr) || layoutErr.Object != duplicate {
+		t.Fatalf("expected Layout to fail with an error pointing to the duplicate, got %v", err)
+	}
+	assert.Nil(t, a.TopLeft)
+}
+
+func TestActorBorder(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"a": {BorderRadius: go2.Pointer(1000), BorderWidth: go2.Pointer(6)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.Root.HasChild([]string{"a"})
+	b, _ := g.Root.HasChild([]string{"b"})
+
+	// the radius is clamped so the header is at most a pill with its bottom center on the border
+	assert.Equal(t, strconv.Itoa(int(math.Min(a.Width, a.Height)/2)), a.Style.BorderRadius.Value)
+	assert.Equal(t, "6", a.Style.StrokeWidth.Value)
+
+	var aLifeline, bLifeline *d2graph.Edge
+	for _, e := range g.Edges {
+		if d2sequence.IsLifelineEnd(e.Dst) {
+			if e.Src == a {
+				aLifeline = e
+			} else if e.Src == b {
+				bLifeline = e
+			}
+		}
+	}
+	assert.Equal(t, a.Center().X, aLifeline.Route[0].X)
+	assert.Equal(t, a.TopLeft.Y+a.Height+3, aLifeline.Route[0].Y)
+	assert.Equal(t, b.TopLeft.Y+b.Height, bLifeline.Route[0].Y)
+	assert.Nil(t, b.Style.BorderRadius)
+}
+
+func TestNestedInGraph(t *testing.T) {
+	ruler, err := textmeasure.NewRuler()
+	assert.Nil(t, err)
+	compile := func(input string) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		assert.Nil(t, g.SetDimensions(nil, ruler, nil))
+		return g
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+
+	g := compile(`
+before -> seq
+seq: {
+  shape: sequence_diagram
+  alice; bob
+  alice -> bob: hello
+  bob -> alice: hi
+}
+seq -> after
+`)
+	seq, _ := g.Root.HasChild([]string{"seq"})
+
+	// the same sequence diagram on its own, with the label of the container
+	standalone := compile(`
+shape: sequence_diagram
+label: seq
+alice; bob
+alice -> bob: hello
+bob -> alice: hi
+`)
+	standalone.Root.LabelDimensions = seq.LabelDimensions
+	if err := d2sequence.Layout(ctx, standalone, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = d2layouts.LayoutNested(ctx, g, d2layouts.NestedGraphInfo(g.Root), d2dagrelayout.DefaultLayout, d2layouts.DefaultRouter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seq, _ = g.Root.HasChild([]string{"seq"})
+	assert.Equal(t, standalone.Root.Width, seq.Width)
+	assert.Equal(t, standalone.Root.Height, seq.Height)
+
+	// the sequence diagram is laid out inside the container, which the parent layout places as a unit
+	for _, obj := range seq.ChildrenArray {
+		if !seq.Box.Contains(obj.TopLeft) {
+			t.Fatalf("expected %s inside %s", obj.AbsID(), seq.AbsID())
+		}
+	}
+	before, _ := g.Root.HasChild([]string{"before"})
+	after, _ := g.Root.HasChild([]string{"after"})
+	assert.True(t, before.TopLeft.Y+before.Height <= seq.TopLeft.Y)
+	assert.True(t, seq.TopLeft.Y+seq.Height <= after.TopLeft.Y)
+}
+
+func TestActorWidth(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b -> c
+`), nil)
+	assert.Nil(t, err)
+	for _, obj := range g.Root.ChildrenArray {
+		obj.Box = geo.NewBox(nil, 150, 60)
+		obj.LabelDimensions.Width = 130
+		obj.LabelDimensions.Height = 20
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"a": {Width: 300},
+			"b": {Width: 60},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.Root.HasChild([]string{"a"})
+	b, _ := g.Root.HasChild([]string{"b"})
+	c, _ := g.Root.HasChild([]string{"c"})
+	assert.Equal(t, 300., a.Width)
+	assert.Equal(t, 60., b.Width)
+	assert.Equal(t, 150., c.Width)
+
+	for _, actor := range []*d2graph.Object{a, b, c} {
+		x, ok := d2sequence.LifelineX(g, actor)
+		assert.True(t, ok)
+		assert.Equal(t, actor.Center().X, x)
+	}
+	// the headers do not overlap
+	assert.True(t, a.TopLeft.X+a.Width < b.TopLeft.X)
+	assert.True(t, b.TopLeft.X+b.Width < c.TopLeft.X)
+
+	// squares, circles, ovals and people keep their proportions at their width
+	g, _, err = d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a.shape: circle
+b
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	a, _ = g.Root.HasChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 50, 50)
+	b, _ = g.Root.HasChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 150, 60)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{"a": {Width: 150}, "b": {Width: 200}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 150., a.Width)
+	assert.InDelta(t, 150., a.Height, 1e-9)
+	assert.Equal(t, 200., b.Width)
+	assert.Equal(t, 60., b.Height)
+
+	// images keep their aspect ratio, narrower or wider
+	layoutImages := func(aBox, bBox *geo.Box) (*d2graph.Object, *d2graph.Object, error) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a: {shape: image; icon: https://icons.terrastruct.com/essentials/004-picture.svg}
+b: {shape: image; icon: https://icons.terrastruct.com/essentials/004-picture.svg}
+a -> b
+`), nil)
+		assert.Nil(t, err)
+		a, _ := g.Root.HasChild([]string{"a"})
+		a.Box = aBox
+		b, _ := g.Root.HasChild([]string{"b"})
+		b.Box = bBox
+		err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+			Actors: map[string]d2sequence.ActorOpts{"a": {Width: 100}, "b": {Width: 300}},
+		})
+		return a, b, err
+	}
+	a, b, err = layoutImages(geo.NewBox(nil, 200, 100), geo.NewBox(nil, 150, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 100., a.Width)
+	assert.Equal(t, 50., a.Height)
+	assert.Equal(t, 300., b.Width)
+	assert.Equal(t, 400., b.Height)
+
+	_, _, err = layoutImages(geo.NewBox(nil, 200, 0), geo.NewBox(nil, 150, 200))
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) || layoutErr.Stage != d2sequence.VALIDATE_STAGE {
+		t.Fatalf("expected an image without a height to be a validation error, got %v", err)
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: call
+b -> a: return
+group: {
+  a -> b
+}
+`), nil)
+	assert.Nil(t, err)
+	metadata := func(id string) map[string]string {
+		return map[string]string{"editor.id": id, "source.line": "3"}
+	}
+	objects := make(map[*d2graph.Object]string)
+	for _, obj := range g.Objects {
+		objects[obj] = obj.AbsID()
+		obj.Metadata = metadata(obj.AbsID())
+	}
+	// inferred activations change the IDs of the messages
+	edges := make(map[*d2graph.Edge]string)
+	for _, edge := range g.Edges {
+		edges[edge] = edge.AbsID()
+		edge.Metadata = metadata(edge.AbsID())
+	}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		InferActivations:  true,
+		MessageTypeStyles: true,
+		BackgroundBands:   true,
+		FrameTitle:        "sd",
+		Canvas:            &d2sequence.CanvasOpts{Width: 100, Height: 100, ScaleDown: true},
+	}
+	for i := 0; i < 2; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		for obj, id := range objects {
+			assert.Equal(t, metadata(id), obj.Metadata)
+		}
+		for edge, id := range edges {
+			assert.Equal(t, metadata(id), edge.Metadata)
+		}
+	}
+	// objects and edges added by the layout have none
+	for _, edge := range g.Edges[len(edges):] {
+		assert.Nil(t, edge.Metadata)
+	}
+}
+
+func TestSuspendedSpan(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.wait: lock
+b.wait -> a
+a -> b.work: run
+b.work -> a
+a -> b.dotted: poll {style.stroke: red}
+b.dotted.style.fill-pattern: dots
+`), nil)
+	assert.Nil(t, err)
+	wait, _ := g.Root.HasChild([]string{"b", "wait"})
+	work, _ := g.Root.HasChild([]string{"b", "work"})
+	dotted, _ := g.Root.HasChild([]string{"b", "dotted"})
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		Spans: map[string]d2sequence.SpanOpts{
+			"b.wait":   {Suspended: true},
+			"b.dotted": {Suspended: true},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, []string{d2sequence.SUSPENDED_CLASS}, wait.Classes)
+		assert.Equal(t, d2sequence.SUSPENDED_FILL_PATTERN, wait.Style.FillPattern.Value)
+		assert.Equal(t, []string{d2sequence.SUSPENDED_CLASS}, dotted.Classes)
+		assert.Equal(t, "dots", dotted.Style.FillPattern.Value)
+		assert.Empty(t, work.Classes)
+		assert.Nil(t, work.Style.FillPattern)
+	}
+}
+
+func TestExternalActor(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+user; api; bank
+bank.style.stroke-dash: 2
+user -> api -> bank
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"user": {External: true},
+			"bank": {External: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lifelines := make(map[string]*d2graph.Edge)
+	for _, e := range g.Edges {
+		if d2sequence.IsLifelineEnd(e.Dst) {
+			lifelines[e.Src.AbsID()] = e
+		}
+	}
+	assert.Equal(t, strconv.Itoa(d2sequence.EXTERNAL_LIFELINE_STROKE_DASH), lifelines["user"].Style.StrokeDash.Value)
+	assert.Equal(t, []string{d2sequence.EXTERNAL_CLASS}, lifelines["user"].Classes)
+	assert.Equal(t, strconv.Itoa(d2sequence.LIFELINE_STROKE_DASH), lifelines["api"].Style.StrokeDash.Value)
+	assert.Empty(t, lifelines["api"].Classes)
+	// a declared dash wins
+	assert.Equal(t, "2", lifelines["bank"].Style.StrokeDash.Value)
+	assert.Equal(t, []string{d2sequence.EXTERNAL_CLASS}, lifelines["bank"].Classes)
+}
+
+func TestReorderActors(t *testing.T) {
+	input := `
+shape: sequence_diagram
+client; db; cache; api
+client -> api: request
+api -> cache: get
+cache -> api
+api -> client: response
+client -> api: request
+api -> client: response
+`
+	totalLength := func(g *d2graph.Graph) float64 {
+		total := 0.
+		for _, e := range g.Edges {
+			if !d2sequence.IsLifelineEnd(e.Dst) {
+				total += math.Abs(e.Route[len(e.Route)-1].X - e.Route[0].X)
+			}
+		}
+		return total
+	}
+	layout := func(opts *d2sequence.ConfigurableOpts) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	ids := func(g *d2graph.Graph) []string {
+		var ids []string
+		for _, actor := range d2sequence.Actors(g) {
+			ids = append(ids, actor.AbsID())
+		}
+		return ids
+	}
+
+	declared := layout(nil)
+	reordered := layout(&d2sequence.ConfigurableOpts{ReorderActors: true})
+	assert.True(t, totalLength(reordered) < totalLength(declared))
+	assert.Equal(t, []string{"client", "api", "cache", "db"}, ids(reordered))
+
+	pinned := layout(&d2sequence.ConfigurableOpts{
+		ReorderActors: true,
+		Actors:        map[string]d2sequence.ActorOpts{"db": {Pinned: true}},
+	})
+	assert.Equal(t, "db", ids(pinned)[1])
+	assert.True(t, totalLength(pinned) < totalLength(declared))
+}
+
+func TestCurvature(t *testing.T) {
+	layout := func(curvature float64) []*d2graph.Edge {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+b -> a
+b -> b: think
+`), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{Curvature: curvature}); err != nil {
+			t.Fatal(err)
+		}
+		return g.Edges
+	}
+
+	straight := layout(0)
+	assert.Equal(t, 2, len(straight[0].Route))
+
+	curved := layout(0.1)
+	for i, message := range curved[:2] {
+		assert.Equal(t, 3, len(message.Route))
+		start, mid, end := message.Route[0], message.Route[1], message.Route[2]
+		// the ends stay where they were and the midpoint is raised off the line by a tenth of the length
+		assert.Equal(t, straight[i].Route[0], start)
+		assert.Equal(t, straight[i].Route[1], end)
+		assert.Equal(t, (start.X+end.X)/2, mid.X)
+		assert.InDelta(t, start.Y-math.Abs(end.X-start.X)*0.1, mid.Y, 1e-9)
+	}
+	// the self message keeps its loop
+	assert.Equal(t, len(straight[2].Route), len(curved[2].Route))
+
+	curved = layout(-0.1)
+	assert.True(t, curved[0].Route[1].Y > curved[0].Route[0].Y)
+
+	// the guards and icons next to the labels follow them onto the arcs
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: guarded
+b -> a: with an icon
+`), nil)
+	assert.Nil(t, err)
+	for _, message := range g.Edges {
+		message.LabelDimensions = d2target.TextDimensions{Width: 100, Height: 20}
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{Curvature: 0.2, Messages: map[string]d2sequence.MessageOpts{
+		g.Edges[0].AbsID(): {Guard: "x > 0"},
+		g.Edges[1].AbsID(): {LabelIcon: "https://icons.terrastruct.com/essentials/092-lock.svg"},
+	}}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 2, len(g.Decorations))
+	for _, d := range g.Decorations {
+		message := d.Edge
+		assert.Equal(t, 3, len(message.Route))
+		labelTL, _ := label.FromString(*message.LabelPosition).GetPointOnRoute(message.Route, 0, *message.LabelPercentage, 100, 20)
+		assert.InDelta(t, labelTL.Y+10, d.TopLeft.Y+d.Height/2, 1e-9, d.Kind)
+	}
+}
+
+func TestClampActivations(t *testing.T) {
+	var lifelineEnd float64
+	layout := func(opts *d2sequence.ConfigurableOpts) (*d2graph.Object, error) {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.t: call
+b.t -> a: return
+a -> b: later
+`), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		err = d2sequence.LayoutWithOpts(ctx, g, nil, opts)
+		b, _ := g.Root.HasChild([]string{"b"})
+		span, _ := b.HasChild([]string{"t"})
+		for _, edge := range g.Edges {
+			if edge.Src == b && d2sequence.IsLifelineEnd(edge.Dst) {
+				lifelineEnd = edge.Route[1].Y
+			}
+		}
+		return span, err
+	}
+
+	span, err := layout(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b's lifeline ends in the middle of its span
+	opts := &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{"b": {LifelineEndY: go2.Pointer(span.TopLeft.Y + span.Height/2)}},
+	}
+
+	span, err = layout(opts)
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a LayoutError, got %v", err)
+	}
+	assert.Equal(t, span, layoutErr.Object)
+	assert.Equal(t, d2sequence.ACTIVATIONS_STAGE, layoutErr.Stage)
+
+	opts.ClampActivations = true
+	span, err = layout(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, lifelineEnd, span.TopLeft.Y+span.Height)
+}
+
+func TestMessageTooltips(t *testing.T) {
+	layout := func(input string) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.Layout(ctx, g, nil); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	plain := layout(`
+shape: sequence_diagram
+a; b
+a -> b: call
+b -> a: return
+`)
+	hovered := layout(`
+shape: sequence_diagram
+a; b
+a -> b: call {
+  tooltip: retried up to 3 times
+}
+b -> a: return
+`)
+
+	call := hovered.Edges[0]
+	if call.Tooltip == nil || call.Tooltip.Value != "retried up to 3 times" {
+		t.Fatalf("expected the tooltip to be kept, got %v", call.Tooltip)
+	}
+	assert.Nil(t, hovered.Edges[1].Tooltip)
+	// unlike a note, the tooltip takes no space
+	for i, edge := range hovered.Edges {
+		assert.Equal(t, plain.Edges[i].Route, edge.Route)
+	}
+	assert.Equal(t, plain.Root.Height, hovered.Root.Height)
+}
+
+func TestDedupLifelines(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := len(g.Edges)
+	assert.Equal(t, 0, d2sequence.DedupLifelines(g))
+
+	// the lifelines are added again, as a layout that is not idempotent would
+	var lifelines []*d2graph.Edge
+	for _, edge := range g.Edges {
+		if d2sequence.IsLifelineEnd(edge.Dst) {
+			copied := *edge
+			copied.Route = []*geo.Point{edge.Route[0].Copy(), edge.Route[1].Copy()}
+			lifelines = append(lifelines, &copied)
+		}
+	}
+	assert.Equal(t, 2, len(lifelines))
+	g.Edges = append(g.Edges, lifelines...)
+	// a lifeline of the same actor elsewhere is not a duplicate
+	moved := *lifelines[0]
+	moved.Route = []*geo.Point{lifelines[0].Route[0].Copy(), lifelines[0].Route[1].Copy()}
+	moved.Route[1].Y += 100
+	g.Edges = append(g.Edges, &moved)
+
+	assert.Equal(t, 2, d2sequence.DedupLifelines(g))
+	assert.Equal(t, expected+1, len(g.Edges))
+	assert.Equal(t, &moved, g.Edges[len(g.Edges)-1])
+}
+
+func TestNestingDirection(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+c -> a.outer: call
+a.outer -> a.outer.inner: nested
+c -> b.outer: call
+b.outer -> b.outer.inner: nested
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Actors: map[string]d2sequence.ActorOpts{
+			"a": {NestingDirection: d2sequence.NestingRight},
+			"b": {NestingDirection: d2sequence.NestingLeft},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	offset := func(id string) float64 {
+		actor, _ := g.Root.HasChild([]string{id})
+		outer, _ := actor.HasChild([]string{"outer"})
+		inner, _ := outer.HasChild([]string{"inner"})
+		lifelineX, _ := d2sequence.LifelineX(g, actor)
+		assert.Equal(t, lifelineX, outer.Center().X)
+		assert.Equal(t, d2sequence.SPAN_BASE_WIDTH, inner.Width)
+		return inner.Center().X - outer.Center().X
+	}
+	assert.Equal(t, d2sequence.SPAN_NESTING_OFFSET, offset("a"))
+	assert.Equal(t, -d2sequence.SPAN_NESTING_OFFSET, offset("b"))
+
+	// the nested message ends on the side of the stepped span
+	a, _ := g.Root.HasChild([]string{"a"})
+	inner, _ := a.HasChild([]string{"outer", "inner"})
+	nested := g.Edges[1].Route
+	assert.Equal(t, inner.TopLeft.X+inner.Width, nested[len(nested)-1].X)
+}
+
+func TestSnapGrid(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b.t: call
+b.t -> c: forward
+c -> c: think
+b.t -> a: return
+b.note: odd sized note
+`), nil)
+	assert.Nil(t, err)
+	for _, obj := range g.Objects {
+		obj.LabelDimensions = d2target.TextDimensions{Width: 37, Height: 13}
+	}
+	for _, edge := range g.Edges {
+		edge.LabelDimensions = d2target.TextDimensions{Width: 41, Height: 13}
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{SnapGrid: 8, FrameTitle: "sd", BackgroundBands: true}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	onGrid := func(what string, v float64) {
+		if math.Mod(v, 8) != 0 {
+			t.Errorf("expected %s to be on the grid, got %v", what, v)
+		}
+	}
+	boxOnGrid := func(what string, b *geo.Box) {
+		onGrid(what+" x", b.TopLeft.X)
+		onGrid(what+" y", b.TopLeft.Y)
+		onGrid(what+" width", b.Width)
+		onGrid(what+" height", b.Height)
+	}
+	boxOnGrid("root", g.Root.Box)
+	for _, obj := range g.Objects {
+		boxOnGrid(obj.AbsID(), obj.Box)
+	}
+	for _, edge := range g.Edges {
+		for _, p := range edge.Route {
+			onGrid(edge.AbsID()+" route x", p.X)
+			onGrid(edge.AbsID()+" route y", p.Y)
+		}
+	}
+	for _, d := range g.Decorations {
+		boxOnGrid(d.Kind, d.Box)
+	}
+
+	// headers and spans stay centered on their lifeline, and messages keep ending on them
+	lifelineX := make(map[*d2graph.Object]float64)
+	for _, edge := range g.Edges {
+		if d2sequence.IsLifelineEnd(edge.Dst) {
+			lifelineX[edge.Src] = edge.Route[0].X
+			assert.Equal(t, edge.Src.Center().X, edge.Route[0].X, edge.AbsID())
+		}
+	}
+	b, _ := g.Root.HasChild([]string{"b"})
+	bT, _ := g.Root.HasChild([]string{"b", "t"})
+	assert.Equal(t, lifelineX[b], bT.Center().X)
+	call, forward := g.Edges[0], g.Edges[1]
+	assert.Equal(t, bT.TopLeft.X, call.Route[len(call.Route)-1].X)
+	assert.Equal(t, bT.TopLeft.X+bT.Width, forward.Route[0].X)
+}
+
+func TestLineCaps(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.t: call
+b.t -> a: return
+`), nil)
+	assert.Nil(t, err)
+	call, ret := g.Edges[0], g.Edges[1]
+	// keys of other tools are left alone, even when they look like the caps
+	call.Metadata = map[string]string{"line-cap.start": "mine"}
+	d2sequence.SetLineCaps(call, d2sequence.LineCapRound, d2sequence.LineCapSquare)
+	d2sequence.SetLineCaps(ret, d2sequence.LineCapButt, "")
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{MessageTypeStyles: true, Curvature: 0.1}
+	for i := 0; i < 2; i++ {
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		start, end := d2sequence.LineCaps(call)
+		assert.Equal(t, d2sequence.LineCapRound, start)
+		assert.Equal(t, d2sequence.LineCapSquare, end)
+		start, end = d2sequence.LineCaps(ret)
+		assert.Equal(t, d2sequence.LineCapButt, start)
+		assert.Equal(t, d2sequence.LineCap(""), end)
+	}
+
+	d2sequence.SetLineCaps(call, "", "")
+	assert.Equal(t, map[string]string{"line-cap.start": "mine"}, call.Metadata)
+	// the caps survive cloning the laid out graph, e.g. to paginate it
+	clone := g.Clone()
+	start, _ := d2sequence.LineCaps(clone.Edges[1])
+	assert.Equal(t, d2sequence.LineCapButt, start)
+}
+
+func TestActivationStyleLane(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.first: call
+b.first -> b.first.nested: nested
+b.first -> a: return
+a -> b: between
+a -> b.second: again
+b.second -> a: done
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{ActivationStyle: d2sequence.ActivationStyleLane}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := g.Root.HasChild([]string{"b"})
+	first, _ := b.HasChild([]string{"first"})
+	second, _ := b.HasChild([]string{"second"})
+	lanes := make(map[*d2graph.Object]*d2graph.Decoration)
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.LANE_DECORATION {
+			lanes[d.Object] = d
+		}
+	}
+	// one lane for each outermost span, the nested span is in the lane of its parent
+	assert.Equal(t, 2, len(lanes))
+	for _, span := range []*d2graph.Object{first, second} {
+		lane := lanes[span]
+		if lane == nil {
+			t.Fatalf("expected a lane for %s", span.AbsID())
+		}
+		assert.Equal(t, b.TopLeft.X, lane.TopLeft.X)
+		assert.Equal(t, b.Width, lane.Width)
+		assert.Equal(t, span.TopLeft.Y, lane.TopLeft.Y)
+		assert.Equal(t, span.Height, lane.Height)
+		// the span is not drawn
+		assert.Equal(t, 0., span.Width)
+		assert.Equal(t, "0", span.Style.Opacity.Value)
+	}
+
+	// the messages connect to the lifeline
+	lifelineX, _ := d2sequence.LifelineX(g, b)
+	assert.Equal(t, lifelineX, g.Edges[0].Route[1].X)
+	between := g.Edges[3].Route[0].Y
+	for _, lane := range lanes {
+		if lane.TopLeft.Y <= between && between <= lane.TopLeft.Y+lane.Height {
+			t.Fatal("expected no lane between the active periods")
+		}
+	}
+}
+
+func TestReturnValue(t *testing.T) {
+	layout := func(returnValue string) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.t: get
+b.t -> a: reply
+a -> b: forward
+`), nil)
+		assert.Nil(t, err)
+		for _, edge := range g.Edges {
+			edge.LabelDimensions = d2target.TextDimensions{Width: 160, Height: 20}
+		}
+		ctx := log.WithTB(context.Background(), t, nil)
+		opts := &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{
+			g.Edges[1].AbsID(): {ReturnValue: returnValue},
+			g.Edges[2].AbsID(): {ReturnValue: returnValue},
+		}}
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+
+	plain := layout("")
+	g := layout("200 OK")
+	annotations := make(map[*d2graph.Edge]*d2graph.Decoration)
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.RETURN_VALUE_DECORATION {
+			annotations[d.Edge] = d
+		}
+	}
+	assert.Equal(t, 2, len(annotations))
+
+	reply := g.Edges[1]
+	annotation := annotations[reply]
+	assert.Equal(t, "200 OK", annotation.Label)
+	assert.NotNil(t, annotation.Style.StrokeDash)
+	end := reply.Route[len(reply.Route)-1]
+	// just above the arrowhead, on the side of the sender, off the receiver lifeline
+	assert.Equal(t, end.X+d2sequence.RETURN_VALUE_GAP, annotation.TopLeft.X)
+	assert.Equal(t, end.Y-d2sequence.RETURN_VALUE_GAP, annotation.TopLeft.Y+annotation.Height)
+	a, _ := g.Root.HasChild([]string{"a"})
+	aX, _ := d2sequence.LifelineX(g, a)
+	if annotation.TopLeft.X <= aX {
+		t.Fatal("expected the return value off the receiver lifeline")
+	}
+
+	// a message to the right has it on the left of its arrowhead
+	forward := g.Edges[2]
+	end = forward.Route[1]
+	annotation = annotations[forward]
+	assert.Equal(t, end.X-d2sequence.RETURN_VALUE_GAP, annotation.TopLeft.X+annotation.Width)
+
+	// the actors make room for it
+	if g.Edges[2].Route[1].X-g.Edges[2].Route[0].X <= plain.Edges[2].Route[1].X-plain.Edges[2].Route[0].X {
+		t.Fatal("expected the actors further apart")
+	}
+	if err := d2sequence.CheckBounds(g); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUniformActorWidth(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d
+a -> b: short
+b -> c: a much longer message label
+c -> d: hi
+`), nil)
+	assert.Nil(t, err)
+	widths := map[string]float64{"a": 40, "b": 220, "c": 90, "d": 10}
+	for _, obj := range g.Objects {
+		obj.LabelDimensions = d2target.TextDimensions{Width: int(widths[obj.ID]), Height: 13}
+		obj.Box = geo.NewBox(nil, widths[obj.ID], 30)
+	}
+	for _, edge := range g.Edges {
+		edge.LabelDimensions = d2target.TextDimensions{Width: 41, Height: 13}
+	}
+	g.Edges[1].LabelDimensions.Width = 300
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{UniformActorWidth: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	actors := g.Root.ChildrenArray
+	for _, actor := range actors[1:] {
+		if actor.Width != actors[0].Width {
+			t.Errorf("expected %s to be %v wide like %s, got %v", actor.ID, actors[0].Width, actors[0].ID, actor.Width)
+		}
+	}
+	if actors[0].Width < 220 {
+		t.Errorf("expected actors to be as wide as the widest one, got %v", actors[0].Width)
+	}
+	step := actors[1].Center().X - actors[0].Center().X
+	for i := 2; i < len(actors); i++ {
+		if d := actors[i].Center().X - actors[i-1].Center().X; math.Abs(d-step) > 1e-6 {
+			t.Errorf("expected actors to be evenly spaced by %v, got %v between %s and %s", step, d, actors[i-1].ID, actors[i].ID)
+		}
+	}
+	for _, edge := range g.Edges {
+		if !d2sequence.IsLifelineEnd(edge.Dst) {
+			continue
+		}
+		if edge.Route[0].X != edge.Src.Center().X {
+			t.Errorf("expected lifeline of %s to be centered, got x %v for center %v", edge.Src.ID, edge.Route[0].X, edge.Src.Center().X)
+		}
+	}
+
+	// squares, circles, ovals and people keep their proportions at the uniform width
+	g, _, err = d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b.shape: square
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	a, _ := g.Root.HasChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 220, 30)
+	b, _ := g.Root.HasChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 50, 50)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{UniformActorWidth: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 220., b.Width)
+	assert.InDelta(t, 220., b.Height, 1e-9)
+	assert.Equal(t, 30., a.Height)
+}
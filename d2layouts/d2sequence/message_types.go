@@ -0,0 +1,156 @@
+package d2sequence
+
+import (
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2target"
+)
+
+type MessageType string
+
+const (
+	// MessageSync is a call that waits for its reply, drawn solid with a filled arrowhead
+	MessageSync MessageType = "sync"
+	// MessageAsync is a call that does not wait, drawn solid with an open arrowhead
+	MessageAsync MessageType = "async"
+	// MessageReply returns from a call, drawn dashed
+	MessageReply MessageType = "reply"
+	// MessageCreate creates its receiver, drawn dashed with an open arrowhead
+	MessageCreate MessageType = "create"
+)
+
+// MarkerSet is the arrowhead drawn at the end of each message type, see ConfigurableOpts.MarkerSet
+type MarkerSet struct {
+	Sync   d2target.Arrowhead
+	Async  d2target.Arrowhead
+	Reply  d2target.Arrowhead
+	Create d2target.Arrowhead
+}
+
+// DefaultMarkerSet tells calls that wait from those that don't by a filled or open triangle
+var DefaultMarkerSet = MarkerSet{
+	Sync:   d2target.TriangleArrowhead,
+	Async:  d2target.UnfilledTriangleArrowhead,
+	Reply:  d2target.TriangleArrowhead,
+	Create: d2target.UnfilledTriangleArrowhead,
+}
+
+// HighContrastMarkerSet gives each message type a different shape, for themes where fill and dashes are hard to tell apart
+var HighContrastMarkerSet = MarkerSet{
+	Sync:   d2target.TriangleArrowhead,
+	Async:  d2target.ArrowArrowhead,
+	Reply:  d2target.FilledDiamondArrowhead,
+	Create: d2target.CircleArrowhead,
+}
+
+func (ms MarkerSet) marker(t MessageType) d2target.Arrowhead {
+	switch t {
+	case MessageAsync:
+		return ms.Async
+	case MessageReply:
+		return ms.Reply
+	case MessageCreate:
+		return ms.Create
+	default:
+		return ms.Sync
+	}
+}
+
+// arrowheadAttributes is the shape and fill of an arrowhead, the inverse of d2target.ToArrowhead
+func arrowheadAttributes(arrowhead d2target.Arrowhead) (shape string, filled bool) {
+	switch arrowhead {
+	case d2target.TriangleArrowhead:
+		return string(d2target.TriangleArrowhead), true
+	case d2target.UnfilledTriangleArrowhead:
+		return string(d2target.TriangleArrowhead), false
+	case d2target.FilledDiamondArrowhead:
+		return string(d2target.DiamondArrowhead), true
+	case d2target.FilledCircleArrowhead:
+		return string(d2target.CircleArrowhead), true
+	default:
+		return string(arrowhead), false
+	}
+}
+
+// messageType is the type of the message in MessageOpts or inferred when not set:
+// replies go from a span back to the sender of the call that opened it,
+// creates are the first messages to actors with an ActorOpts.EntryY and the others are sync
+func messageType(message *d2graph.Edge, opts *ConfigurableOpts, calls map[*d2graph.Object]*d2graph.Edge, created map[*d2graph.Edge]bool) MessageType {
+	if t := opts.messageOpts(message).Type; t != "" {
+		return t
+	}
+	if _, isReply := replyTo(calls, message); isReply {
+		return MessageReply
+	}
+	if created[message] {
+		return MessageCreate
+	}
+	return MessageSync
+}
+
+// applyMessageTypeStyles sets the default style of each message type on the messages that do not declare it.
+// The styles set by a previous layout are undone before, so they don't count as declared, see saveLayoutInputs
+func applyMessageTypeStyles(root *d2graph.Object, messages []*d2graph.Edge, opts *ConfigurableOpts) {
+	calls := openingCalls(root, messages)
+	created := make(map[*d2graph.Edge]bool)
+	seen := make(map[*d2graph.Object]bool)
+	for _, message := range messages {
+		dst := message.Dst
+		for dst.Parent != root {
+			dst = dst.Parent
+		}
+		if !seen[dst] && opts.Actors[dst.AbsID()].EntryY != nil {
+			created[message] = true
+		}
+		seen[dst] = true
+	}
+
+	markers := DefaultMarkerSet
+	if opts.MarkerSet != nil {
+		markers = *opts.MarkerSet
+	}
+	for _, message := range messages {
+		t := messageType(message, opts, calls, created)
+		dashed := t == MessageReply || t == MessageCreate
+
+		if dashed && message.Style.StrokeDash == nil {
+			message.Style.StrokeDash = &d2graph.Scalar{Value: strconv.Itoa(MESSAGE_STROKE_DASH)}
+		}
+		if !message.DstArrow {
+			continue
+		}
+		if message.DstArrowhead == nil {
+			message.DstArrowhead = &d2graph.Attributes{}
+		}
+		if message.DstArrowhead.Shape.Value == "" && message.DstArrowhead.Style.Filled == nil {
+			shape, filled := arrowheadAttributes(markers.marker(t))
+			message.DstArrowhead.Shape = d2graph.Scalar{Value: shape}
+			message.DstArrowhead.Style.Filled = &d2graph.Scalar{Value: strconv.FormatBool(filled)}
+		}
+	}
+}
+
+// prefixReplyLabels starts the labels of replies with REPLY_LABEL_PREFIX, like UML return values,
+// and measures them again
+func (sd *sequenceDiagram) prefixReplyLabels() error {
+	calls := openingCalls(sd.root, sd.messages)
+	for _, message := range sd.messages {
+		if message.Label.Value == "" || strings.HasPrefix(message.Label.Value, strings.TrimSpace(REPLY_LABEL_PREFIX)) {
+			continue
+		}
+		if messageType(message, sd.opts, calls, nil) != MessageReply {
+			continue
+		}
+		mtext := message.Text()
+		mtext.Text = REPLY_LABEL_PREFIX + message.Label.Value
+		dims, err := sd.measureText(mtext)
+		if err != nil {
+			return err
+		}
+		message.Label.Value = mtext.Text
+		message.LabelDimensions = *dims
+	}
+	return nil
+}
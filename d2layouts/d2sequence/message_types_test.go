@@ -0,0 +1,220 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestMessageTypeStyles(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c; d
+a -> b.t: sync
+a -> c: async
+b.t -> a: reply
+a -> d: create
+a -> c: explicit {
+  style.stroke-dash: 5
+  target-arrowhead.shape: diamond
+}
+c.t -> a: explicit reply {style.stroke-dash: 1}
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	sync, async, reply, create, explicit, explicitReply := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3], g.Edges[4], g.Edges[5]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		MessageTypeStyles: true,
+		Actors: map[string]d2sequence.ActorOpts{
+			"d": {EntryY: go2.Pointer(250.)},
+		},
+		Messages: map[string]d2sequence.MessageOpts{
+			async.AbsID():         {Type: d2sequence.MessageAsync},
+			explicitReply.AbsID(): {Type: d2sequence.MessageReply},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, sync.Style.StrokeDash)
+	assert.Equal(t, d2target.TriangleArrowhead, sync.DstArrowhead.ToArrowhead())
+
+	assert.Nil(t, async.Style.StrokeDash)
+	assert.Equal(t, d2target.UnfilledTriangleArrowhead, async.DstArrowhead.ToArrowhead())
+
+	assert.Equal(t, "3", reply.Style.StrokeDash.Value)
+	assert.Equal(t, d2target.TriangleArrowhead, reply.DstArrowhead.ToArrowhead())
+
+	assert.Equal(t, "3", create.Style.StrokeDash.Value)
+	assert.Equal(t, d2target.UnfilledTriangleArrowhead, create.DstArrowhead.ToArrowhead())
+
+	// declared on the messages
+	assert.Equal(t, "5", explicit.Style.StrokeDash.Value)
+	assert.Equal(t, d2target.DiamondArrowhead, explicit.DstArrowhead.ToArrowhead())
+	assert.Equal(t, "1", explicitReply.Style.StrokeDash.Value)
+
+	// the styles set by a layout are not taken for declared ones by the next
+	opts.MessageTypeStyles = false
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, reply.Style.StrokeDash)
+	assert.Nil(t, create.Style.StrokeDash)
+	assert.True(t, sync.DstArrowhead == nil || sync.DstArrowhead.Shape.Value == "")
+	assert.True(t, async.DstArrowhead == nil || async.DstArrowhead.Shape.Value == "")
+	assert.Equal(t, "5", explicit.Style.StrokeDash.Value)
+	assert.Equal(t, d2target.DiamondArrowhead, explicit.DstArrowhead.ToArrowhead())
+
+	opts.MessageTypeStyles = true
+	opts.Messages[reply.AbsID()] = d2sequence.MessageOpts{Type: d2sequence.MessageAsync}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, reply.Style.StrokeDash)
+	assert.Equal(t, d2target.UnfilledTriangleArrowhead, reply.DstArrowhead.ToArrowhead())
+	assert.Equal(t, "3", create.Style.StrokeDash.Value)
+}
+
+func TestMarkerSet(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c; d
+a -> b.t: sync
+a -> c: async
+b.t -> a: reply
+a -> d: create
+a -> c: explicit {target-arrowhead.shape: cf-one}
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	sync, async, reply, create, explicit := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3], g.Edges[4]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		MessageTypeStyles: true,
+		Actors: map[string]d2sequence.ActorOpts{
+			"d": {EntryY: go2.Pointer(250.)},
+		},
+		Messages: map[string]d2sequence.MessageOpts{
+			async.AbsID(): {Type: d2sequence.MessageAsync},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, d2sequence.DefaultMarkerSet.Reply, reply.DstArrowhead.ToArrowhead())
+
+	// switching marker sets on a laid out graph replaces the arrowheads of the previous layout
+	opts.MarkerSet = &d2sequence.HighContrastMarkerSet
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, d2sequence.HighContrastMarkerSet.Sync, sync.DstArrowhead.ToArrowhead())
+	assert.Equal(t, d2sequence.HighContrastMarkerSet.Async, async.DstArrowhead.ToArrowhead())
+	assert.Equal(t, d2sequence.HighContrastMarkerSet.Reply, reply.DstArrowhead.ToArrowhead())
+	assert.Equal(t, d2sequence.HighContrastMarkerSet.Create, create.DstArrowhead.ToArrowhead())
+	// declared arrowheads are kept
+	assert.Equal(t, d2target.CfOne, explicit.DstArrowhead.ToArrowhead())
+}
+
+func TestMessageStatus(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: ok
+a -> b: slow
+a -> b: failed
+a -> b: failed again {style.stroke: black}
+a -> b: plain
+`), nil)
+	assert.Nil(t, err)
+	ok, slow, failed, explicit, plain := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3], g.Edges[4]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	opts := &d2sequence.ConfigurableOpts{
+		StatusColors: map[d2sequence.MessageStatus]string{d2sequence.MessageWarning: "gold"},
+		Messages: map[string]d2sequence.MessageOpts{
+			ok.AbsID():       {Status: d2sequence.MessageSuccess},
+			slow.AbsID():     {Status: d2sequence.MessageWarning},
+			failed.AbsID():   {Status: d2sequence.MessageError},
+			explicit.AbsID(): {Status: d2sequence.MessageError},
+		},
+	}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, d2sequence.DefaultStatusColors[d2sequence.MessageSuccess], ok.Style.Stroke.Value)
+	assert.Equal(t, "gold", slow.Style.Stroke.Value)
+	assert.Equal(t, d2sequence.DefaultStatusColors[d2sequence.MessageError], failed.Style.Stroke.Value)
+	assert.Equal(t, "black", explicit.Style.Stroke.Value)
+	assert.Nil(t, plain.Style.Stroke)
+
+	assert.Equal(t, []string{"success"}, ok.Classes)
+	assert.Equal(t, []string{"error"}, explicit.Classes)
+	assert.Empty(t, plain.Classes)
+
+	// changing or clearing statuses on a laid out graph leaves nothing of the previous ones
+	opts.Messages[ok.AbsID()] = d2sequence.MessageOpts{Status: d2sequence.MessageError}
+	delete(opts.Messages, slow.AbsID())
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, d2sequence.DefaultStatusColors[d2sequence.MessageError], ok.Style.Stroke.Value)
+	assert.Equal(t, []string{"error"}, ok.Classes)
+	assert.Nil(t, slow.Style.Stroke)
+	assert.Empty(t, slow.Classes)
+	assert.Equal(t, "black", explicit.Style.Stroke.Value)
+	assert.Equal(t, []string{"error"}, explicit.Classes)
+}
+
+func TestReplyLabelPrefix(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b.t: get
+b.t -> a: value
+a -> c.t: check
+c.t -> a: ": ok"
+a -> b: notify
+b -> a: ack
+`), nil)
+	assert.Nil(t, err)
+	for _, e := range g.Edges {
+		e.LabelDimensions.Width = 40
+		e.LabelDimensions.Height = 20
+	}
+	get, value, prefixed, notify, ack := g.Edges[0], g.Edges[1], g.Edges[3], g.Edges[4], g.Edges[5]
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		ReplyLabelPrefix: true,
+		Messages: map[string]d2sequence.MessageOpts{
+			ack.AbsID(): {Type: d2sequence.MessageReply},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ": value", value.Label.Value)
+	// measured again with the prefix
+	assert.NotEqual(t, 40, value.LabelDimensions.Width)
+	assert.Equal(t, ": ok", prefixed.Label.Value)
+	assert.Equal(t, ": ack", ack.Label.Value)
+	assert.Equal(t, "get", get.Label.Value)
+	assert.Equal(t, "notify", notify.Label.Value)
+}
@@ -0,0 +1,195 @@
+package d2sequence
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// PaginateByCount splits a laid out sequence diagram into pages of at most k messages from top to bottom, e.g. for
+// printing. Each page is a copy of the diagram with all the actor headers and the messages of the page moved up
+// below them. Spans and groups that continue from the previous page are reopened at the top of the page
+func PaginateByCount(g *d2graph.Graph, k int) []*d2graph.Graph {
+	if k <= 0 {
+		return nil
+	}
+	var messages []*d2graph.Edge
+	for _, edge := range g.Edges {
+		if !IsLifelineEnd(edge.Dst) && len(edge.Route) > 0 {
+			messages = append(messages, edge)
+		}
+	}
+	if len(messages) <= k {
+		return []*d2graph.Graph{g.Clone()}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return routeTop(messages[i].Route) < routeTop(messages[j].Route)
+	})
+
+	// everything above the first message is the header repeated on every page
+	headerBottom := routeTop(messages[0].Route)
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			headerBottom = math.Min(headerBottom, edge.Route[0].Y)
+		}
+	}
+	// pages are cut halfway between their last message and the first message of the next page
+	cuts := []float64{headerBottom}
+	for i := k; i < len(messages); i += k {
+		cuts = append(cuts, (routeBottom(messages[i-1].Route)+routeTop(messages[i].Route))/2)
+	}
+	cuts = append(cuts, math.Inf(1))
+
+	var pages []*d2graph.Graph
+	for p := 0; p < len(cuts)-1; p++ {
+		onPage := make(map[*d2graph.Edge]bool)
+		end := go2.IntMin((p+1)*k, len(messages))
+		for _, message := range messages[p*k : end] {
+			onPage[message] = true
+		}
+		pages = append(pages, paginate(g, onPage, headerBottom, cuts[p], cuts[p+1]))
+	}
+	return pages
+}
+
+// paginate copies the diagram with the messages on the page, the elements between startY and endY
+// moved up to headerBottom, and the header
+func paginate(g *d2graph.Graph, onPage map[*d2graph.Edge]bool, headerBottom, startY, endY float64) *d2graph.Graph {
+	page := g.Clone()
+	dy := headerBottom - startY
+	inPage := func(y float64) bool {
+		return startY <= y && y < endY
+	}
+
+	// content bottom of the whole diagram and of the page, for the lifelines and the root to end
+	// as far below the page content as below the diagram content
+	diagramEnd := contentBottom(g)
+	pageEnd := math.Min(diagramEnd, endY) + dy
+
+	// notes are told from spans by their messages, before the messages of other pages are removed
+	removed := make(map[*d2graph.Object]bool)
+	for _, obj := range page.Objects {
+		if obj.Parent == page.Root && !obj.IsSequenceDiagramGroup() {
+			// actors are the header
+			continue
+		}
+		if obj.IsSequenceDiagramNote() {
+			if inPage(obj.TopLeft.Y) {
+				obj.TopLeft.Y += dy
+			} else {
+				removed[obj] = true
+			}
+			continue
+		}
+		// spans and groups are cut to the page
+		objTop := math.Max(obj.TopLeft.Y, startY)
+		objBottom := math.Min(obj.TopLeft.Y+obj.Height, endY)
+		if objBottom <= objTop {
+			removed[obj] = true
+			continue
+		}
+		obj.TopLeft.Y = objTop + dy
+		obj.Height = objBottom - objTop
+	}
+	// spans and groups in removed ones are out of the page too
+	for _, obj := range page.Objects {
+		for parent := obj.Parent; parent != nil; parent = parent.Parent {
+			if removed[parent] {
+				removed[obj] = true
+				break
+			}
+		}
+	}
+	var objects []*d2graph.Object
+	for _, obj := range page.Objects {
+		if !removed[obj] {
+			objects = append(objects, obj)
+		}
+	}
+	page.Objects = objects
+
+	var edges []*d2graph.Edge
+	for i, edge := range page.Edges {
+		original := g.Edges[i]
+		if IsLifelineEnd(edge.Dst) {
+			end := edge.Route[len(edge.Route)-1]
+			end.Y = math.Max(edge.Route[0].Y, math.Min(end.Y, endY)+dy)
+			edges = append(edges, edge)
+		} else if onPage[original] {
+			edge.Move(0, dy)
+			edges = append(edges, edge)
+		}
+	}
+	page.Edges = edges
+
+	for _, obj := range append([]*d2graph.Object{page.Root}, page.Objects...) {
+		var children []*d2graph.Object
+		for _, child := range obj.ChildrenArray {
+			if removed[child] {
+				delete(obj.Children, strings.ToLower(child.ID))
+				continue
+			}
+			children = append(children, child)
+		}
+		obj.ChildrenArray = children
+	}
+
+	var decorations []*d2graph.Decoration
+	for _, d := range page.Decorations {
+		if d.Object != nil && removed[d.Object] {
+			continue
+		}
+		switch {
+		case d.TopLeft.Y < headerBottom:
+			// header decorations, like the frame, end with the page
+			if d.TopLeft.Y+d.Height > headerBottom {
+				d.Height = math.Max(headerBottom-d.TopLeft.Y, d.Height-(diagramEnd-pageEnd))
+			}
+		case inPage(d.TopLeft.Y):
+			d.Move(0, dy)
+		default:
+			continue
+		}
+		decorations = append(decorations, d)
+	}
+	page.Decorations = decorations
+
+	page.Root.Height -= diagramEnd - pageEnd
+	return page
+}
+
+// contentBottom is the bottom of the objects and edges of a laid out diagram, the end of its lifelines unless they
+// are left out with SkipLifelines
+func contentBottom(g *d2graph.Graph) float64 {
+	bottom := g.Root.TopLeft.Y
+	for _, obj := range g.Objects {
+		if obj.Box != nil && obj.TopLeft != nil {
+			bottom = math.Max(bottom, obj.TopLeft.Y+obj.Height)
+		}
+	}
+	for _, edge := range g.Edges {
+		bottom = math.Max(bottom, routeBottom(edge.Route))
+	}
+	return bottom
+}
+
+func routeTop(route []*geo.Point) float64 {
+	y := math.Inf(1)
+	for _, p := range route {
+		y = math.Min(y, p.Y)
+	}
+	return y
+}
+
+func routeBottom(route []*geo.Point) float64 {
+	y := math.Inf(-1)
+	for _, p := range route {
+		y = math.Max(y, p.Y)
+	}
+	return y
+}
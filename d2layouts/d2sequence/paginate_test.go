@@ -0,0 +1,102 @@
+package d2sequence_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestPaginateByCount(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b: 1
+b -> a: 2
+a -> b.t: 3
+b.t -> c: 4
+c -> b.t: 5
+b.t -> a: 6
+a -> c: 7
+c -> a: 8
+a -> b: 9
+b -> a: 10
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	span, _ := g.Root.HasChild([]string{"b", "t"})
+	firstY := g.Edges[0].Route[0].Y
+
+	pages := d2sequence.PaginateByCount(g, 4)
+	assert.Equal(t, 3, len(pages))
+	for i, page := range pages {
+		// the headers are repeated on every page
+		actors := d2sequence.Actors(page)
+		assert.Equal(t, 3, len(actors))
+		for j, actor := range d2sequence.Actors(g) {
+			assert.Equal(t, actor.AbsID(), actors[j].AbsID())
+			assert.Equal(t, *actor.TopLeft, *actors[j].TopLeft)
+			assert.Equal(t, actor.Width, actors[j].Width)
+		}
+
+		numbers := d2sequence.MessageNumbers(page)
+		if i < 2 {
+			assert.Equal(t, 4, len(numbers))
+		} else {
+			assert.Equal(t, 2, len(numbers))
+		}
+		// the first message of each page is right below the headers
+		y, ok := d2sequence.MessageY(page, 0)
+		assert.True(t, ok)
+		assert.True(t, y <= firstY)
+		assert.True(t, page.Root.Height <= g.Root.Height)
+	}
+	first, _ := pages[0].Root.HasChild([]string{"b", "t"})
+	second, _ := pages[1].Root.HasChild([]string{"b", "t"})
+	_, onLast := pages[2].Root.HasChild([]string{"b", "t"})
+	assert.False(t, onLast)
+
+	// the span of 3 to 6 continues on the second page from its top
+	assert.Equal(t, span.TopLeft.Y, first.TopLeft.Y)
+	assert.True(t, first.TopLeft.Y+first.Height < span.TopLeft.Y+span.Height)
+	assert.True(t, second.TopLeft.Y < pages[1].Edges[0].Route[0].Y)
+	assert.Equal(t, "5", pages[1].Edges[0].Label.Value)
+	assert.True(t, second.TopLeft.Y+second.Height > pages[1].Edges[1].Route[0].Y)
+
+	// the original is left as is
+	assert.Equal(t, 10+3, len(g.Edges))
+}
+
+func TestPaginateWithoutLifelines(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: 1
+b -> a: 2
+a -> b: 3
+b -> a: 4
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{SkipLifelines: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := d2sequence.PaginateByCount(g, 2)
+	assert.Equal(t, 2, len(pages))
+	for _, page := range pages {
+		// pages end below their messages even with no lifelines to end them
+		assert.False(t, math.IsNaN(page.Root.Height))
+		assert.True(t, page.Root.Height < g.Root.Height)
+		assert.Nil(t, d2sequence.CheckBounds(page))
+	}
+}
@@ -0,0 +1,54 @@
+package d2sequence
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// RoutePaths returns the SVG path data of the route of each message and lifeline of a laid out sequence diagram,
+// for renderers to draw them without computing paths. With smoothLoops, the corners of self messages, from an actor
+// back to itself or its spans, are rounded by SELF_MESSAGE_CORNER_RADIUS. Other routes, curved messages included,
+// are straight lines through their points
+func RoutePaths(g *d2graph.Graph, smoothLoops bool) map[*d2graph.Edge]string {
+	paths := make(map[*d2graph.Edge]string, len(g.Edges))
+	for _, edge := range g.Edges {
+		if len(edge.Route) == 0 {
+			continue
+		}
+		radius := 0.
+		if smoothLoops && !IsLifelineEnd(edge.Dst) && isSelfMessage(edge) {
+			radius = SELF_MESSAGE_CORNER_RADIUS
+		}
+		paths[edge] = routePath(edge.Route, radius)
+	}
+	return paths
+}
+
+// routePath is the path data of a route, with its corners rounded by radius, or less on short segments
+func routePath(route []*geo.Point, radius float64) string {
+	path := []string{fmt.Sprintf("M %f %f", route[0].X, route[0].Y)}
+	for i := 1; i < len(route)-1; i++ {
+		prev, corner, next := route[i-1], route[i], route[i+1]
+		r := math.Min(radius, math.Min(
+			geo.EuclideanDistance(prev.X, prev.Y, corner.X, corner.Y)/2,
+			geo.EuclideanDistance(corner.X, corner.Y, next.X, next.Y)/2,
+		))
+		if r <= 0 {
+			path = append(path, fmt.Sprintf("L %f %f", corner.X, corner.Y))
+			continue
+		}
+		in := prev.VectorTo(corner).Unit().Multiply(r).ToPoint()
+		out := corner.VectorTo(next).Unit().Multiply(r).ToPoint()
+		path = append(path,
+			fmt.Sprintf("L %f %f", corner.X-in.X, corner.Y-in.Y),
+			fmt.Sprintf("Q %f %f %f %f", corner.X, corner.Y, corner.X+out.X, corner.Y+out.Y),
+		)
+	}
+	last := route[len(route)-1]
+	path = append(path, fmt.Sprintf("L %f %f", last.X, last.Y))
+	return strings.Join(path, " ")
+}
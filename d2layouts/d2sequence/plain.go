@@ -0,0 +1,70 @@
+package d2sequence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// DumpPlain writes the objects and messages of a laid out sequence diagram in a format like the plain output
+// of Graphviz, to compare layouts with other tools:
+//
+//	graph 1 <width> <height>
+//	node <id> <center x> <center y> <width> <height> <label>
+//	edge <src> <dst> <n> <x1> <y1> ... <xn> <yn> [<label> <label center x> <label center y>]
+//	stop
+//
+// Unlike Graphviz, coordinates are in layout units with y growing downwards. Lifelines are left out,
+// objects that are not laid out too
+func DumpPlain(g *d2graph.Graph) string {
+	var sb strings.Builder
+	if g.Root.Box != nil {
+		fmt.Fprintf(&sb, "graph 1 %s %s\n", plainNumber(g.Root.Width), plainNumber(g.Root.Height))
+	}
+	for _, obj := range g.Objects {
+		if obj.Box == nil || obj.TopLeft == nil {
+			continue
+		}
+		center := obj.Center()
+		fmt.Fprintf(&sb, "node %s %s %s %s %s %s\n",
+			plainID(obj.AbsID()),
+			plainNumber(center.X), plainNumber(center.Y), plainNumber(obj.Width), plainNumber(obj.Height),
+			plainID(obj.Label.Value),
+		)
+	}
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) || len(edge.Route) == 0 {
+			continue
+		}
+		fmt.Fprintf(&sb, "edge %s %s %d", plainID(edge.Src.AbsID()), plainID(edge.Dst.AbsID()), len(edge.Route))
+		for _, p := range edge.Route {
+			fmt.Fprintf(&sb, " %s %s", plainNumber(p.X), plainNumber(p.Y))
+		}
+		if labelBox := edgeLabelBox(edge); labelBox != nil {
+			center := labelBox.Center()
+			fmt.Fprintf(&sb, " %s %s %s", plainID(edge.Label.Value), plainNumber(center.X), plainNumber(center.Y))
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString("stop\n")
+	return sb.String()
+}
+
+// plainID quotes the IDs and labels that are not a single word, like Graphviz does
+func plainID(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if !(r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9') {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func plainNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
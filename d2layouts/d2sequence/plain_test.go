@@ -0,0 +1,43 @@
+package d2sequence_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/diff"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestDumpPlain(t *testing.T) {
+	input := `
+shape: sequence_diagram
+alice; bob
+alice -> bob.t: hello
+bob.t -> bob.t: think
+bob.t -> alice
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	for _, e := range g.Edges {
+		if e.Label.Value != "" {
+			e.LabelDimensions.Width = 40
+			e.LabelDimensions.Height = 20
+		}
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = diff.Testdata(filepath.Join("..", "..", "testdata", "d2sequence", t.Name()), ".txt", []byte(d2sequence.DumpPlain(g)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
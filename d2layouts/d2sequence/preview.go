@@ -0,0 +1,167 @@
+package d2sequence
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// min space between the lifelines of neighbor actors in ASCIIPreview
+const PREVIEW_MIN_ACTOR_DISTANCE = 10
+
+// ASCIIPreview draws a sequence diagram as text, with the actors as columns and the messages as rows
+// in the order they are laid out, e.g. to make test failures readable
+// . +---+     +---+
+// . | a |     | b |
+// . +-+-+     +-+-+
+// .   |   hi    |
+// .   |-------->|
+// .   |<--------|
+func ASCIIPreview(g *d2graph.Graph) string {
+	actors := Actors(g)
+	if len(actors) == 0 {
+		return ""
+	}
+	column := make(map[*d2graph.Object]int)
+	for i, actor := range actors {
+		column[actor] = i
+	}
+	actorOf := func(obj *d2graph.Object) (int, bool) {
+		for obj != nil && obj.Parent != g.Root {
+			obj = obj.Parent
+		}
+		i, ok := column[obj]
+		return i, ok
+	}
+
+	type row struct {
+		message  *d2graph.Edge
+		src, dst int
+	}
+	var rows []row
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			continue
+		}
+		src, srcOK := actorOf(edge.Src)
+		dst, dstOK := actorOf(edge.Dst)
+		if srcOK && dstOK {
+			rows = append(rows, row{edge, src, dst})
+		}
+	}
+	// messages are drawn from top to bottom once laid out, in declaration order otherwise
+	sort.SliceStable(rows, func(i, j int) bool {
+		return previewY(rows[i].message) < previewY(rows[j].message)
+	})
+
+	labels := make([]string, len(actors))
+	for i, actor := range actors {
+		labels[i] = actor.Label.Value
+		if labels[i] == "" {
+			labels[i] = actor.ID
+		}
+	}
+	// like the layout, the space between actors fits the labels of the messages between them
+	gaps := make([]int, len(actors))
+	for i := 0; i < len(actors)-1; i++ {
+		gaps[i] = go2.IntMax((utf8.RuneCountInString(labels[i])+4)/2+(utf8.RuneCountInString(labels[i+1])+4)/2+2, PREVIEW_MIN_ACTOR_DISTANCE)
+	}
+	for _, r := range rows {
+		first, last := go2.IntMin(r.src, r.dst), go2.IntMax(r.src, r.dst)
+		if first == last {
+			continue
+		}
+		perRank := int(math.Ceil(float64(utf8.RuneCountInString(r.message.Label.Value)+4) / float64(last-first)))
+		for i := first; i < last; i++ {
+			gaps[i] = go2.IntMax(gaps[i], perRank)
+		}
+	}
+	centers := make([]int, len(actors))
+	centers[0] = (utf8.RuneCountInString(labels[0]) + 4) / 2
+	for i := 1; i < len(actors); i++ {
+		centers[i] = centers[i-1] + gaps[i-1]
+	}
+
+	// lines are runes so that labels are as wide as their characters, not their bytes
+	var lines [][]rune
+	set := func(line []rune, x int, s string) []rune {
+		r := []rune(s)
+		for len(line) < x+len(r) {
+			line = append(line, ' ')
+		}
+		copy(line[x:], r)
+		return line
+	}
+	newLine := func() []rune {
+		var line []rune
+		for _, x := range centers {
+			line = set(line, x, "|")
+		}
+		return line
+	}
+
+	var top, middle, bottom []rune
+	for i, x := range centers {
+		width := utf8.RuneCountInString(labels[i]) + 4
+		left := x - width/2
+		border := "+" + strings.Repeat("-", width-2) + "+"
+		top = set(top, left, border)
+		middle = set(middle, left, "| "+labels[i]+" |")
+		bottom = set(bottom, left, border)
+		bottom = set(bottom, x, "+")
+	}
+	lines = append(lines, top, middle, bottom)
+
+	for _, r := range rows {
+		text := r.message.Label.Value
+		src, dst := centers[r.src], centers[r.dst]
+		if r.src == r.dst {
+			lines = append(lines, set(newLine(), src+1, "---+"))
+			line := newLine()
+			line = set(line, src+4, "|")
+			if text != "" {
+				line = set(line, src+6, text)
+			}
+			lines = append(lines, line, set(newLine(), src+1, "<--+"))
+			continue
+		}
+		if text != "" {
+			lines = append(lines, set(newLine(), (src+dst)/2-utf8.RuneCountInString(text)/2, text))
+		}
+		arrow := strings.Repeat("-", abs(dst-src)-1)
+		if src < dst {
+			arrow = arrow[1:] + ">"
+			lines = append(lines, set(newLine(), src+1, arrow))
+		} else {
+			arrow = "<" + arrow[1:]
+			lines = append(lines, set(newLine(), dst+1, arrow))
+		}
+	}
+	lines = append(lines, newLine())
+
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(strings.TrimRight(string(line), " "))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func previewY(message *d2graph.Edge) float64 {
+	if len(message.Route) == 0 {
+		return 0
+	}
+	return message.Route[0].Y
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
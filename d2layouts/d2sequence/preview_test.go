@@ -0,0 +1,59 @@
+package d2sequence_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/util-go/diff"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestASCIIPreview(t *testing.T) {
+	input := `
+shape: sequence_diagram
+alice; bob; carol
+alice -> bob: hello
+bob -> carol: forward the greeting
+carol -> carol: think
+carol -> alice
+bob -> alice: bye
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	err = diff.Testdata(filepath.Join("..", "..", "testdata", "d2sequence", t.Name()), ".txt", []byte(d2sequence.ASCIIPreview(g)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestASCIIPreviewUnicode(t *testing.T) {
+	input := `
+shape: sequence_diagram
+école; bureau
+école -> bureau: déjà vu ✓
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// labels take a column per character, not per byte
+	err = diff.Testdata(filepath.Join("..", "..", "testdata", "d2sequence", t.Name()), ".txt", []byte(d2sequence.ASCIIPreview(g)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
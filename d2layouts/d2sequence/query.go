@@ -0,0 +1,195 @@
+package d2sequence
+
+import (
+	"math"
+	"sort"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// HitTest returns the message whose route passes within tolerance of p
+// when several messages are within tolerance, the closest one wins, with ties going to the topmost
+func HitTest(g *d2graph.Graph, p *geo.Point, tolerance float64) (*d2graph.Edge, bool) {
+	var hit *d2graph.Edge
+	hitDistance := math.Inf(1)
+	hitY := math.Inf(1)
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			continue
+		}
+		distance := math.Inf(1)
+		minY := math.Inf(1)
+		for i := 1; i < len(edge.Route); i++ {
+			distance = math.Min(distance, p.DistanceToLine(edge.Route[i-1], edge.Route[i]))
+			minY = math.Min(minY, math.Min(edge.Route[i-1].Y, edge.Route[i].Y))
+		}
+		if distance > tolerance {
+			continue
+		}
+		if distance < hitDistance || (distance == hitDistance && minY < hitY) {
+			hit = edge
+			hitDistance = distance
+			hitY = minY
+		}
+	}
+	return hit, hit != nil
+}
+
+// MessageNumbers numbers the messages of a laid out sequence diagram from 1, from top to bottom.
+// Messages drawn at the same height, like concurrent messages, have the same number
+func MessageNumbers(g *d2graph.Graph) map[*d2graph.Edge]int {
+	var messages []*d2graph.Edge
+	for _, edge := range g.Edges {
+		if !IsLifelineEnd(edge.Dst) && len(edge.Route) > 0 {
+			messages = append(messages, edge)
+		}
+	}
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].Route[0].Y < messages[j].Route[0].Y
+	})
+	numbers := make(map[*d2graph.Edge]int, len(messages))
+	number := 0
+	for i, message := range messages {
+		if i == 0 || message.Route[0].Y != messages[i-1].Route[0].Y {
+			number++
+		}
+		numbers[message] = number
+	}
+	return numbers
+}
+
+// MessageY returns the y a message of a laid out sequence diagram starts at, by its index from 0 among the messages
+// in declaration order, not in the order the layout draws concurrent messages in, see d2graph.Graph.DeclaredEdges
+func MessageY(g *d2graph.Graph, index int) (float64, bool) {
+	if index < 0 {
+		return 0, false
+	}
+	for _, edge := range g.DeclaredEdges() {
+		if IsLifelineEnd(edge.Dst) || len(edge.Route) == 0 {
+			continue
+		}
+		if index == 0 {
+			return edge.Route[0].Y, true
+		}
+		index--
+	}
+	return 0, false
+}
+
+// ActorIndex returns the column of an actor of a laid out sequence diagram, from 0 for the leftmost actor
+func ActorIndex(g *d2graph.Graph, actor *d2graph.Object) (int, bool) {
+	for i, a := range Actors(g) {
+		if a == actor {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+// LifelineX returns the x of the lifeline of an actor of a laid out sequence diagram. Without lifeline edges,
+// e.g. with SkipLifelines, it is the center of the actor, where the lifeline is drawn
+func LifelineX(g *d2graph.Graph, actor *d2graph.Object) (float64, bool) {
+	for _, edge := range g.Edges {
+		if edge.Src == actor && IsLifelineEnd(edge.Dst) {
+			return edge.Route[0].X, true
+		}
+	}
+	isActor := actor.Parent != nil && actor.Parent.IsSequenceDiagram() && !actor.IsSequenceDiagramGroup()
+	if isActor && actor.Box != nil && actor.TopLeft != nil {
+		return actor.Center().X, true
+	}
+	return 0, false
+}
+
+// BoxAt returns the innermost activation box of an actor of a laid out sequence diagram that spans y,
+// e.g. the box a message endpoint at y is attached to
+func BoxAt(actor *d2graph.Object, y float64) (*d2graph.Object, bool) {
+	var box *d2graph.Object
+	var visit func(obj *d2graph.Object)
+	visit = func(obj *d2graph.Object) {
+		for _, child := range obj.ChildrenArray {
+			if child.IsSequenceDiagramNote() || child.TopLeft == nil {
+				continue
+			}
+			if child.TopLeft.Y <= y && y <= child.TopLeft.Y+child.Height {
+				// nested boxes are children of the boxes they are in, so the last one found is the deepest
+				box = child
+				visit(child)
+				return
+			}
+		}
+	}
+	visit(actor)
+	return box, box != nil
+}
+
+// IncomingMessages returns the messages an actor of a sequence diagram receives, on its lifeline or its spans,
+// in the order of the graph edges. Self messages are both incoming and outgoing
+func IncomingMessages(actor *d2graph.Object) []*d2graph.Edge {
+	return actorMessages(actor, func(edge *d2graph.Edge) *d2graph.Object {
+		return edge.Dst
+	})
+}
+
+// OutgoingMessages returns the messages an actor of a sequence diagram sends, from its lifeline or its spans,
+// in the order of the graph edges. Self messages are both incoming and outgoing
+func OutgoingMessages(actor *d2graph.Object) []*d2graph.Edge {
+	return actorMessages(actor, func(edge *d2graph.Edge) *d2graph.Object {
+		return edge.Src
+	})
+}
+
+func actorMessages(actor *d2graph.Object, end func(*d2graph.Edge) *d2graph.Object) []*d2graph.Edge {
+	var messages []*d2graph.Edge
+	for _, edge := range actor.Graph.Edges {
+		if IsLifelineEnd(edge.Dst) {
+			continue
+		}
+		if end(edge).IsDescendantOf(actor) {
+			messages = append(messages, edge)
+		}
+	}
+	return messages
+}
+
+// Actors returns the actors of a sequence diagram from left to right once laid out, in declaration order before.
+// A diagram with actors that are not placed, e.g. added after the layout, keeps them all in declaration order
+func Actors(g *d2graph.Graph) []*d2graph.Object {
+	var actors []*d2graph.Object
+	for _, obj := range g.Root.ChildrenArray {
+		if !obj.IsSequenceDiagramGroup() {
+			actors = append(actors, obj)
+		}
+	}
+	for _, actor := range actors {
+		if actor.Box == nil || actor.TopLeft == nil {
+			return actors
+		}
+	}
+	sort.SliceStable(actors, func(i, j int) bool {
+		return actors[i].TopLeft.X < actors[j].TopLeft.X
+	})
+	return actors
+}
+
+// PageBreaks returns the x positions to split a laid out sequence diagram at, from left to right, so that each page
+// is at most pageWidth wide. Breaks fall halfway between neighboring actors, never across an actor, so an actor wider
+// than pageWidth gets a page of its own that is wider than pageWidth
+func PageBreaks(g *d2graph.Graph, pageWidth float64) []float64 {
+	if pageWidth <= 0 {
+		return nil
+	}
+	var breaks []float64
+	pageStart := g.Root.TopLeft.X
+	var prev *d2graph.Object
+	for _, actor := range Actors(g) {
+		if prev != nil && actor.TopLeft.X+actor.Width > pageStart+pageWidth {
+			x := (prev.TopLeft.X + prev.Width + actor.TopLeft.X) / 2
+			breaks = append(breaks, x)
+			pageStart = x
+		}
+		prev = actor
+	}
+	return breaks
+}
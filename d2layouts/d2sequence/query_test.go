@@ -0,0 +1,467 @@
+package d2sequence_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestHitTest(t *testing.T) {
+	g := d2graph.NewGraph()
+	g.Root.Shape = d2graph.Scalar{Value: d2target.ShapeSequenceDiagram}
+	a := g.Root.EnsureChild([]string{"a"})
+	a.Box = geo.NewBox(nil, 100, 100)
+	b := g.Root.EnsureChild([]string{"b"})
+	b.Box = geo.NewBox(nil, 100, 100)
+	g.Edges = []*d2graph.Edge{{Src: a, Dst: b}, {Src: b, Dst: a}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	first, second := g.Edges[0], g.Edges[1]
+	midX := (first.Route[0].X + first.Route[1].X) / 2.
+
+	hit, ok := d2sequence.HitTest(g, geo.NewPoint(midX, second.Route[0].Y-3), 5)
+	if !ok || hit != second {
+		t.Fatal("expected to hit the second message")
+	}
+
+	// equally distant from both messages
+	tieY := (first.Route[0].Y + second.Route[0].Y) / 2.
+	hit, ok = d2sequence.HitTest(g, geo.NewPoint(midX, tieY), tieY-first.Route[0].Y)
+	if !ok || hit != first {
+		t.Fatal("expected ties to go to the topmost message")
+	}
+
+	if _, ok = d2sequence.HitTest(g, geo.NewPoint(midX, tieY), 5); ok {
+		t.Fatal("expected no message within tolerance")
+	}
+	// lifelines are not messages
+	if _, ok = d2sequence.HitTest(g, geo.NewPoint(a.Center().X, a.TopLeft.Y+a.Height+1), 2); ok {
+		t.Fatal("expected lifelines not to be hit")
+	}
+}
+
+func TestActorIndexAndLifelineX(t *testing.T) {
+	input := `
+shape: sequence_diagram
+c; a; b
+group: {
+  a -> b.t
+}
+c -> a
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var actors []*d2graph.Object
+	for _, id := range []string{"c", "a", "b"} {
+		actor, _ := g.Root.HasChild([]string{id})
+		actors = append(actors, actor)
+	}
+	for i, actor := range actors {
+		index, ok := d2sequence.ActorIndex(g, actor)
+		if !ok || index != i {
+			t.Fatalf("expected %s to be at index %d, got %d", actor.ID, i, index)
+		}
+		if i > 0 && actor.Center().X <= actors[i-1].Center().X {
+			t.Fatalf("expected %s to be right of %s", actor.ID, actors[i-1].ID)
+		}
+		x, ok := d2sequence.LifelineX(g, actor)
+		if !ok || x != actor.Center().X {
+			t.Fatalf("expected %s lifeline at %.5f, got %.5f", actor.ID, actor.Center().X, x)
+		}
+	}
+
+	group, _ := g.Root.HasChild([]string{"group"})
+	if _, ok := d2sequence.ActorIndex(g, group); ok {
+		t.Fatal("expected groups not to be actors")
+	}
+	span, _ := g.Root.HasChild([]string{"b", "t"})
+	if _, ok := d2sequence.LifelineX(g, span); ok {
+		t.Fatal("expected spans not to have lifelines")
+	}
+}
+
+func TestActors(t *testing.T) {
+	input := `
+shape: sequence_diagram
+b; c; a
+group: {
+  b -> c
+}
+c -> a
+`
+	g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := func(objects []*d2graph.Object) []string {
+		var ids []string
+		for _, obj := range objects {
+			ids = append(ids, obj.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"b", "c", "a"}, ids(d2sequence.Actors(g)))
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	actors := d2sequence.Actors(g)
+	assert.Equal(t, []string{"b", "c", "a"}, ids(actors))
+	for i := 1; i < len(actors); i++ {
+		if actors[i].TopLeft.X <= actors[i-1].TopLeft.X {
+			t.Fatalf("expected %s to be right of %s", actors[i].ID, actors[i-1].ID)
+		}
+	}
+
+	// with an actor added after the layout, none of them are ordered by x
+	actors[0].TopLeft.X, actors[2].TopLeft.X = actors[2].TopLeft.X, actors[0].TopLeft.X
+	g.Root.EnsureChild([]string{"d"})
+	assert.Equal(t, []string{"b", "c", "a", "d"}, ids(d2sequence.Actors(g)))
+}
+
+func TestPageBreaks(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c; d; e
+a -> b -> c -> d -> e
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	actors := d2sequence.Actors(g)
+
+	// room for two actors a page
+	pageWidth := actors[2].TopLeft.X + actors[2].Width - g.Root.TopLeft.X - 1
+	breaks := d2sequence.PageBreaks(g, pageWidth)
+	assert.Equal(t, 2, len(breaks))
+	for _, x := range breaks {
+		for _, actor := range actors {
+			if actor.TopLeft.X < x && x < actor.TopLeft.X+actor.Width {
+				t.Fatalf("expected the break at %v outside of %s", x, actor.AbsID())
+			}
+		}
+	}
+	assert.True(t, actors[1].TopLeft.X+actors[1].Width < breaks[0] && breaks[0] < actors[2].TopLeft.X)
+	assert.True(t, actors[3].TopLeft.X+actors[3].Width < breaks[1] && breaks[1] < actors[4].TopLeft.X)
+
+	// the whole diagram fits in one page
+	assert.Equal(t, 0, len(d2sequence.PageBreaks(g, g.Root.Width)))
+}
+
+func TestReveals(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b.t: one
+b.t -> c: two
+b.note: "waits"
+c -> b.t: three
+b.t -> a: four
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reveals := d2sequence.Reveals(g, 0.5)
+	prev := d2sequence.Reveal{}
+	for _, edge := range g.Edges[:4] {
+		reveal, has := reveals[edge.AbsID()]
+		if !has {
+			t.Fatalf("expected %s to be revealed", edge.AbsID())
+		}
+		assert.True(t, reveal.Ordinal > prev.Ordinal)
+		assert.True(t, reveal.Begin > prev.Begin || prev.Ordinal == 0)
+		assert.Equal(t, 0.5, reveal.Duration)
+		prev = reveal
+	}
+	assert.Equal(t, 1, reveals[g.Edges[0].AbsID()].Ordinal)
+	assert.Equal(t, 0., reveals[g.Edges[0].AbsID()].Begin)
+
+	// the note comes between the messages around it and the span with its first message
+	note := reveals["b.note"]
+	assert.Equal(t, reveals[g.Edges[1].AbsID()].Ordinal+1, note.Ordinal)
+	assert.Equal(t, reveals[g.Edges[2].AbsID()].Ordinal-1, note.Ordinal)
+	assert.Equal(t, reveals[g.Edges[0].AbsID()], reveals["b.t"])
+
+	// messages keep the IDs they were declared with, not the ones of the spans InferActivations moves them to
+	g, _, err = d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: call
+b -> a: return
+`), nil)
+	assert.Nil(t, err)
+	ids := []string{g.Edges[0].AbsID(), g.Edges[1].AbsID()}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{InferActivations: true}); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotEqual(t, ids[0], g.Edges[0].AbsID())
+	reveals = d2sequence.Reveals(g, 1)
+	for i, id := range ids {
+		reveal, has := reveals[id]
+		if !has {
+			t.Fatalf("expected %s to be revealed", id)
+		}
+		assert.Equal(t, i+1, reveal.Ordinal)
+	}
+	_, has := reveals[g.Edges[0].AbsID()]
+	assert.False(t, has)
+}
+
+func TestMessageY(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+b -> b: loop
+b -> a
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, edge := range g.Edges[:3] {
+		y, ok := d2sequence.MessageY(g, i)
+		assert.True(t, ok)
+		assert.Equal(t, edge.Route[0].Y, y)
+	}
+	// lifelines are not messages
+	_, ok := d2sequence.MessageY(g, 3)
+	assert.False(t, ok)
+	_, ok = d2sequence.MessageY(g, -1)
+	assert.False(t, ok)
+
+	// concurrent messages drawn in another order keep their declared index
+	g, _, err = d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b: first
+a -> c: second
+`), nil)
+	assert.Nil(t, err)
+	declared := append([]*d2graph.Edge{}, g.Edges...)
+	opts := &d2sequence.ConfigurableOpts{Messages: map[string]d2sequence.MessageOpts{
+		declared[0].AbsID(): {ConcurrencyGroup: "fork", Priority: 1},
+		declared[1].AbsID(): {ConcurrencyGroup: "fork"},
+	}}
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, declared[1], g.Edges[0])
+	// moved to tell it from the first message, drawn at the same height
+	declared[1].Route[0].Y += 10
+	y, ok := d2sequence.MessageY(g, 0)
+	assert.True(t, ok)
+	assert.Equal(t, declared[0].Route[0].Y, y)
+	y, ok = d2sequence.MessageY(g, 1)
+	assert.True(t, ok)
+	assert.Equal(t, declared[1].Route[0].Y, y)
+}
+
+func TestFingerprint(t *testing.T) {
+	compile := func(input string) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		assert.Nil(t, err)
+		return g
+	}
+	input := `
+shape: sequence_diagram
+a; b
+a -> b: hello
+b -> a: hi
+`
+	g := compile(input)
+	fingerprint := d2sequence.Fingerprint(g)
+	assert.Equal(t, 16, len(fingerprint))
+
+	// geometry does not change the fingerprint
+	resized := compile(input)
+	for _, obj := range resized.Objects {
+		obj.Box = geo.NewBox(nil, 300, 80)
+	}
+	assert.Equal(t, fingerprint, d2sequence.Fingerprint(resized))
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, resized, nil); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, fingerprint, d2sequence.Fingerprint(resized))
+
+	for _, changed := range []string{
+		"shape: sequence_diagram\na; b\na -> b: hello\nb -> a: bye\n",
+		"shape: sequence_diagram\nb; a\na -> b: hello\nb -> a: hi\n",
+		"shape: sequence_diagram\na; b\na -> b: hello\na -> b: hi\n",
+	} {
+		assert.NotEqual(t, fingerprint, d2sequence.Fingerprint(compile(changed)))
+	}
+}
+
+func TestRoutePaths(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b
+b -> b
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	straight, loop := g.Edges[0], g.Edges[1]
+
+	paths := d2sequence.RoutePaths(g, false)
+	assert.Equal(t, len(g.Edges), len(paths))
+	start, end := straight.Route[0], straight.Route[1]
+	assert.Equal(t, fmt.Sprintf("M %f %f L %f %f", start.X, start.Y, end.X, end.Y), paths[straight])
+	assert.Equal(t, 4, strings.Count(paths[loop], " L ")+strings.Count(paths[loop], "M "))
+
+	// smoothed loops curve at their 2 corners, straight messages stay straight
+	smooth := d2sequence.RoutePaths(g, true)
+	assert.Equal(t, paths[straight], smooth[straight])
+	assert.Equal(t, 2, strings.Count(smooth[loop], " Q "))
+
+	// curved messages go through their midpoint, only loops are rounded
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{Curvature: 0.2}); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 3, len(straight.Route))
+	smooth = d2sequence.RoutePaths(g, true)
+	assert.Equal(t, 0, strings.Count(smooth[straight], " Q "))
+	assert.Equal(t, 2, strings.Count(smooth[loop], " Q "))
+}
+
+func TestBoxAt(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b.outer: call
+b.outer -> b.outer.inner: nested
+b.outer.inner -> a: reply
+a -> b: after
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b := g.Root.ChildrenArray[1]
+	outer := b.ChildrenArray[0]
+	inner := outer.ChildrenArray[0]
+
+	// the nested message ends on both boxes, the deepest one wins
+	box, ok := d2sequence.BoxAt(b, g.Edges[1].Route[len(g.Edges[1].Route)-1].Y)
+	assert.True(t, ok)
+	assert.Equal(t, inner, box)
+
+	box, ok = d2sequence.BoxAt(b, g.Edges[0].Route[0].Y)
+	assert.True(t, ok)
+	assert.Equal(t, outer, box)
+
+	_, ok = d2sequence.BoxAt(b, g.Edges[3].Route[0].Y)
+	assert.False(t, ok)
+}
+
+func TestIncomingAndOutgoingMessages(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b.t: call
+b.t -> c: forward
+c -> b.t: answer
+b -> b: think
+b.t -> a: return
+a -> c
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := g.Root.HasChild([]string{"b"})
+	call, forward, answer, think, ret := g.Edges[0], g.Edges[1], g.Edges[2], g.Edges[3], g.Edges[4]
+	// messages to and from the span of b are b's too, the self message is in both
+	assert.Equal(t, []*d2graph.Edge{call, answer, think}, d2sequence.IncomingMessages(b))
+	assert.Equal(t, []*d2graph.Edge{forward, think, ret}, d2sequence.OutgoingMessages(b))
+
+	a, _ := g.Root.HasChild([]string{"a"})
+	assert.Equal(t, []*d2graph.Edge{ret}, d2sequence.IncomingMessages(a))
+	assert.Equal(t, []*d2graph.Edge{call, g.Edges[5]}, d2sequence.OutgoingMessages(a))
+}
+
+func TestLabelAnchor(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b
+a -> b: call
+b -> b: think
+b -> a: return
+a -> b
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	call, think, ret := g.Edges[0], g.Edges[1], g.Edges[2]
+	for _, tc := range []struct {
+		message *d2graph.Edge
+		side    d2sequence.LabelSide
+	}{
+		{call, d2sequence.LabelSideAbove},
+		{think, d2sequence.LabelSideRight},
+		{ret, d2sequence.LabelSideBelow},
+	} {
+		anchor, side := d2sequence.LabelAnchor(tc.message)
+		if anchor == nil {
+			t.Fatalf("expected %s to have an anchor", tc.message.AbsID())
+		}
+		route := geo.Route(tc.message.Route)
+		midpoint, _ := route.GetPointAtDistance(route.Length() / 2)
+		assert.Equal(t, *midpoint, *anchor, tc.message.AbsID())
+		assert.Equal(t, tc.side, side, tc.message.AbsID())
+	}
+	// the anchor of straight messages is halfway between their ends
+	anchor, _ := d2sequence.LabelAnchor(call)
+	assert.Equal(t, (call.Route[0].X+call.Route[len(call.Route)-1].X)/2, anchor.X)
+
+	g.Edges[3].Route = nil
+	anchor, side := d2sequence.LabelAnchor(g.Edges[3])
+	assert.Nil(t, anchor)
+	assert.Equal(t, d2sequence.LabelSide(""), side)
+
+	// a route collapsed to a point has no direction to place the label by
+	g.Edges[3].Route = []*geo.Point{geo.NewPoint(10, 10), geo.NewPoint(10, 10)}
+	anchor, side = d2sequence.LabelAnchor(g.Edges[3])
+	assert.Nil(t, anchor)
+	assert.Equal(t, d2sequence.LabelSide(""), side)
+}
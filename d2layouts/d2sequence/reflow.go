@@ -0,0 +1,41 @@
+package d2sequence
+
+import (
+	"context"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// ReflowMessages places the messages of a sequence diagram laid out with Layout again, in the order of g.Edges,
+// e.g. after inserting a message in the middle. Actors keep their x, everything below them is placed again,
+// so that the message numbers given by MessageNumbers follow the new order.
+// It is a full layout of the diagram with the actors held in place: what the previous layout changed in the graph,
+// like inferred spans or scaled labels, is undone first, and the options apply like they do in Layout
+func ReflowMessages(ctx context.Context, g *d2graph.Graph) error {
+	return ReflowMessagesWithOpts(ctx, g, nil)
+}
+
+// ReflowMessagesWithOpts is ReflowMessages for a diagram laid out with LayoutWithOpts and the same opts.
+// The actors of a diagram snapped to SnapGrid keep their snapped x. A diagram that must be scaled down to fit
+// CanvasOpts cannot keep its actors in place, since the scale changes with the messages, and is an error
+func ReflowMessagesWithOpts(ctx context.Context, g *d2graph.Graph, opts *ConfigurableOpts) error {
+	if opts == nil {
+		opts = &DefaultOpts
+	}
+	if !g.Root.IsSequenceDiagram() || g.Root.Box == nil || g.Root.TopLeft == nil {
+		return errorf(VALIDATE_STAGE, g.Root, "%s is not a laid out sequence diagram", g.Root.AbsID())
+	}
+	actors := Actors(g)
+	for _, actor := range actors {
+		if actor.Box == nil || actor.TopLeft == nil {
+			return errorf(VALIDATE_STAGE, actor, "%s is not laid out, its diagram must be laid out again", actor.ID)
+		}
+	}
+
+	reflowOpts := *opts
+	reflowOpts.actorOffsets = make(map[string]float64, len(actors))
+	for _, actor := range actors {
+		reflowOpts.actorOffsets[actor.AbsID()] = actor.Center().X - actors[0].Center().X
+	}
+	return LayoutWithOpts(ctx, g, nil, &reflowOpts)
+}
@@ -0,0 +1,188 @@
+package d2sequence_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestReflowMessages(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b: first
+b -> c: second
+c -> a: third
+`), nil)
+	assert.Nil(t, err)
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.Layout(ctx, g, nil); err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.Root.HasChild([]string{"a"})
+	b, _ := g.Root.HasChild([]string{"b"})
+	c, _ := g.Root.HasChild([]string{"c"})
+	xs := []float64{a.TopLeft.X, b.TopLeft.X, c.TopLeft.X}
+	first, second, third := g.Edges[0], g.Edges[1], g.Edges[2]
+	rowStep := second.Route[0].Y - first.Route[0].Y
+	secondY := second.Route[0].Y
+	thirdY := third.Route[0].Y
+
+	inserted := &d2graph.Edge{
+		Src:      a,
+		Dst:      c,
+		DstArrow: true,
+		Attributes: d2graph.Attributes{
+			Label:           d2graph.Scalar{Value: "inserted"},
+			LabelDimensions: first.LabelDimensions,
+		},
+	}
+	g.Edges = append([]*d2graph.Edge{first, inserted}, g.Edges[1:]...)
+	if err := d2sequence.ReflowMessages(ctx, g); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, xs, []float64{a.TopLeft.X, b.TopLeft.X, c.TopLeft.X})
+	assert.Equal(t, secondY, inserted.Route[0].Y)
+	assert.Equal(t, secondY+rowStep, second.Route[0].Y)
+	assert.Equal(t, thirdY+rowStep, third.Route[0].Y)
+
+	numbers := d2sequence.MessageNumbers(g)
+	assert.Equal(t, 1, numbers[first])
+	assert.Equal(t, 2, numbers[inserted])
+	assert.Equal(t, 3, numbers[second])
+	assert.Equal(t, 4, numbers[third])
+}
+
+func TestReflowMessagesRelayout(t *testing.T) {
+	input := `
+shape: sequence_diagram
+a; b; c
+a -> b: call
+b -> c: forward
+c -> b: answer
+a -> c: lost
+`
+	newGraph := func() *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(input), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, obj := range g.Objects {
+			obj.LabelDimensions = d2target.TextDimensions{Width: 37, Height: 13}
+		}
+		for _, edge := range g.Edges {
+			edge.LabelDimensions = d2target.TextDimensions{Width: 41, Height: 13}
+		}
+		return g
+	}
+	snapshot := func(g *d2graph.Graph) string {
+		var sb strings.Builder
+		for _, obj := range g.Objects {
+			fmt.Fprintf(&sb, "%s %v %v %v %v\n", obj.AbsID(), obj.TopLeft, obj.Width, obj.Height, obj.LabelDimensions)
+		}
+		for _, edge := range g.Edges {
+			fmt.Fprintf(&sb, "%s", edge.AbsID())
+			for _, p := range edge.Route {
+				fmt.Fprintf(&sb, " %v", *p)
+			}
+			sb.WriteString("\n")
+		}
+		for _, d := range g.Decorations {
+			fmt.Fprintf(&sb, "%s %v %v %v\n", d.Kind, d.TopLeft, d.Width, d.Height)
+		}
+		return sb.String()
+	}
+
+	// reflowing a diagram without changing its messages lays it out the same, from the same inputs
+	for _, opts := range []*d2sequence.ConfigurableOpts{
+		{InferActivations: true, LostReturnStubs: true},
+		{SnapGrid: 8},
+		{Canvas: &d2sequence.CanvasOpts{Width: 1000, Height: 1000, ScaleDown: true}},
+	} {
+		ctx := log.WithTB(context.Background(), t, nil)
+		laidOut := newGraph()
+		if err := d2sequence.LayoutWithOpts(ctx, laidOut, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		reflowed := newGraph()
+		if err := d2sequence.LayoutWithOpts(ctx, reflowed, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		if err := d2sequence.ReflowMessagesWithOpts(ctx, reflowed, opts); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, snapshot(laidOut), snapshot(reflowed))
+	}
+}
+
+func TestReflowMessagesSnapped(t *testing.T) {
+	layout := func(opts *d2sequence.ConfigurableOpts) *d2graph.Graph {
+		g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+a; b; c
+a -> b: first
+b -> c: second
+`), nil)
+		assert.Nil(t, err)
+		for _, edge := range g.Edges {
+			edge.LabelDimensions = d2target.TextDimensions{Width: 41, Height: 13}
+		}
+		ctx := log.WithTB(context.Background(), t, nil)
+		if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+			t.Fatal(err)
+		}
+		return g
+	}
+	insert := func(g *d2graph.Graph, label string) {
+		a, _ := g.Root.HasChild([]string{"a"})
+		c, _ := g.Root.HasChild([]string{"c"})
+		inserted := &d2graph.Edge{Src: a, Dst: c, DstArrow: true}
+		inserted.Label.Value = label
+		inserted.LabelDimensions = d2target.TextDimensions{Width: 300, Height: 13}
+		g.Edges = append([]*d2graph.Edge{g.Edges[0], inserted}, g.Edges[1:]...)
+	}
+	xs := func(g *d2graph.Graph) []float64 {
+		var xs []float64
+		for _, actor := range d2sequence.Actors(g) {
+			xs = append(xs, actor.Center().X)
+		}
+		return xs
+	}
+
+	// the actors keep their snapped x, even with a label that would space them further apart
+	opts := &d2sequence.ConfigurableOpts{SnapGrid: 8}
+	g := layout(opts)
+	before := xs(g)
+	insert(g, "a much longer inserted message")
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.ReflowMessagesWithOpts(ctx, g, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, before, xs(g))
+	for _, x := range xs(g) {
+		assert.Equal(t, 0., math.Mod(x, 8))
+	}
+
+	// a diagram scaled down to fit its canvas would have its actors moved by the new scale
+	opts = &d2sequence.ConfigurableOpts{Canvas: &d2sequence.CanvasOpts{Width: 300, Height: 300, ScaleDown: true}}
+	g = layout(opts)
+	insert(g, "inserted")
+	err := d2sequence.ReflowMessagesWithOpts(ctx, g, opts)
+	var layoutErr *d2sequence.LayoutError
+	if !errors.As(err, &layoutErr) {
+		t.Fatalf("expected a layout error, got %v", err)
+	}
+	assert.Equal(t, d2sequence.BOUNDS_STAGE, layoutErr.Stage)
+}
@@ -0,0 +1,135 @@
+package d2sequence
+
+import (
+	"sort"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// max number of barycenter passes of reorderActors
+const REORDER_PASSES = 8
+
+// reorderActors returns the actors in the order with the shortest total message length found by barycenter passes
+// then swaps of pairs of actors, or in their order if none is shorter. Pinned actors and actors in actor groups
+// stay in their column
+func reorderActors(actors []*d2graph.Object, messages []*d2graph.Edge, opts *ConfigurableOpts) []*d2graph.Object {
+	if len(actors) < 3 {
+		return actors
+	}
+	root := actors[0].Parent
+	actorOf := func(obj *d2graph.Object) *d2graph.Object {
+		for obj.Parent != nil && obj.Parent != root {
+			obj = obj.Parent
+		}
+		return obj
+	}
+	pinned := make(map[*d2graph.Object]bool)
+	grouped := make(map[string]bool)
+	var walk func(groups []ActorGroup)
+	walk = func(groups []ActorGroup) {
+		for _, group := range groups {
+			for _, id := range group.Actors {
+				grouped[id] = true
+			}
+			walk(group.Groups)
+		}
+	}
+	walk(opts.ActorGroups)
+	for _, actor := range actors {
+		pinned[actor] = opts.Actors[actor.AbsID()].Pinned || grouped[actor.AbsID()]
+	}
+
+	type pair struct{ src, dst *d2graph.Object }
+	var pairs []pair
+	for _, message := range messages {
+		src, dst := actorOf(message.Src), actorOf(message.Dst)
+		if src != dst {
+			pairs = append(pairs, pair{src, dst})
+		}
+	}
+	// the total length of the messages in columns
+	cost := func(order []*d2graph.Object) int {
+		index := make(map[*d2graph.Object]int, len(order))
+		for i, actor := range order {
+			index[actor] = i
+		}
+		total := 0
+		for _, p := range pairs {
+			d := index[p.src] - index[p.dst]
+			if d < 0 {
+				d = -d
+			}
+			total += d
+		}
+		return total
+	}
+
+	best := append([]*d2graph.Object{}, actors...)
+	bestCost := cost(actors)
+	order := append([]*d2graph.Object{}, actors...)
+	for pass := 0; pass < REORDER_PASSES; pass++ {
+		index := make(map[*d2graph.Object]int, len(order))
+		for i, actor := range order {
+			index[actor] = i
+		}
+		sum := make(map[*d2graph.Object]float64)
+		count := make(map[*d2graph.Object]float64)
+		for _, p := range pairs {
+			sum[p.src] += float64(index[p.dst])
+			count[p.src]++
+			sum[p.dst] += float64(index[p.src])
+			count[p.dst]++
+		}
+		barycenter := func(actor *d2graph.Object) float64 {
+			if count[actor] == 0 {
+				return float64(index[actor])
+			}
+			return sum[actor] / count[actor]
+		}
+
+		var free []*d2graph.Object
+		for _, actor := range order {
+			if !pinned[actor] {
+				free = append(free, actor)
+			}
+		}
+		sort.SliceStable(free, func(i, j int) bool {
+			return barycenter(free[i]) < barycenter(free[j])
+		})
+		next := make([]*d2graph.Object, 0, len(order))
+		for _, actor := range order {
+			if pinned[actor] {
+				next = append(next, actor)
+			} else {
+				next = append(next, free[0])
+				free = free[1:]
+			}
+		}
+		order = next
+
+		if c := cost(order); c < bestCost {
+			best = append([]*d2graph.Object{}, order...)
+			bestCost = c
+		}
+	}
+
+	// barycenters can swing between orders of the same length, swapping pairs of actors settles them
+	for improved := true; improved; {
+		improved = false
+		for i := range best {
+			for j := i + 1; j < len(best); j++ {
+				if pinned[best[i]] || pinned[best[j]] {
+					continue
+				}
+				best[i], best[j] = best[j], best[i]
+				if c := cost(best); c < bestCost {
+					bestCost = c
+					improved = true
+				} else {
+					best[i], best[j] = best[j], best[i]
+				}
+			}
+		}
+	}
+	return best
+}
@@ -0,0 +1,68 @@
+package d2sequence
+
+import (
+	"strconv"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// measureReturnValues measures the return values of the messages, with their padding, so that they count towards
+// the space between actors
+func (sd *sequenceDiagram) measureReturnValues() error {
+	for _, message := range sd.messages {
+		returnValue := sd.opts.messageOpts(message).ReturnValue
+		if returnValue == "" {
+			continue
+		}
+		dims, err := sd.measureText(&d2target.MText{
+			Text:     returnValue,
+			FontSize: d2fonts.FONT_SIZE_S,
+			IsItalic: true,
+		})
+		if err != nil {
+			return err
+		}
+		dims.Width += int(RETURN_VALUE_PADDING * 2)
+		dims.Height += int(RETURN_VALUE_PADDING * 2)
+		sd.returnValues[message] = dims
+	}
+	return nil
+}
+
+// placeReturnValues places the return value of each reply just above its arrowhead, on the side of the sender
+// so that it stays off the receiver lifeline and spans
+// . ┌───────┐                    ┌───────┐
+// . │ actor │                    │ actor │
+// . └───┬───┘                    └───┬───┘
+// .     │ ┌╌╌╌╌╌╌┐                   │
+// .     │ ╎200 OK╎                   │
+// .     │ └╌╌╌╌╌╌┘  label            │
+// .     ◄────────────────────────────┤
+func (sd *sequenceDiagram) placeReturnValues() {
+	for _, message := range sd.messages {
+		dims, has := sd.returnValues[message]
+		if !has {
+			continue
+		}
+		width, height := float64(dims.Width), float64(dims.Height)
+		end := message.Route[len(message.Route)-1]
+		prev := message.Route[len(message.Route)-2]
+		x := end.X + RETURN_VALUE_GAP
+		if prev.X < end.X {
+			x = end.X - RETURN_VALUE_GAP - width
+		}
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:  RETURN_VALUE_DECORATION,
+			Box:   geo.NewBox(geo.NewPoint(x, end.Y-RETURN_VALUE_GAP-height), width, height),
+			Label: sd.opts.messageOpts(message).ReturnValue,
+			Style: d2graph.Style{
+				StrokeDash: &d2graph.Scalar{Value: strconv.Itoa(MESSAGE_STROKE_DASH)},
+			},
+			Edge:   message,
+			ZIndex: LABEL_Z_INDEX,
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package d2sequence
+
+import (
+	"math"
+	"sort"
+
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// Reveal is when an animated renderer shows an element of a laid out sequence diagram, see Reveals
+type Reveal struct {
+	// Ordinal is the step the element appears at, from 1
+	Ordinal int
+	// Begin is when the element starts appearing, in the unit of the interval given to Reveals
+	Begin float64
+	// Duration is how long the element takes to appear
+	Duration float64
+}
+
+// Reveals returns when each message, span and note of a laid out sequence diagram appears for animated renderers,
+// by absolute ID, the one messages had before InferActivations moved them to spans like in MessageOpts.
+// Messages and notes appear one step each from top to bottom,
+// one every interval, and the ones drawn at the same height together. Spans appear with the first message in them
+func Reveals(g *d2graph.Graph, interval float64) map[string]Reveal {
+	tops := make(map[string]float64)
+	var spans []*d2graph.Object
+	for _, edge := range g.Edges {
+		if IsLifelineEnd(edge.Dst) || len(edge.Route) == 0 {
+			continue
+		}
+		top := math.Inf(1)
+		for _, p := range edge.Route {
+			top = math.Min(top, p.Y)
+		}
+		tops[declaredMessageID(g, edge)] = top
+	}
+	var walk func(obj *d2graph.Object)
+	walk = func(obj *d2graph.Object) {
+		for _, child := range obj.ChildrenArray {
+			if child.IsSequenceDiagramNote() {
+				tops[child.AbsID()] = child.TopLeft.Y
+			} else {
+				spans = append(spans, child)
+			}
+			walk(child)
+		}
+	}
+	for _, actor := range Actors(g) {
+		walk(actor)
+	}
+
+	var steps []float64
+	seen := make(map[float64]bool)
+	for _, top := range tops {
+		if !seen[top] {
+			seen[top] = true
+			steps = append(steps, top)
+		}
+	}
+	sort.Float64s(steps)
+
+	reveal := func(ordinal int) Reveal {
+		return Reveal{
+			Ordinal:  ordinal,
+			Begin:    float64(ordinal-1) * interval,
+			Duration: interval,
+		}
+	}
+	reveals := make(map[string]Reveal, len(tops)+len(spans))
+	for id, top := range tops {
+		reveals[id] = reveal(sort.SearchFloat64s(steps, top) + 1)
+	}
+	for _, span := range spans {
+		// the span starts a little above its first message
+		i := go2.IntMin(sort.SearchFloat64s(steps, span.TopLeft.Y), len(steps)-1)
+		reveals[span.AbsID()] = reveal(go2.IntMax(i, 0) + 1)
+	}
+	return reveals
+}
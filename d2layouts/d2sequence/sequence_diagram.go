@@ -1,23 +1,29 @@
 package d2sequence
 
 import (
-	"errors"
+	"context"
 	"fmt"
 	"math"
 	"sort"
 	"strconv"
 	"strings"
 
+	"cdr.dev/slog"
+
 	"oss.terrastruct.com/util-go/go2"
 
 	"oss.terrastruct.com/d2/d2graph"
 	"oss.terrastruct.com/d2/d2target"
 	"oss.terrastruct.com/d2/lib/geo"
 	"oss.terrastruct.com/d2/lib/label"
+	"oss.terrastruct.com/d2/lib/log"
 	"oss.terrastruct.com/d2/lib/shape"
+	"oss.terrastruct.com/d2/lib/textmeasure"
 )
 
 type sequenceDiagram struct {
+	opts *ConfigurableOpts
+
 	root      *d2graph.Object
 	messages  []*d2graph.Edge
 	lifelines []*d2graph.Edge
@@ -26,6 +32,39 @@ type sequenceDiagram struct {
 	spans     []*d2graph.Object
 	notes     []*d2graph.Object
 
+	decorations []*d2graph.Decoration
+	// frame around the whole diagram, if any
+	frame *d2graph.Decoration
+	// title and subtitle above the whole diagram, if any
+	titleBlock *geo.Box
+
+	actorGroups []*actorGroup
+	// space the actor group borders take around the actors
+	actorGroupInsets geo.Spacing
+
+	// messages drawn at the height of a previous message of their concurrency group
+	concurrent map[*d2graph.Edge]bool
+	// messages spaced from the previous message on purpose, e.g. by ConfigurableOpts.SpacingHook, and the previous
+	// message, compactVertical keeps the distance between them
+	spacedAfter map[*d2graph.Edge]*d2graph.Edge
+
+	// empty columns spaced like actors, see ActorOpts.PlaceholdersAfter
+	placeholders map[*d2graph.Object]bool
+
+	// measures the text added by the layout, see measureText
+	ruler *textmeasure.Ruler
+
+	// measured guards of the messages that have one, including the brackets
+	guards map[*d2graph.Edge]*d2target.TextDimensions
+	// measured return values of the messages that have one, see MessageOpts.ReturnValue
+	returnValues map[*d2graph.Edge]*d2target.TextDimensions
+	// measured stereotypes of the actors that have one, including the guillemets
+	stereotypes map[*d2graph.Object]*d2target.TextDimensions
+	// calls without a return by the span they open, when their stubs are drawn
+	unanswered map[*d2graph.Object]*d2graph.Edge
+	// size of the operator tabs of the groups that are fragments
+	fragmentTabs map[*d2graph.Object]*geo.Box
+
 	// can be either actors or spans
 	// rank: left to right position of actors/spans (spans have the same rank as their parents)
 	objectRank map[*d2graph.Object]int
@@ -38,7 +77,9 @@ type sequenceDiagram struct {
 	// every neighbor actors need different distances depending on the message labels between them
 	actorXStep []float64
 
-	yStep          float64
+	yStep float64
+	// yStep before VerticalScale, for the space above the first message and below the last one
+	baseYStep      float64
 	maxActorHeight float64
 
 	verticalIndices map[string]int
@@ -66,7 +107,7 @@ func getEdgeEarliestLineNum(e *d2graph.Edge) int {
 	return min
 }
 
-func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*sequenceDiagram, error) {
+func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge, opts *ConfigurableOpts) (*sequenceDiagram, error) {
 	var actors []*d2graph.Object
 	var groups []*d2graph.Object
 
@@ -87,10 +128,36 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 	}
 
 	if len(actors) == 0 {
-		return nil, errors.New("no actors declared in sequence diagram")
+		var root *d2graph.Object
+		if len(objects) > 0 {
+			root = objects[0].Parent
+		}
+		return nil, errorf(ACTORS_STAGE, root, "no actors declared in sequence diagram")
+	}
+
+	if opts.ReorderActors {
+		actors = reorderActors(actors, messages, opts)
+	}
+
+	// placeholders are laid out as actors that are not part of the graph so they are not drawn
+	placeholders := make(map[*d2graph.Object]bool)
+	var columns []*d2graph.Object
+	for _, actor := range actors {
+		columns = append(columns, actor)
+		for i := 0; i < opts.Actors[actor.AbsID()].PlaceholdersAfter; i++ {
+			placeholder := &d2graph.Object{
+				ID:     fmt.Sprintf("%s-placeholder-%d", actor.ID, i),
+				Parent: actor.Parent,
+			}
+			placeholder.Box = geo.NewBox(nil, MIN_ACTOR_WIDTH, 0)
+			placeholders[placeholder] = true
+			columns = append(columns, placeholder)
+		}
 	}
+	actors = columns
 
 	sd := &sequenceDiagram{
+		opts:            opts,
 		messages:        messages,
 		actors:          actors,
 		groups:          groups,
@@ -104,22 +171,77 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 		yStep:           MIN_MESSAGE_DISTANCE,
 		maxActorHeight:  0.,
 		verticalIndices: make(map[string]int),
+		guards:          make(map[*d2graph.Edge]*d2target.TextDimensions),
+		returnValues:    make(map[*d2graph.Edge]*d2target.TextDimensions),
+		stereotypes:     make(map[*d2graph.Object]*d2target.TextDimensions),
+		fragmentTabs:    make(map[*d2graph.Object]*geo.Box),
+		concurrent:      make(map[*d2graph.Edge]bool),
+		spacedAfter:     make(map[*d2graph.Edge]*d2graph.Edge),
+		placeholders:    placeholders,
+	}
+
+	// actors without dimensions get a default box instead of breaking the layout, images are sized to keep their
+	// aspect ratio and must have one
+	for _, actor := range actors {
+		isImage := strings.EqualFold(actor.Shape.Value, d2target.ShapeImage)
+		if isImage && actor.Box != nil && (actor.Width <= 0 || actor.Height <= 0) {
+			return nil, errorf(VALIDATE_STAGE, actor, "image %s must have a positive width and height, got %vx%v", actor.AbsID(), actor.Width, actor.Height)
+		}
+		actor.EnsureBox(d2graph.DEFAULT_SHAPE_SIZE, d2graph.DEFAULT_SHAPE_SIZE)
+	}
+
+	// with UniformActorWidth, every header is as wide as the widest one
+	uniformWidth := 0.
+	if opts.UniformActorWidth {
+		for _, actor := range actors {
+			if placeholders[actor] {
+				continue
+			}
+			width := math.Max(actor.Width, MIN_ACTOR_WIDTH)
+			if w := sd.actorOpts(actor).Width; w > 0 {
+				width = w
+			}
+			uniformWidth = math.Max(uniformWidth, width)
+		}
 	}
 
 	for rank, actor := range actors {
 		sd.root = actor.Parent
 		sd.objectRank[actor] = rank
 
-		if actor.Width < MIN_ACTOR_WIDTH {
+		width := actor.Width
+		if uniformWidth > 0 && !placeholders[actor] {
+			width = uniformWidth
+		} else if w := sd.actorOpts(actor).Width; w > 0 {
+			width = w
+		} else if actor.Width < MIN_ACTOR_WIDTH {
+			width = MIN_ACTOR_WIDTH
+		}
+		if width != actor.Width && actor.Width > 0 {
 			dslShape := strings.ToLower(actor.Shape.Value)
 			switch dslShape {
 			case d2target.ShapePerson, d2target.ShapeOval, d2target.ShapeSquare, d2target.ShapeCircle:
-				// scale shape up to min width uniformly
-				actor.Height *= MIN_ACTOR_WIDTH / actor.Width
+				// scale shape to its width uniformly
+				actor.Height *= width / actor.Width
+			case d2target.ShapeImage:
+				// images keep their aspect ratio, fit within a narrower width or scaled up to a wider one
+				if width < actor.Width {
+					actor.Box = actor.Box.FitWithin(width, actor.Height)
+				} else {
+					actor.Box = actor.Box.ScaleToWidth(width)
+				}
 			}
-			actor.Width = MIN_ACTOR_WIDTH
 		}
+		actor.Width = width
+		if err := sd.measureStereotype(actor); err != nil {
+			return nil, err
+		}
+		sd.applyActorBorder(actor)
 		sd.maxActorHeight = math.Max(sd.maxActorHeight, actor.Height)
+		if stereotype, has := sd.stereotypes[actor]; has && actor.HasOutsideBottomLabel() {
+			// the stereotype is above the shape when the label is below it
+			sd.maxActorHeight = math.Max(sd.maxActorHeight, actor.Height+float64(stereotype.Height))
+		}
 
 		queue := make([]*d2graph.Object, len(actor.ChildrenArray))
 		copy(queue, actor.ChildrenArray)
@@ -132,7 +254,19 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 			// edge groups are children of actors with no edges and children edges
 			if child.IsSequenceDiagramNote() {
 				sd.verticalIndices[child.AbsID()] = getObjEarliestLineNum(child)
-				child.Shape = d2graph.Scalar{Value: shape.PAGE_TYPE}
+				if sd.opts.Notes[child.AbsID()].State {
+					child.Box = geo.NewBox(nil,
+						float64(child.LabelDimensions.Width)+STATE_PADDING*2,
+						float64(child.LabelDimensions.Height)+STATE_PADDING*2,
+					)
+					child.Shape = d2graph.Scalar{Value: shape.SQUARE_TYPE}
+					if child.Style.BorderRadius == nil {
+						child.Style.BorderRadius = &d2graph.Scalar{Value: fmt.Sprintf("%d", STATE_BORDER_RADIUS)}
+					}
+				} else {
+					child.EnsureBox(d2graph.DEFAULT_SHAPE_SIZE, d2graph.DEFAULT_SHAPE_SIZE)
+					child.Shape = d2graph.Scalar{Value: shape.PAGE_TYPE}
+				}
 				sd.notes = append(sd.notes, child)
 				sd.objectRank[child] = rank
 				child.LabelPosition = go2.Pointer(label.InsideMiddleCenter.String())
@@ -142,6 +276,11 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 				// TODO why not? Spans should be able to
 				child.Label = d2graph.Scalar{Value: ""}
 				child.Shape = d2graph.Scalar{Value: shape.SQUARE_TYPE}
+				// messages are routed to the actor lifeline until the span is placed,
+				// not to where a previous layout left it
+				if child.Box != nil {
+					child.TopLeft = nil
+				}
 				sd.spans = append(sd.spans, child)
 				sd.objectRank[child] = rank
 			}
@@ -160,17 +299,57 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 		}
 	}
 
+	if err := sd.initActorGroups(); err != nil {
+		return nil, err
+	}
+	if sd.opts.ReplyLabelPrefix {
+		if err := sd.prefixReplyLabels(); err != nil {
+			return nil, err
+		}
+	}
+	if sd.opts.TruncateLabels > 0 {
+		if err := sd.truncateLabels(); err != nil {
+			return nil, err
+		}
+	}
+	if err := sd.measureGuards(); err != nil {
+		return nil, err
+	}
+	if err := sd.measureReturnValues(); err != nil {
+		return nil, err
+	}
+	if err := sd.measureFragmentTabs(); err != nil {
+		return nil, err
+	}
+
+	concurrencyGroups := make(map[string]bool)
 	for _, message := range sd.messages {
 		sd.verticalIndices[message.AbsID()] = getEdgeEarliestLineNum(message)
+		if group := sd.opts.messageOpts(message).concurrencyGroup(); group != "" {
+			sd.concurrent[message] = concurrencyGroups[group]
+			concurrencyGroups[group] = true
+		}
 		// TODO this should not be global yStep, only affect the neighbors
 		sd.yStep = math.Max(sd.yStep, float64(message.LabelDimensions.Height))
+		labelWidth := float64(message.LabelDimensions.Width) + sd.labelIconWidth(message)
+		if returnValue, has := sd.returnValues[message]; has {
+			// the label is centered and the return value at the end, the message fits it on both sides of the label
+			labelWidth += (float64(returnValue.Width) + RETURN_VALUE_GAP) * 2
+		}
+		if guard, has := sd.guards[message]; has {
+			sd.yStep = math.Max(sd.yStep, float64(guard.Height))
+			labelWidth += float64(guard.Width)
+			if message.Label.Value != "" {
+				labelWidth += GUARD_LABEL_GAP
+			}
+		}
 
 		// ensures that long labels, spanning over multiple actors, don't make for large gaps between actors
 		// by distributing the label length across the actors rank difference
 		rankDiff := math.Abs(float64(sd.objectRank[message.Src]) - float64(sd.objectRank[message.Dst]))
 		if rankDiff != 0 {
 			// rankDiff = 0 for self edges
-			distributedLabelWidth := float64(message.LabelDimensions.Width) / rankDiff
+			distributedLabelWidth := labelWidth / rankDiff
 			for rank := go2.IntMin(sd.objectRank[message.Src], sd.objectRank[message.Dst]); rank <= go2.IntMax(sd.objectRank[message.Src], sd.objectRank[message.Dst])-1; rank++ {
 				sd.actorXStep[rank] = math.Max(sd.actorXStep[rank], distributedLabelWidth+HORIZONTAL_PAD)
 			}
@@ -185,7 +364,22 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 		}
 	}
 
+	for rank, actor := range sd.actors[:len(sd.actors)-1] {
+		sd.actorXStep[rank] = math.Max(sd.actorXStep[rank], sd.actorOpts(actor).MinNextDistance)
+	}
+	if opts.UniformActorWidth {
+		// evenly spaced, as far apart as the actors that need the most space
+		maxStep := 0.
+		for _, step := range sd.actorXStep {
+			maxStep = math.Max(maxStep, step)
+		}
+		for rank := range sd.actorXStep {
+			sd.actorXStep[rank] = maxStep
+		}
+	}
+
 	sd.yStep += VERTICAL_PAD
+	sd.baseYStep = sd.yStep
 	sd.maxActorHeight += VERTICAL_PAD
 	if sd.root.HasLabel() {
 		sd.maxActorHeight += float64(sd.root.LabelDimensions.Height)
@@ -194,16 +388,91 @@ func newSequenceDiagram(objects []*d2graph.Object, messages []*d2graph.Edge) (*s
 	return sd, nil
 }
 
+// scaleYStep scales the distance between messages, the header gap and the end of the lifelines keep baseYStep
+// the scale is clamped so the distance never gets smaller than the tallest label or self message
+func (sd *sequenceDiagram) scaleYStep(ctx context.Context, scale float64) {
+	minYStep := sd.yStep - VERTICAL_PAD
+	for _, message := range sd.messages {
+		if sd.objectRank[message.Src] == sd.objectRank[message.Dst] {
+			minYStep = math.Max(minYStep, SELF_MESSAGE_HEIGHT)
+			break
+		}
+	}
+	if sd.yStep*scale < minYStep {
+		clamped := minYStep / sd.yStep
+		log.Warn(ctx, "sequence diagram vertical scale too small, messages would overlap", slog.F("scale", scale), slog.F("clamped", clamped))
+		scale = clamped
+	}
+	sd.yStep *= scale
+}
+
 func (sd *sequenceDiagram) layout() error {
 	sd.placeActors()
+	sd.placeStereotypes()
+	sd.placeActorGroups()
 	sd.placeNotes()
 	if err := sd.routeMessages(); err != nil {
 		return err
 	}
+	if sd.opts.CompactVertical {
+		sd.compactVertical()
+	}
+	if err := sd.checkEntries(); err != nil {
+		return err
+	}
 	sd.placeSpans()
+	if err := sd.alignSharedSpans(); err != nil {
+		return err
+	}
+	if sd.opts.MergeActivations {
+		sd.mergeActivations()
+	}
 	sd.adjustRouteEndpoints()
 	sd.placeGroups()
+	sd.placeFragmentTabs()
+	sd.placeLostReturns()
+	if err := sd.placeCoregions(); err != nil {
+		return err
+	}
+	sd.placeGates()
+	if err := sd.placeActorGroupMessages(); err != nil {
+		return err
+	}
 	sd.addLifelineEdges()
+	sd.placeDividers()
+	if err := sd.checkActivations(); err != nil {
+		return err
+	}
+	if sd.opts.ActivationStyle == ActivationStyleLane {
+		sd.placeLanes()
+	}
+	if err := sd.placeAnchors(); err != nil {
+		return err
+	}
+	// the decorations next to the labels follow them on the arcs
+	if sd.opts.Curvature != 0 {
+		sd.curveMessages()
+	}
+	sd.placeGuards()
+	sd.placeLabelIcons()
+	sd.placeReturnValues()
+	if sd.opts.LabelHalos {
+		sd.placeHalos()
+	}
+	if err := sd.placeDurationBrackets(); err != nil {
+		return err
+	}
+	if sd.opts.BackgroundBands {
+		sd.placeBands()
+	}
+	if sd.opts.FrameTitle != "" {
+		if err := sd.placeFrame(); err != nil {
+			return err
+		}
+	}
+	if sd.opts.Title != "" {
+		return sd.placeTitle()
+	}
 	return nil
 }
 
@@ -218,6 +487,7 @@ func (sd *sequenceDiagram) placeGroups() {
 	for _, group := range sd.groups {
 		sd.adjustGroupLabel(group)
 	}
+	sd.separateStackedGroups()
 }
 
 func (sd *sequenceDiagram) placeGroup(group *d2graph.Object) {
@@ -228,7 +498,12 @@ func (sd *sequenceDiagram) placeGroup(group *d2graph.Object) {
 
 	for _, m := range sd.messages {
 		if m.ContainedBy(group) {
-			for _, p := range m.Route {
+			route := m.Route
+			if sd.isGate(m) {
+				// the sending end is outside of the group
+				route = route[1:]
+			}
+			for _, p := range route {
 				minX = math.Min(minX, p.X-HORIZONTAL_PAD)
 				minY = math.Min(minY, p.Y-MIN_MESSAGE_DISTANCE/2.)
 				maxX = math.Max(maxX, p.X+HORIZONTAL_PAD)
@@ -283,11 +558,18 @@ func (sd *sequenceDiagram) placeGroup(group *d2graph.Object) {
 }
 
 func (sd *sequenceDiagram) adjustGroupLabel(group *d2graph.Object) {
-	if !group.HasLabel() {
+	labelHeight := 0
+	if group.HasLabel() {
+		labelHeight = group.LabelDimensions.Height
+	}
+	if tab, has := sd.fragmentTabs[group]; has {
+		labelHeight = go2.IntMax(labelHeight, int(math.Ceil(tab.Height)))
+	}
+	if labelHeight == 0 {
 		return
 	}
 
-	heightAdd := (group.LabelDimensions.Height + EDGE_GROUP_LABEL_PADDING) - GROUP_CONTAINER_PADDING
+	heightAdd := (labelHeight + EDGE_GROUP_LABEL_PADDING) - GROUP_CONTAINER_PADDING
 	if heightAdd < 0 {
 		return
 	}
@@ -332,9 +614,23 @@ func (sd *sequenceDiagram) adjustGroupLabel(group *d2graph.Object) {
 
 }
 
+// applyActorBorder sets the border of the actor header from its ActorOpts. The radius is clamped to half the smaller
+// side like renderers do with rounded rectangles, so the bottom center where the lifeline attaches is always
+// on the border and not in the void of a rounded corner
+func (sd *sequenceDiagram) applyActorBorder(actor *d2graph.Object) {
+	opts := sd.actorOpts(actor)
+	if opts.BorderRadius != nil {
+		radius := go2.IntMin(*opts.BorderRadius, int(math.Min(actor.Width, actor.Height)/2.))
+		actor.Style.BorderRadius = &d2graph.Scalar{Value: strconv.Itoa(go2.IntMax(radius, 0))}
+	}
+	if opts.BorderWidth != nil {
+		actor.Style.StrokeWidth = &d2graph.Scalar{Value: strconv.Itoa(*opts.BorderWidth)}
+	}
+}
+
 // placeActors places actors bottom aligned, side by side with centers spaced by sd.actorXStep
 func (sd *sequenceDiagram) placeActors() {
-	centerX := sd.actors[0].Width / 2.
+	centerX := sd.actorGroupInsets.Left + sd.actors[0].Width/2.
 	for rank, actor := range sd.actors {
 		var yOffset float64
 		if actor.HasOutsideBottomLabel() {
@@ -347,7 +643,13 @@ func (sd *sequenceDiagram) placeActors() {
 			actor.LabelPosition = go2.Pointer(label.InsideMiddleCenter.String())
 			yOffset = sd.maxActorHeight - actor.Height
 		}
+		if entryY := sd.actorOpts(actor).EntryY; entryY != nil {
+			yOffset = *entryY
+		}
 		halfWidth := actor.Width / 2.
+		if offset, has := sd.opts.actorOffsets[actor.AbsID()]; has && rank > 0 {
+			centerX = sd.actors[0].Center().X + offset
+		}
 		actor.TopLeft = geo.NewPoint(math.Round(centerX-halfWidth), yOffset)
 		if rank != len(sd.actors)-1 {
 			centerX += sd.actorXStep[rank]
@@ -377,20 +679,43 @@ func (sd *sequenceDiagram) addLifelineEdges() {
 	for _, actor := range sd.actors {
 		endY = math.Max(endY, actor.TopLeft.Y+actor.Height)
 	}
-	endY += sd.yStep
+	endY += sd.baseYStep
 
 	for _, actor := range sd.actors {
+		if sd.placeholders[actor] {
+			continue
+		}
+		actorEndY := endY
+		actorOpts := sd.actorOpts(actor)
+		if actorOpts.LifelineEndY != nil {
+			actorEndY = *actorOpts.LifelineEndY
+		} else if i := actorOpts.LifelineEndMessage; i != nil && *i >= 0 && *i < len(sd.messages) {
+			actorEndY = math.Inf(-1)
+			for _, p := range sd.messages[*i].Route {
+				actorEndY = math.Max(actorEndY, p.Y)
+			}
+			actorEndY += sd.baseYStep
+		}
+
 		actorBottom := actor.Center()
 		actorBottom.Y = actor.TopLeft.Y + actor.Height
 		if *actor.LabelPosition == label.OutsideBottomCenter.String() && actor.HasLabel() {
 			actorBottom.Y += float64(actor.LabelDimensions.Height) + LIFELINE_LABEL_PAD
+		} else if borderWidth := actorOpts.BorderWidth; borderWidth != nil {
+			// the stroke is centered on the border
+			actorBottom.Y += float64(*borderWidth) / 2.
 		}
 		actorLifelineEnd := actor.Center()
-		actorLifelineEnd.Y = endY
+		actorLifelineEnd.Y = math.Max(actorEndY, actorBottom.Y)
 		style := d2graph.Style{
 			StrokeDash:  &d2graph.Scalar{Value: fmt.Sprintf("%d", LIFELINE_STROKE_DASH)},
 			StrokeWidth: &d2graph.Scalar{Value: fmt.Sprintf("%d", LIFELINE_STROKE_WIDTH)},
 		}
+		var classes []string
+		if actorOpts.External {
+			style.StrokeDash.Value = strconv.Itoa(EXTERNAL_LIFELINE_STROKE_DASH)
+			classes = append(classes, EXTERNAL_CLASS)
+		}
 		if actor.Style.StrokeDash != nil {
 			style.StrokeDash = &d2graph.Scalar{Value: actor.Style.StrokeDash.Value}
 		}
@@ -399,7 +724,7 @@ func (sd *sequenceDiagram) addLifelineEdges() {
 		}
 
 		sd.lifelines = append(sd.lifelines, &d2graph.Edge{
-			Attributes: d2graph.Attributes{Style: style},
+			Attributes: d2graph.Attributes{Style: style, Classes: classes},
 			Src:        actor,
 			SrcArrow:   false,
 			Dst: &d2graph.Object{
@@ -439,10 +764,10 @@ func (sd *sequenceDiagram) placeNotes() {
 
 	for _, note := range sd.notes {
 		verticalIndex := sd.verticalIndices[note.AbsID()]
-		y := sd.maxActorHeight + sd.yStep
+		y := sd.maxActorHeight + sd.headerGap()
 
 		for _, msg := range sd.messages {
-			if sd.verticalIndices[msg.AbsID()] < verticalIndex {
+			if sd.verticalIndices[msg.AbsID()] < verticalIndex && !sd.concurrent[msg] {
 				y += sd.yStep
 			}
 		}
@@ -516,20 +841,63 @@ func (sd *sequenceDiagram) placeSpans() {
 
 		// if it is the same as the child top left, add some padding
 		minY := math.Min(minMessageY, minChildY)
-		if minY == minChildY || minY == minMessageY {
+		// with SpanEndAnchors, the opening and closing messages connect to the span top and bottom without padding
+		anchorTop := sd.opts.SpanEndAnchors && minMessageY < minChildY
+		if leadIn := sd.spanOpts(span).LeadIn; leadIn > 0 {
+			minY -= leadIn
+		} else if !anchorTop && (minY == minChildY || minY == minMessageY) {
 			minY -= SPAN_MESSAGE_PAD
 		}
 		maxY := math.Max(maxMessageY, maxChildY)
-		if maxY == maxChildY || maxY == maxMessageY {
+		anchorBottom := sd.opts.SpanEndAnchors && maxMessageY > maxChildY && maxMessageY-minY >= MIN_SPAN_HEIGHT
+		if !anchorBottom && (maxY == maxChildY || maxY == maxMessageY) {
 			maxY += SPAN_MESSAGE_PAD
 		}
 
 		height := math.Max(maxY-minY, MIN_SPAN_HEIGHT)
+		baseWidth := SPAN_BASE_WIDTH
+		if sd.opts.ActivationStyle == ActivationStyleInline {
+			// inline spans are a short thickening of the lifeline where they are activated
+			// instead of a box over all their messages
+			centerY := minMessageY
+			if math.IsInf(centerY, 1) {
+				centerY = minChildY + INLINE_SPAN_HEIGHT/2.
+			}
+			minY = centerY - INLINE_SPAN_HEIGHT/2.
+			height = INLINE_SPAN_HEIGHT
+			baseWidth = INLINE_SPAN_WIDTH
+		}
 		// -1 because the actors count as 1 level
-		width := SPAN_BASE_WIDTH + (float64(span.Level()-sd.root.Level()-2) * SPAN_DEPTH_GROWTH_FACTOR)
-		x := rankToX[sd.objectRank[span]] - (width / 2.)
+		width := baseWidth + (float64(span.Level()-sd.root.Level()-2) * SPAN_DEPTH_GROWTH_FACTOR)
+		if sd.spanOffset(span) != 0 {
+			width = baseWidth
+		}
+		if sd.opts.ActivationStyle == ActivationStyleLane {
+			// the lane is drawn instead, messages connect to the lifeline
+			width = 0
+			if span.Style.Opacity == nil {
+				span.Style.Opacity = &d2graph.Scalar{Value: "0"}
+			}
+		}
+		x := rankToX[sd.objectRank[span]] + sd.spanOffset(span) - (width / 2.)
 		span.Box = geo.NewBox(geo.NewPoint(x, minY), width, height)
 		span.ZIndex = SPAN_Z_INDEX
+		if borderRadius := sd.spanOpts(span).BorderRadius; borderRadius != nil {
+			span.Style.BorderRadius = &d2graph.Scalar{Value: strconv.Itoa(*borderRadius)}
+		}
+		if sd.spanOpts(span).Suspended {
+			if span.Style.FillPattern == nil {
+				span.Style.FillPattern = &d2graph.Scalar{Value: SUSPENDED_FILL_PATTERN}
+			}
+			if !go2.Contains(span.Classes, SUSPENDED_CLASS) {
+				span.Classes = append(span.Classes, SUSPENDED_CLASS)
+			}
+		}
+		if sd.opts.SpanColorFromMessage && span.Style.Fill == nil {
+			if opening, exists := sd.firstMessage[span]; exists && opening.Dst == span && opening.Style.Stroke != nil {
+				span.Style.Fill = &d2graph.Scalar{Value: opening.Style.Stroke.Value}
+			}
+		}
 	}
 }
 
@@ -538,7 +906,11 @@ func (sd *sequenceDiagram) placeSpans() {
 func (sd *sequenceDiagram) routeMessages() error {
 	var prevIsLoop bool
 	var prevGroup *d2graph.Object
-	messageOffset := sd.maxActorHeight + sd.yStep
+	var prevMessage *d2graph.Edge
+	// the top and bottom of the previous message, without the notes above it
+	var prevTop, prevBottom float64
+	messageOffset := sd.maxActorHeight + sd.headerGap()
+	concurrencyStartY := make(map[string]float64)
 	for _, message := range sd.messages {
 		message.ZIndex = MESSAGE_Z_INDEX
 		noteOffset := 0.
@@ -548,25 +920,54 @@ func (sd *sequenceDiagram) routeMessages() error {
 			}
 		}
 
-		// we need extra space if the previous message is a loop in a different group
-		group := message.GetGroup()
-		if prevIsLoop && prevGroup != group {
-			messageOffset += MIN_MESSAGE_DISTANCE
-		}
-		prevGroup = group
+		var startY float64
+		concurrencyGroup := sd.opts.messageOpts(message).concurrencyGroup()
+		if sd.concurrent[message] {
+			startY = concurrencyStartY[concurrencyGroup]
+		} else {
+			// we need extra space if the previous message is a loop in a different group
+			group := message.GetGroup()
+			if prevIsLoop && prevGroup != group {
+				messageOffset += MIN_MESSAGE_DISTANCE
+			}
+			prevGroup = group
+			if prevMessage != nil {
+				if spacing := sd.groupSpacing(prevMessage, message); spacing > 0 {
+					messageOffset += sd.yStep * (spacing - 1)
+					sd.spacedAfter[message] = prevMessage
+				}
+			}
+			if prevMessage != nil && sd.opts.TimeScale > 0 {
+				prevTime := sd.opts.messageOpts(prevMessage).Timestamp
+				time := sd.opts.messageOpts(message).Timestamp
+				if prevTime != nil && time != nil {
+					messageOffset = math.Max(prevTop+(*time-*prevTime)*sd.opts.TimeScale, prevBottom+MIN_MESSAGE_DISTANCE)
+					sd.spacedAfter[message] = prevMessage
+				}
+			}
+			if prevMessage != nil && sd.opts.SpacingHook != nil {
+				if gap := sd.opts.SpacingHook(prevMessage, message); gap > 0 {
+					messageOffset = prevBottom + gap
+					sd.spacedAfter[message] = prevMessage
+				}
+			}
 
-		startY := messageOffset + noteOffset
+			startY = messageOffset + noteOffset
+			if concurrencyGroup != "" {
+				concurrencyStartY[concurrencyGroup] = startY
+			}
+		}
 
 		var startX, endX float64
 		if startCenter := getCenter(message.Src); startCenter != nil {
 			startX = startCenter.X
 		} else {
-			return fmt.Errorf("could not find center of %s. Is it declared as an actor?", message.Src.ID)
+			return errorf(ROUTE_STAGE, message.Src, "could not find center of %s. Is it declared as an actor?", message.Src.ID)
 		}
 		if endCenter := getCenter(message.Dst); endCenter != nil {
 			endX = endCenter.X
 		} else {
-			return fmt.Errorf("could not find center of %s. Is it declared as an actor?", message.Dst.ID)
+			return errorf(ROUTE_STAGE, message.Dst, "could not find center of %s. Is it declared as an actor?", message.Dst.ID)
 		}
 		isToDescendant := strings.HasPrefix(message.Dst.AbsID(), message.Src.AbsID()+".")
 		isFromDescendant := strings.HasPrefix(message.Src.AbsID(), message.Dst.AbsID()+".")
@@ -584,7 +985,11 @@ func (sd *sequenceDiagram) routeMessages() error {
 
 		if isSelfMessage || isToDescendant || isFromDescendant || isToSibling {
 			midX := startX + SELF_MESSAGE_HORIZONTAL_TRAVEL
-			endY := startY + MIN_MESSAGE_DISTANCE*1.5
+			loopHeight := SELF_MESSAGE_HEIGHT
+			if h := sd.opts.messageOpts(message).SelfMessageHeight; h > 0 {
+				loopHeight = h
+			}
+			endY := startY + loopHeight
 			message.Route = []*geo.Point{
 				geo.NewPoint(startX, startY),
 				geo.NewPoint(midX, startY),
@@ -592,6 +997,10 @@ func (sd *sequenceDiagram) routeMessages() error {
 				geo.NewPoint(endX, endY),
 			}
 			prevIsLoop = true
+			if !sd.concurrent[message] {
+				// taller loops push the next messages down, the default height already fits in yStep
+				messageOffset += math.Max(0, loopHeight-SELF_MESSAGE_HEIGHT)
+			}
 		} else {
 			message.Route = []*geo.Point{
 				geo.NewPoint(startX, startY),
@@ -599,7 +1008,12 @@ func (sd *sequenceDiagram) routeMessages() error {
 			}
 			prevIsLoop = false
 		}
-		messageOffset += sd.yStep
+		if !sd.concurrent[message] {
+			messageOffset += sd.yStep
+		}
+		prevMessage = message
+		prevTop = startY - noteOffset
+		prevBottom = message.Route[len(message.Route)-1].Y - noteOffset
 
 		if message.Label.Value != "" {
 			message.LabelPosition = go2.Pointer(label.InsideMiddleCenter.String())
@@ -608,6 +1022,34 @@ func (sd *sequenceDiagram) routeMessages() error {
 	return nil
 }
 
+// checkEntries checks that no message is above the header of an actor with an ActorOpts.EntryY
+func (sd *sequenceDiagram) checkEntries() error {
+	for _, message := range sd.messages {
+		for _, actor := range []*d2graph.Object{message.Src, message.Dst} {
+			for !actor.Parent.IsSequenceDiagram() {
+				actor = actor.Parent
+			}
+			if sd.actorOpts(actor).EntryY != nil && message.Route[0].Y < actor.TopLeft.Y+actor.Height {
+				return edgeErrorf(ROUTE_STAGE, message, "message %s is above the header of %s which enters the diagram later", message.AbsID(), actor.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// actorOf is the actor an object is on, the object itself for actors
+func actorOf(obj *d2graph.Object) *d2graph.Object {
+	for obj.Parent != nil && !obj.Parent.IsSequenceDiagram() {
+		obj = obj.Parent
+	}
+	return obj
+}
+
+// isSelfMessage tells if a message goes from an actor back to itself, its spans included, drawn as a loop
+func isSelfMessage(message *d2graph.Edge) bool {
+	return actorOf(message.Src) == actorOf(message.Dst)
+}
+
 func getCenter(obj *d2graph.Object) *geo.Point {
 	if obj == nil {
 		return nil
@@ -624,6 +1066,7 @@ func (sd *sequenceDiagram) adjustRouteEndpoints() {
 	for _, message := range sd.messages {
 		route := message.Route
 		if !sd.isActor(message.Src) {
+			route[0].X += sd.spanOffset(message.Src)
 			if sd.objectRank[message.Src] <= sd.objectRank[message.Dst] {
 				route[0].X += message.Src.Width / 2.
 			} else {
@@ -631,6 +1074,7 @@ func (sd *sequenceDiagram) adjustRouteEndpoints() {
 			}
 		}
 		if !sd.isActor(message.Dst) {
+			route[len(route)-1].X += sd.spanOffset(message.Dst)
 			if sd.objectRank[message.Src] < sd.objectRank[message.Dst] {
 				route[len(route)-1].X -= message.Dst.Width / 2.
 			} else {
@@ -640,18 +1084,102 @@ func (sd *sequenceDiagram) adjustRouteEndpoints() {
 	}
 }
 
+// spanOffset is how far the center of a span is from its actor lifeline, by the NestingDirection of the actor
+func (sd *sequenceDiagram) spanOffset(span *d2graph.Object) float64 {
+	actor := span
+	for !sd.isActor(actor) {
+		actor = actor.Parent
+	}
+	depth := float64(span.Level() - sd.root.Level() - 2)
+	switch sd.actorOpts(actor).NestingDirection {
+	case NestingRight:
+		return depth * SPAN_NESTING_OFFSET
+	case NestingLeft:
+		return -depth * SPAN_NESTING_OFFSET
+	}
+	return 0
+}
+
 func (sd *sequenceDiagram) isActor(obj *d2graph.Object) bool {
 	return obj.Parent == sd.root
 }
 
 func (sd *sequenceDiagram) getWidth() float64 {
-	// the layout is always placed starting at 0, so the width is just the last actor
+	// the layout is always placed starting at 0, so the width is the last actor
+	// or whatever goes further right of it, like self messages of the last actor and their groups
 	lastActor := sd.actors[len(sd.actors)-1]
-	return lastActor.TopLeft.X + lastActor.Width
+	width := lastActor.TopLeft.X + lastActor.Width + sd.actorGroupInsets.Right
+	for _, message := range sd.messages {
+		for _, p := range message.Route {
+			width = math.Max(width, p.X)
+		}
+		if labelBox := edgeLabelBox(message); labelBox != nil {
+			width = math.Max(width, labelBox.TopLeft.X+labelBox.Width)
+		}
+	}
+	for _, obj := range append(append([]*d2graph.Object{}, sd.groups...), sd.notes...) {
+		width = math.Max(width, obj.TopLeft.X+obj.Width)
+	}
+	for _, d := range sd.decorations {
+		if d.Kind == DURATION_BRACKET_DECORATION {
+			width = math.Max(width, d.TopLeft.X+d.Width)
+		}
+	}
+	return width
 }
 
 func (sd *sequenceDiagram) getHeight() float64 {
-	return sd.lifelines[0].Route[1].Y
+	// lifelines may end at different heights
+	height := 0.
+	for _, lifeline := range sd.lifelines {
+		height = math.Max(height, lifeline.Route[1].Y)
+	}
+	return height
+}
+
+func (sd *sequenceDiagram) spanOpts(span *d2graph.Object) SpanOpts {
+	return sd.opts.Spans[span.AbsID()]
+}
+
+// measureText measures text the layout adds to the diagram, with the ruler from the options or a new one
+func (sd *sequenceDiagram) measureText(mtext *d2target.MText) (*d2target.TextDimensions, error) {
+	if sd.ruler == nil {
+		sd.ruler = sd.opts.Ruler
+	}
+	if sd.ruler == nil {
+		ruler, err := textmeasure.NewRuler()
+		if err != nil {
+			return nil, errorf(MEASURE_STAGE, nil, "failed to create ruler: %v", err)
+		}
+		sd.ruler = ruler
+	}
+	return d2graph.GetTextDimensions(nil, sd.ruler, mtext, nil), nil
+}
+
+// headerGap is the distance from the bottom of the actor headers to the first message or note
+func (sd *sequenceDiagram) headerGap() float64 {
+	if sd.opts.HeaderGap != nil {
+		return *sd.opts.HeaderGap
+	}
+	return sd.baseYStep
+}
+
+// getBounds returns the box the sequence diagram takes, from (0, 0) unless it is framed or titled
+func (sd *sequenceDiagram) getBounds() *geo.Box {
+	bounds := geo.NewBox(geo.NewPoint(0, 0), sd.getWidth(), sd.getHeight())
+	if sd.frame != nil {
+		bounds = sd.frame.Box.Copy()
+	}
+	if sd.titleBlock != nil {
+		tl := geo.NewPoint(math.Min(bounds.TopLeft.X, sd.titleBlock.TopLeft.X), sd.titleBlock.TopLeft.Y)
+		right := math.Max(bounds.TopLeft.X+bounds.Width, sd.titleBlock.TopLeft.X+sd.titleBlock.Width)
+		bounds = geo.NewBox(tl, right-tl.X, bounds.TopLeft.Y+bounds.Height-tl.Y)
+	}
+	return bounds
+}
+
+func (sd *sequenceDiagram) actorOpts(actor *d2graph.Object) ActorOpts {
+	return sd.opts.Actors[actor.AbsID()]
 }
 
 func (sd *sequenceDiagram) shift(tl *geo.Point) {
@@ -672,4 +1200,8 @@ func (sd *sequenceDiagram) shift(tl *geo.Point) {
 			p.Y += tl.Y
 		}
 	}
+
+	for _, d := range sd.decorations {
+		d.Move(tl.X, tl.Y)
+	}
 }
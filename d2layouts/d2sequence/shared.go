@@ -0,0 +1,75 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// alignSharedSpans stretches spans shared between actors to the same top and bottom, growing the spans they are in,
+// and connects them when asked
+// . ┌───┐       ┌───┐
+// . │ a │       │ b │
+// . └─┬─┘       └─┬─┘
+// .  ┌┴┐         ┌┴┐
+// .  │ ├─────────┤ │
+// .  │ │ shared  │ │
+// .  │ ├─────────┤ │
+// .  └┬┘         └┬┘
+func (sd *sequenceDiagram) alignSharedSpans() error {
+	if sd.opts.ActivationStyle == ActivationStyleInline || sd.opts.ActivationStyle == ActivationStyleLane {
+		return nil
+	}
+	spans := make(map[string]*d2graph.Object, len(sd.spans))
+	for _, span := range sd.spans {
+		spans[span.AbsID()] = span
+	}
+	// spans shared with each other both ways are connected once
+	connected := make(map[[2]*d2graph.Object]bool)
+	for _, span := range sd.spans {
+		spanOpts := sd.spanOpts(span)
+		if spanOpts.SharedWith == "" {
+			continue
+		}
+		other, has := spans[spanOpts.SharedWith]
+		if !has {
+			return errorf(VALIDATE_STAGE, span, "span %s is shared with %#v which is not a span", span.AbsID(), spanOpts.SharedWith)
+		}
+		if sd.objectRank[other] == sd.objectRank[span] {
+			return errorf(VALIDATE_STAGE, span, "span %s is shared with %s of the same actor", span.AbsID(), other.AbsID())
+		}
+
+		top := math.Min(span.TopLeft.Y, other.TopLeft.Y)
+		bottom := math.Max(span.TopLeft.Y+span.Height, other.TopLeft.Y+other.Height)
+		for _, s := range []*d2graph.Object{span, other} {
+			s.TopLeft.Y = top
+			s.Height = bottom - top
+			// the spans it is in must still contain it
+			for parent := s.Parent; parent.Parent != sd.root; parent = parent.Parent {
+				parentBottom := math.Max(parent.TopLeft.Y+parent.Height, bottom+SPAN_MESSAGE_PAD)
+				parent.TopLeft.Y = math.Min(parent.TopLeft.Y, top-SPAN_MESSAGE_PAD)
+				parent.Height = parentBottom - parent.TopLeft.Y
+			}
+		}
+
+		if spanOpts.SharedConnector {
+			left, right := span, other
+			if left.TopLeft.X > right.TopLeft.X {
+				left, right = right, left
+			}
+			if connected[[2]*d2graph.Object{left, right}] {
+				continue
+			}
+			connected[[2]*d2graph.Object{left, right}] = true
+			x := left.TopLeft.X + left.Width
+			sd.decorations = append(sd.decorations, &d2graph.Decoration{
+				Kind:   SHARED_ACTIVATION_DECORATION,
+				Box:    geo.NewBox(geo.NewPoint(x, top), right.TopLeft.X-x, bottom-top),
+				Object: span,
+				ZIndex: SPAN_Z_INDEX,
+			})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestSharedSpans(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+x; a; b; c
+x -> a.t: start
+a.t -> c
+c -> b.t
+b.t -> x
+`), nil)
+	assert.Nil(t, err)
+	a, _ := g.Root.HasChild([]string{"a"})
+	b, _ := g.Root.HasChild([]string{"b"})
+	at, _ := a.HasChild([]string{"t"})
+	bt, _ := b.HasChild([]string{"t"})
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Spans: map[string]d2sequence.SpanOpts{
+			at.AbsID(): {SharedWith: bt.AbsID(), SharedConnector: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, at.TopLeft.Y, bt.TopLeft.Y)
+	assert.Equal(t, at.TopLeft.Y+at.Height, bt.TopLeft.Y+bt.Height)
+	// the shared height covers the messages of both spans
+	if at.TopLeft.Y >= g.Edges[0].Route[0].Y || at.TopLeft.Y+at.Height <= g.Edges[3].Route[0].Y {
+		t.Fatal("expected the shared spans to cover the messages of both spans")
+	}
+
+	var connector *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.SHARED_ACTIVATION_DECORATION {
+			connector = d
+		}
+	}
+	if connector == nil {
+		t.Fatal("expected a connector between the shared spans")
+	}
+	assert.Equal(t, at.TopLeft.X+at.Width, connector.TopLeft.X)
+	assert.Equal(t, bt.TopLeft.X, connector.TopLeft.X+connector.Width)
+	assert.Equal(t, at.TopLeft.Y, connector.TopLeft.Y)
+	assert.Equal(t, at.Height, connector.Height)
+
+	// spans shared with each other are connected once
+	err = d2sequence.LayoutWithOpts(ctx, g, nil, &d2sequence.ConfigurableOpts{
+		Spans: map[string]d2sequence.SpanOpts{
+			at.AbsID(): {SharedWith: bt.AbsID(), SharedConnector: true},
+			bt.AbsID(): {SharedWith: at.AbsID(), SharedConnector: true},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectors := 0
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.SHARED_ACTIVATION_DECORATION {
+			connectors++
+		}
+	}
+	assert.Equal(t, 1, connectors)
+}
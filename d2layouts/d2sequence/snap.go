@@ -0,0 +1,95 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// snapToGrid moves every coordinate of the laid out graph to the nearest multiple of grid. Boxes snap both of their
+// corners so that their sides are on the grid too. Their unsnapped sizes are kept for the next layout of the graph.
+// Actors, spans and notes snap their center instead, with a width that is a multiple of twice the grid, so that they
+// stay centered on the lifeline. Messages and lifelines keep ending on the side or center of their objects
+func snapToGrid(g *d2graph.Graph, grid float64) {
+	snap := func(v float64) float64 {
+		return math.Round(v/grid) * grid
+	}
+	snapBox := func(b *geo.Box) {
+		if b == nil || b.TopLeft == nil {
+			return
+		}
+		right, bottom := snap(b.TopLeft.X+b.Width), snap(b.TopLeft.Y+b.Height)
+		b.TopLeft.X, b.TopLeft.Y = snap(b.TopLeft.X), snap(b.TopLeft.Y)
+		b.Width, b.Height = right-b.TopLeft.X, bottom-b.TopLeft.Y
+	}
+	snapCentered := func(b *geo.Box) {
+		if b == nil || b.TopLeft == nil {
+			return
+		}
+		centerX := snap(b.TopLeft.X + b.Width/2.)
+		width := math.Max(1, math.Round(b.Width/(2*grid))) * 2 * grid
+		bottom := snap(b.TopLeft.Y + b.Height)
+		b.TopLeft.X, b.TopLeft.Y = centerX-width/2., snap(b.TopLeft.Y)
+		b.Width, b.Height = width, bottom-b.TopLeft.Y
+	}
+
+	// the ends of the edges on a side or the center of their objects, to put them back there once snapped
+	type anchor struct {
+		obj *d2graph.Object
+		// -1 for the left side, 0 for the center and 1 for the right side
+		side float64
+	}
+	anchorOf := func(obj *d2graph.Object, x float64) (anchor, bool) {
+		if obj == nil || obj.Box == nil || obj.TopLeft == nil || obj.IsSequenceDiagramGroup() {
+			return anchor{}, false
+		}
+		for _, side := range []float64{-1, 0, 1} {
+			if geo.PrecisionCompare(x, obj.Center().X+side*obj.Width/2., geo.PRECISION) == 0 {
+				return anchor{obj, side}, true
+			}
+		}
+		return anchor{}, false
+	}
+	anchors := make(map[*geo.Point]anchor)
+	for _, edge := range g.Edges {
+		if len(edge.Route) == 0 {
+			continue
+		}
+		if IsLifelineEnd(edge.Dst) {
+			for _, p := range edge.Route {
+				anchors[p] = anchor{edge.Src, 0}
+			}
+			continue
+		}
+		first, last := edge.Route[0], edge.Route[len(edge.Route)-1]
+		if a, ok := anchorOf(edge.Src, first.X); ok {
+			anchors[first] = a
+		}
+		if a, ok := anchorOf(edge.Dst, last.X); ok {
+			anchors[last] = a
+		}
+	}
+
+	snapBox(g.Root.Box)
+	for _, obj := range g.Objects {
+		if obj.IsSequenceDiagramGroup() {
+			snapBox(obj.Box)
+		} else {
+			snapCentered(obj.Box)
+		}
+	}
+	for _, edge := range g.Edges {
+		for _, p := range edge.Route {
+			if a, ok := anchors[p]; ok {
+				p.X = a.obj.Center().X + a.side*a.obj.Width/2.
+			} else {
+				p.X = snap(p.X)
+			}
+			p.Y = snap(p.Y)
+		}
+	}
+	for _, d := range g.Decorations {
+		snapBox(d.Box)
+	}
+}
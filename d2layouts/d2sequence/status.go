@@ -0,0 +1,48 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/util-go/go2"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+type MessageStatus string
+
+const (
+	MessageSuccess MessageStatus = "success"
+	MessageWarning MessageStatus = "warning"
+	MessageError   MessageStatus = "error"
+)
+
+// DefaultStatusColors are the stroke colors of messages by their MessageOpts.Status, see ConfigurableOpts.StatusColors
+var DefaultStatusColors = map[MessageStatus]string{
+	MessageSuccess: "#2E7D32",
+	MessageWarning: "#EF6C00",
+	MessageError:   "#C62828",
+}
+
+// applyMessageStatuses colors the messages with a status that do not declare a stroke, and adds the status
+// to their classes for renderers and tooling to filter them. The strokes and classes of a previous layout are undone
+// before, so changing or clearing a status does not leave them behind, see saveLayoutInputs
+func applyMessageStatuses(messages []*d2graph.Edge, opts *ConfigurableOpts) error {
+	for _, message := range messages {
+		status := opts.messageOpts(message).Status
+		if status == "" {
+			continue
+		}
+		color, has := opts.StatusColors[status]
+		if !has {
+			color, has = DefaultStatusColors[status]
+		}
+		if !has {
+			return edgeErrorf(VALIDATE_STAGE, message, "unknown status %#v on message %s", status, message.AbsID())
+		}
+		if message.Style.Stroke == nil {
+			message.Style.Stroke = &d2graph.Scalar{Value: color}
+		}
+		if !go2.Contains(message.Classes, string(status)) {
+			message.Classes = append(message.Classes, string(status))
+		}
+	}
+	return nil
+}
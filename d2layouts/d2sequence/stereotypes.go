@@ -0,0 +1,62 @@
+package d2sequence
+
+import (
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+func (sd *sequenceDiagram) stereotypeText(actor *d2graph.Object) string {
+	return "«" + sd.actorOpts(actor).Stereotype + "»"
+}
+
+// measureStereotype measures the stereotype of the actor and makes its header taller to fit it above the label
+func (sd *sequenceDiagram) measureStereotype(actor *d2graph.Object) error {
+	if sd.actorOpts(actor).Stereotype == "" {
+		return nil
+	}
+	// drawn like the actor label but not bold
+	mtext := actor.Text()
+	mtext.Text = sd.stereotypeText(actor)
+	mtext.IsBold = false
+	dims, err := sd.measureText(mtext)
+	if err != nil {
+		return err
+	}
+	sd.stereotypes[actor] = dims
+	if !actor.HasOutsideBottomLabel() {
+		actor.Height += float64(dims.Height)
+	}
+	return nil
+}
+
+// placeStereotypes places the stereotypes right above the actor labels, horizontally centered,
+// or above the shape when the label is below it
+// . ┌──────────────┐
+// . │  «boundary»  │
+// . │     api      │
+// . └──────┬───────┘
+func (sd *sequenceDiagram) placeStereotypes() {
+	for _, actor := range sd.actors {
+		dims, has := sd.stereotypes[actor]
+		if !has {
+			continue
+		}
+		width := float64(dims.Width)
+		height := float64(dims.Height)
+		y := actor.TopLeft.Y - height
+		if !actor.HasOutsideBottomLabel() {
+			labelHeight := 0.
+			if actor.HasLabel() {
+				labelHeight = float64(actor.LabelDimensions.Height)
+			}
+			y = actor.Center().Y - labelHeight/2. - height
+		}
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   STEREOTYPE_DECORATION,
+			Box:    geo.NewBox(geo.NewPoint(actor.Center().X-width/2., y), width, height),
+			Label:  sd.stereotypeText(actor),
+			Object: actor,
+			ZIndex: LABEL_Z_INDEX,
+		})
+	}
+}
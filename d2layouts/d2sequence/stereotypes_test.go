@@ -0,0 +1,67 @@
+package d2sequence_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"oss.terrastruct.com/d2/d2compiler"
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2layouts/d2sequence"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+	"oss.terrastruct.com/d2/lib/log"
+)
+
+func TestStereotype(t *testing.T) {
+	g, _, err := d2compiler.Compile("", strings.NewReader(`
+shape: sequence_diagram
+user; api
+user -> api
+`), nil)
+	assert.Nil(t, err)
+	user, _ := g.Root.HasChild([]string{"user"})
+	api, _ := g.Root.HasChild([]string{"api"})
+	for _, actor := range []*d2graph.Object{user, api} {
+		actor.Box = geo.NewBox(nil, 100, 60)
+		actor.LabelDimensions = d2target.TextDimensions{Width: 40, Height: 21}
+	}
+	opts := &d2sequence.ConfigurableOpts{Actors: map[string]d2sequence.ActorOpts{
+		"api": {Stereotype: "boundary"},
+	}}
+
+	ctx := log.WithTB(context.Background(), t, nil)
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var stereotype *d2graph.Decoration
+	for _, d := range g.Decorations {
+		if d.Kind == d2sequence.STEREOTYPE_DECORATION {
+			stereotype = d
+		}
+	}
+	if stereotype == nil {
+		t.Fatal("expected a stereotype")
+	}
+	assert.Equal(t, "«boundary»", stereotype.Label)
+	assert.Equal(t, api, stereotype.Object)
+	assert.Equal(t, 60+stereotype.Height, api.Height)
+	assert.Equal(t, 60., user.Height)
+	// centered above the label
+	assert.Equal(t, api.Center().X, stereotype.Center().X)
+	assert.Equal(t, api.Center().Y-21/2., stereotype.TopLeft.Y+stereotype.Height)
+	if stereotype.TopLeft.Y < api.TopLeft.Y {
+		t.Fatal("expected the stereotype in the header")
+	}
+	// headers are bottom aligned, the shorter header starts lower
+	assert.Equal(t, api.TopLeft.Y+api.Height, user.TopLeft.Y+user.Height)
+
+	// laying out again does not grow the header again
+	if err := d2sequence.LayoutWithOpts(ctx, g, nil, opts); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 60+stereotype.Height, api.Height)
+}
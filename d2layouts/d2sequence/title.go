@@ -0,0 +1,63 @@
+package d2sequence
+
+import (
+	"math"
+
+	"oss.terrastruct.com/d2/d2graph"
+	"oss.terrastruct.com/d2/d2renderers/d2fonts"
+	"oss.terrastruct.com/d2/d2target"
+	"oss.terrastruct.com/d2/lib/geo"
+)
+
+// placeTitle places the title, and the subtitle below it, centered above everything in the sequence diagram,
+// including the frame. The bounds of the diagram grow to the title block so that the content moves down by its height
+// .          Checkout
+// .   an order from the cart
+// . ┌─────┐            ┌─────┐
+// . │  a  │            │  b  │
+// . └──┬──┘            └──┬──┘
+func (sd *sequenceDiagram) placeTitle() error {
+	texts := []*d2target.MText{{
+		Text:     sd.opts.Title,
+		FontSize: d2fonts.FONT_SIZE_XL,
+		IsBold:   true,
+	}}
+	kinds := []string{TITLE_DECORATION}
+	if sd.opts.Subtitle != "" {
+		texts = append(texts, &d2target.MText{
+			Text:     sd.opts.Subtitle,
+			FontSize: d2fonts.FONT_SIZE_M,
+		})
+		kinds = append(kinds, SUBTITLE_DECORATION)
+	}
+	var dims []*d2target.TextDimensions
+	blockWidth := 0.
+	blockHeight := TITLE_MARGIN
+	for i, text := range texts {
+		d, err := sd.measureText(text)
+		if err != nil {
+			return err
+		}
+		dims = append(dims, d)
+		blockWidth = math.Max(blockWidth, float64(d.Width))
+		blockHeight += float64(d.Height)
+		if i > 0 {
+			blockHeight += SUBTITLE_GAP
+		}
+	}
+
+	bounds := sd.getBounds()
+	centerX := bounds.TopLeft.X + bounds.Width/2
+	y := bounds.TopLeft.Y - blockHeight
+	sd.titleBlock = geo.NewBox(geo.NewPoint(centerX-blockWidth/2, y), blockWidth, blockHeight)
+	for i, d := range dims {
+		sd.decorations = append(sd.decorations, &d2graph.Decoration{
+			Kind:   kinds[i],
+			Box:    geo.NewBox(geo.NewPoint(centerX-float64(d.Width)/2, y), float64(d.Width), float64(d.Height)),
+			Label:  texts[i].Text,
+			ZIndex: LABEL_Z_INDEX,
+		})
+		y += float64(d.Height) + SUBTITLE_GAP
+	}
+	return nil
+}
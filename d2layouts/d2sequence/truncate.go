@@ -0,0 +1,46 @@
+package d2sequence
+
+import (
+	"sort"
+
+	"oss.terrastruct.com/d2/d2graph"
+)
+
+// truncateLabels cuts the message labels wider than ConfigurableOpts.TruncateLabels to the longest prefix that fits
+// with an ellipsis
+func (sd *sequenceDiagram) truncateLabels() error {
+	maxWidth := sd.opts.TruncateLabels
+	for _, message := range sd.messages {
+		if message.Label.Value == "" || float64(message.LabelDimensions.Width) <= maxWidth {
+			continue
+		}
+		full := []rune(message.Label.Value)
+		mtext := message.Text()
+		var measureErr error
+		// the number of runes kept, the first prefix that does not fit minus one
+		kept := sort.Search(len(full), func(n int) bool {
+			mtext.Text = string(full[:n+1]) + ELLIPSIS
+			dims, err := sd.measureText(mtext)
+			if err != nil {
+				measureErr = err
+				return true
+			}
+			return float64(dims.Width) > maxWidth
+		})
+		if measureErr != nil {
+			return measureErr
+		}
+
+		mtext.Text = string(full[:kept]) + ELLIPSIS
+		dims, err := sd.measureText(mtext)
+		if err != nil {
+			return err
+		}
+		if message.Tooltip == nil {
+			message.Tooltip = &d2graph.Scalar{Value: message.Label.Value}
+		}
+		message.Label.Value = mtext.Text
+		message.LabelDimensions = *dims
+	}
+	return nil
+}
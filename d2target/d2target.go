@@ -582,6 +582,9 @@ type Connection struct {
 	Stroke       string  `json:"stroke"`
 	Fill         string  `json:"fill,omitempty"`
 	BorderRadius float64 `json:"borderRadius,omitempty"`
+	// StrokeGradient fades the line from one color to another along its route, renderers that do not draw
+	// gradients use Stroke
+	StrokeGradient *Gradient `json:"strokeGradient,omitempty"`
 
 	Text
 	LabelPosition   string  `json:"labelPosition"`
@@ -597,6 +600,12 @@ type Connection struct {
 	ZIndex int `json:"zIndex"`
 }
 
+// Gradient is a color transition from the start to the end of a connection route
+type Gradient struct {
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
 func BaseConnection() *Connection {
 	return &Connection{
 		SrcArrow:     NoArrowhead,
@@ -1,6 +1,9 @@
 package geo
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 type Box struct {
 	TopLeft *Point
@@ -89,3 +92,36 @@ func (b1 Box) Overlaps(b2 Box) bool {
 	return (b1.TopLeft.X < (b2.TopLeft.X + b2.Width)) && ((b1.TopLeft.X + b1.Width) > b2.TopLeft.X) &&
 		(b1.TopLeft.Y < (b2.TopLeft.Y + b2.Height)) && ((b1.TopLeft.Y + b1.Height) > b2.TopLeft.Y)
 }
+
+// FitWithin returns a copy of the box shrunk to fit within maxWidth and maxHeight, keeping its aspect ratio.
+// A box that already fits, or limits that are not positive, keep its size
+func (b *Box) FitWithin(maxWidth, maxHeight float64) *Box {
+	if maxWidth <= 0 || maxHeight <= 0 {
+		return b.resized(b.Width, b.Height)
+	}
+	scale := math.Min(maxWidth/b.Width, maxHeight/b.Height)
+	if b.Width == 0 || b.Height == 0 || scale >= 1 {
+		return b.resized(b.Width, b.Height)
+	}
+	return b.resized(b.Width*scale, b.Height*scale)
+}
+
+// ScaleToWidth returns a copy of the box with the given width and the height that keeps its aspect ratio.
+// A width that is not positive keeps its size
+func (b *Box) ScaleToWidth(width float64) *Box {
+	if width <= 0 {
+		return b.resized(b.Width, b.Height)
+	}
+	if b.Width == 0 {
+		return b.resized(width, b.Height)
+	}
+	return b.resized(width, b.Height*width/b.Width)
+}
+
+func (b *Box) resized(width, height float64) *Box {
+	var tl *Point
+	if b.TopLeft != nil {
+		tl = b.TopLeft.Copy()
+	}
+	return NewBox(tl, width, height)
+}
@@ -0,0 +1,60 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBoxFitWithin(t *testing.T) {
+	b := NewBox(NewPoint(10, 20), 400, 200)
+
+	fit := b.FitWithin(100, 100)
+	if fit.Width != 100 || fit.Height != 50 {
+		t.Fatalf("Expected a 100x50 box, got %vx%v", fit.Width, fit.Height)
+	}
+	if fit.TopLeft.X != 10 || fit.TopLeft.Y != 20 || fit.TopLeft == b.TopLeft {
+		t.Fatalf("Expected a copy of the top left, got %v", fit.TopLeft.ToString())
+	}
+
+	fit = b.FitWithin(1000, 40)
+	if fit.Height != 40 || math.Abs(fit.Width/fit.Height-2) > PRECISION {
+		t.Fatalf("Expected a box limited by the height with the same aspect ratio, got %vx%v", fit.Width, fit.Height)
+	}
+
+	fit = b.FitWithin(1000, 1000)
+	if fit.Width != 400 || fit.Height != 200 {
+		t.Fatalf("Expected a box that fits to keep its size, got %vx%v", fit.Width, fit.Height)
+	}
+
+	fit = NewBox(nil, 300, 900).FitWithin(100, 100)
+	if fit.TopLeft != nil || fit.Width > 100 || fit.Height > 100 || math.Abs(fit.Height/fit.Width-3) > PRECISION {
+		t.Fatalf("Expected a box within 100x100 with the same aspect ratio, got %vx%v", fit.Width, fit.Height)
+	}
+
+	fit = b.FitWithin(0, -10)
+	if fit.Width != 400 || fit.Height != 200 {
+		t.Fatalf("Expected limits that are not positive to keep the size, got %vx%v", fit.Width, fit.Height)
+	}
+}
+
+func TestBoxScaleToWidth(t *testing.T) {
+	b := NewBox(NewPoint(0, 0), 300, 200)
+
+	scaled := b.ScaleToWidth(150)
+	if scaled.Width != 150 || scaled.Height != 100 {
+		t.Fatalf("Expected a 150x100 box, got %vx%v", scaled.Width, scaled.Height)
+	}
+
+	scaled = b.ScaleToWidth(600)
+	if scaled.Width != 600 || scaled.Height != 400 {
+		t.Fatalf("Expected a 600x400 box, got %vx%v", scaled.Width, scaled.Height)
+	}
+	if b.Width != 300 || b.Height != 200 {
+		t.Fatal("Expected the original box to be unchanged")
+	}
+
+	scaled = b.ScaleToWidth(-5)
+	if scaled.Width != 300 || scaled.Height != 200 {
+		t.Fatalf("Expected a width that is not positive to keep the size, got %vx%v", scaled.Width, scaled.Height)
+	}
+}